@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
+	"gopkg.in/yaml.v3"
+)
+
+// A collectorConfig holds the settings of a collector, as given
+// by flags. Fields are named like the flags.
+type collectorConfig struct {
+	RuleComments       string
+	CounterNames       string
+	SetNames           string
+	QuotaNames         string
+	RuleText           string
+	RuleCommentFormat  string
+	RuleDuplicates     string
+	SetElementCounters string
+	SetElementLimit    int
+	SetElementTop      bool
+
+	// Filters come from the configuration file, and are applied
+	// before the name filters above.
+	Filters []filterEntry
+}
+
+// newCollector validates the configuration and creates a collector
+// of the namespace. Rule counters are made monotonic by counters, and
+// metadata is kept in cache, if not nil.
+func (cfg *collectorConfig) newCollector(conn nftcollector.Conn, netns string, counters *nftcollector.CounterTracker, cache *nftcollector.MetadataCache) (*nftcollector.Collector, error) {
+	rcre, err := compileFilter(cfg.RuleComments)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule-comments: %v", err)
+	}
+	cnre, err := compileFilter(cfg.CounterNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid counter-names: %v", err)
+	}
+	stre, err := compileFilter(cfg.SetNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid set-names: %v", err)
+	}
+	qnre, err := compileFilter(cfg.QuotaNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quota-names: %v", err)
+	}
+	switch nftcollector.RuleTextMode(cfg.RuleText) {
+	case nftcollector.RuleTextNone, nftcollector.RuleTextPlain, nftcollector.RuleTextHash:
+	default:
+		return nil, fmt.Errorf("invalid rule-text: %q", cfg.RuleText)
+	}
+	switch nftcollector.RuleCommentFormat(cfg.RuleCommentFormat) {
+	case nftcollector.RuleCommentPlain, nftcollector.RuleCommentJSON:
+	default:
+		return nil, fmt.Errorf("invalid rule-comment-format: %q", cfg.RuleCommentFormat)
+	}
+	switch nftcollector.RuleDuplicatesMode(cfg.RuleDuplicates) {
+	case nftcollector.RuleDuplicatesSum, nftcollector.RuleDuplicatesHandle, nftcollector.RuleDuplicatesPosition:
+	default:
+		return nil, fmt.Errorf("invalid rule-duplicates: %q", cfg.RuleDuplicates)
+	}
+	sere, err := compileFilter(cfg.SetElementCounters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid set-element-counters: %v", err)
+	}
+
+	filters, err := compileFilterEntries(cfg.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := nftcollector.New(conn, nftcollector.Options{
+		RuleComments:       rcre.MatchString,
+		CounterNames:       cnre.MatchString,
+		SetNames:           stre.MatchString,
+		QuotaNames:         qnre.MatchString,
+		Filters:            filters,
+		RuleLabels:         rcre,
+		RuleText:           nftcollector.RuleTextMode(cfg.RuleText),
+		RuleCommentFormat:  nftcollector.RuleCommentFormat(cfg.RuleCommentFormat),
+		RuleDuplicates:     nftcollector.RuleDuplicatesMode(cfg.RuleDuplicates),
+		SetElementCounters: sere.MatchString,
+		SetElementLimit:    cfg.SetElementLimit,
+		SetElementTop:      cfg.SetElementTop,
+		Counters:           counters,
+		Cache:              cache,
+		NetNS:              netns,
+		Metrics:            selfMetrics,
+	})
+	if err != nil {
+		// The modes were checked above, so it's the labels.
+		return nil, fmt.Errorf("invalid rule-comments: %v", err)
+	}
+	return c, nil
+}
+
+// set changes the field named like a flag.
+func (cfg *collectorConfig) set(name, value string) error {
+	switch name {
+	case "rule-comments":
+		cfg.RuleComments = value
+	case "counter-names":
+		cfg.CounterNames = value
+	case "set-names":
+		cfg.SetNames = value
+	case "quota-names":
+		cfg.QuotaNames = value
+	case "rule-text":
+		cfg.RuleText = value
+	case "rule-comment-format":
+		cfg.RuleCommentFormat = value
+	case "rule-duplicates":
+		cfg.RuleDuplicates = value
+	case "set-element-counters":
+		cfg.SetElementCounters = value
+	case "set-element-limit":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		cfg.SetElementLimit = v
+	case "set-element-top":
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		cfg.SetElementTop = v
+	default:
+		return fmt.Errorf("unknown setting: %q", name)
+	}
+	return nil
+}
+
+// compileFilter compiles a fully anchored regular expression.
+func compileFilter(s string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(" + s + ")$")
+}
+
+// A moduleFlag is a flag.Value collecting probe modules. Each value
+// is "<module>.<setting>=<value>", where setting is the name of a
+// flag. Unset settings are inherited from the flags.
+type moduleFlag map[string]map[string]string
+
+// String implements flag.Value.
+func (f moduleFlag) String() string {
+	var ss []string
+	for module, settings := range f {
+		for name, value := range settings {
+			ss = append(ss, module+"."+name+"="+value)
+		}
+	}
+	sort.Strings(ss)
+	return strings.Join(ss, " ")
+}
+
+// Set implements flag.Value.
+func (f moduleFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	j := strings.IndexByte(s, '.')
+	if i < 0 || j < 0 || j > i || j == 0 {
+		return fmt.Errorf("expected <module>.<setting>=<value>: %q", s)
+	}
+
+	module, name, value := s[:j], s[j+1:i], s[i+1:]
+	if err := (&collectorConfig{}).set(name, value); err != nil {
+		return err
+	}
+	if f[module] == nil {
+		f[module] = map[string]string{}
+	}
+	f[module][name] = value
+	return nil
+}
+
+// configs returns the configuration of each module, based on def.
+func (f moduleFlag) configs(def collectorConfig) map[string]collectorConfig {
+	cfgs := map[string]collectorConfig{}
+	for module, settings := range f {
+		cfg := def
+		for name, value := range settings {
+			// Validated in Set.
+			cfg.set(name, value)
+		}
+		cfgs[module] = cfg
+	}
+	return cfgs
+}
+
+// A configFile is the contents of -config.file.
+type configFile struct {
+	// Filters select what is exported, in addition to the flags.
+	Filters []filterEntry `yaml:"filters"`
+
+	// Modules are the modules of /probe.
+	Modules map[string]moduleEntry `yaml:"modules"`
+}
+
+// A moduleEntry is a /probe module in a configuration file.
+type moduleEntry struct {
+	// Settings are named like flags, as in -probe-module.
+	Settings map[string]string `yaml:",inline"`
+
+	// Filters are applied before those at the top level.
+	Filters []filterEntry `yaml:"filters"`
+}
+
+// A filterEntry includes or excludes objects. The first matching
+// entry decides. Objects not matched by any entry are filtered by the
+// name flags, e.g. -set-names.
+type filterEntry struct {
+	Action string   `yaml:"action"` // "include" or "exclude".
+	Kinds  []string `yaml:"kinds"`  // Empty means all.
+
+	// Family is a table family name, e.g. "inet". Empty means all.
+	Family string `yaml:"family"`
+
+	// Table, Chain and Name are regular expressions (fully
+	// anchored). Empty means all. Chain only applies to rules, and
+	// Name is the comment of rules.
+	Table string `yaml:"table"`
+	Chain string `yaml:"chain"`
+	Name  string `yaml:"name"`
+}
+
+// readConfigFile parses a configuration file. Unknown fields are
+// errors.
+func readConfigFile(path string) (*configFile, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f configFile
+	dec := yaml.NewDecoder(bytes.NewReader(bs))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	return &f, nil
+}
+
+// apply returns the configuration and the module configurations,
+// given the configuration from flags. Modules from flags override
+// settings of the file.
+func (f *configFile) apply(def collectorConfig, flagModules moduleFlag) (collectorConfig, map[string]collectorConfig, error) {
+	cfg := def
+	cfg.Filters = append(append([]filterEntry(nil), f.Filters...), def.Filters...)
+	if _, err := compileFilterEntries(cfg.Filters); err != nil {
+		return cfg, nil, err
+	}
+
+	modules := flagModules.configs(cfg)
+	for name, m := range f.Modules {
+		mcfg := cfg
+		for k, v := range m.Settings {
+			if err := mcfg.set(k, v); err != nil {
+				return cfg, nil, fmt.Errorf("modules.%s: %v", name, err)
+			}
+		}
+		for k, v := range flagModules[name] {
+			// Validated in moduleFlag.Set.
+			mcfg.set(k, v)
+		}
+		mcfg.Filters = append(append([]filterEntry(nil), m.Filters...), cfg.Filters...)
+		if _, err := compileFilterEntries(m.Filters); err != nil {
+			return cfg, nil, fmt.Errorf("modules.%s.%v", name, err)
+		}
+		modules[name] = mcfg
+	}
+
+	return cfg, modules, nil
+}
+
+// compileFilterEntries validates and compiles entries. Errors name
+// the offending entry.
+func compileFilterEntries(es []filterEntry) ([]nftcollector.Filter, error) {
+	var fs []nftcollector.Filter
+	for i, e := range es {
+		f, err := e.compile()
+		if err != nil {
+			return nil, fmt.Errorf("filters[%d]: %v", i, err)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// compile validates and compiles an entry.
+func (e *filterEntry) compile() (nftcollector.Filter, error) {
+	var f nftcollector.Filter
+	switch e.Action {
+	case "include":
+		f.Include = true
+	case "exclude":
+	default:
+		return f, fmt.Errorf("action must be include or exclude: %q", e.Action)
+	}
+
+	for _, k := range e.Kinds {
+		switch nftcollector.ObjectKind(k) {
+		case nftcollector.KindRules, nftcollector.KindCounters, nftcollector.KindSets, nftcollector.KindQuotas:
+		default:
+			return f, fmt.Errorf("unknown kind: %q", k)
+		}
+		if f.Kinds == nil {
+			f.Kinds = map[nftcollector.ObjectKind]bool{}
+		}
+		f.Kinds[nftcollector.ObjectKind(k)] = true
+	}
+
+	switch e.Family {
+	case "", "inet", "ip", "ip6", "arp", "netdev", "bridge":
+		f.Family = e.Family
+	default:
+		return f, fmt.Errorf("unknown family: %q", e.Family)
+	}
+
+	if e.Chain != "" && (len(f.Kinds) != 1 || !f.Kinds[nftcollector.KindRules]) {
+		return f, fmt.Errorf("chain requires kinds: [rules]")
+	}
+
+	for _, re := range []struct {
+		name string
+		s    string
+		re   **regexp.Regexp
+	}{
+		{"table", e.Table, &f.Table},
+		{"chain", e.Chain, &f.Chain},
+		{"name", e.Name, &f.Name},
+	} {
+		if re.s == "" {
+			continue
+		}
+		var err error
+		*re.re, err = compileFilter(re.s)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s: %v", re.name, err)
+		}
+	}
+
+	return f, nil
+}
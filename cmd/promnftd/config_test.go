@@ -0,0 +1,202 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
+)
+
+// testCollectorConfig is the default configuration of the flags.
+var testCollectorConfig = collectorConfig{
+	RuleComments:    ".*",
+	CounterNames:    ".*",
+	SetNames:        ".*",
+	QuotaNames:      ".*",
+	SetElementLimit: 100,
+}
+
+func TestCollectorConfigNewCollector(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		cfg := testCollectorConfig
+		cfg.RuleText = "hash"
+
+		table := &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}
+		conn := &fakeNFTConn{
+			tables: []*nftables.Table{table},
+			chains: []*nftables.Chain{{Name: "input", Table: table}},
+			objs: map[string][]nftables.Obj{
+				"filter": {&nftables.CounterObj{Table: table, Name: "anything"}},
+			},
+			rules: map[string][]*nftcollector.Rule{
+				"filter/input": {
+					{Rule: &nftables.Rule{Table: table, Chain: &nftables.Chain{Name: "input"},
+						Exprs: []expr.Any{&expr.Counter{}}}},
+				},
+			},
+		}
+		c, err := cfg.newCollector(conn, "", nil, nil)
+		if err != nil {
+			t.Fatalf("newCollector failed: %v", err)
+		}
+		// The rule has no comment, so it's only exported with a rule text.
+		if got, want := testutil.CollectAndCount(c, "nftables_rule_packet_count"), 1; got != want {
+			t.Errorf("CollectAndCount(rule): got %d, want %d", got, want)
+		}
+		if got, want := testutil.CollectAndCount(c, "nftables_counter_packet_count"), 1; got != want {
+			t.Errorf("CollectAndCount(counter): got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		cfg := testCollectorConfig
+		cfg.SetNames = "("
+
+		_, err := cfg.newCollector(&fakeNFTConn{}, "", nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "set-names") {
+			t.Errorf("newCollector: got %v, want set-names error", err)
+		}
+	})
+	t.Run("reservedRuleLabel", func(t *testing.T) {
+		cfg := testCollectorConfig
+		cfg.RuleComments = "(?P<chain>.*)"
+
+		_, err := cfg.newCollector(&fakeNFTConn{}, "", nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "reserved label name") {
+			t.Errorf("newCollector: got %v, want reserved label name error", err)
+		}
+	})
+}
+
+func TestModuleFlag(t *testing.T) {
+	f := moduleFlag{}
+	for _, s := range []string{"web.counter-names=web-.*", "web.set-element-limit=5", "empty.rule-comments="} {
+		if err := f.Set(s); err != nil {
+			t.Fatalf("Set(%q) failed: %v", s, err)
+		}
+	}
+
+	if got, want := f.String(), "empty.rule-comments= web.counter-names=web-.* web.set-element-limit=5"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+
+	got := f.configs(testCollectorConfig)
+	web := testCollectorConfig
+	web.CounterNames = "web-.*"
+	web.SetElementLimit = 5
+	empty := testCollectorConfig
+	empty.RuleComments = ""
+	want := map[string]collectorConfig{"web": web, "empty": empty}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("configs: got %+v, want %+v", got, want)
+	}
+
+	for _, s := range []string{"web", "web=a", ".rule-comments=a", "web.unknown=a", "web.set-element-top=maybe"} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q): got nil error, want error", s)
+		}
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "config_test")
+	if err != nil {
+		t.Fatalf("TempFile failed: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(`
+filters:
+  - action: exclude
+    kinds: [sets]
+    family: ip
+    table: nat
+modules:
+  web:
+    counter-names: web.*
+    set-element-limit: 5
+    filters:
+      - action: include
+        kinds: [sets]
+        table: web
+`); err != nil {
+		t.Fatalf("WriteString failed: %v", err)
+	}
+	f.Close()
+
+	cf, err := readConfigFile(f.Name())
+	if err != nil {
+		t.Fatalf("readConfigFile failed: %v", err)
+	}
+
+	flagModules := moduleFlag{}
+	if err := flagModules.Set("web.set-element-limit=7"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	cfg, modules, err := cf.apply(testCollectorConfig, flagModules)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	nat := filterEntry{Action: "exclude", Kinds: []string{"sets"}, Family: "ip", Table: "nat"}
+	wantCfg := testCollectorConfig
+	wantCfg.Filters = []filterEntry{nat}
+	if !reflect.DeepEqual(cfg, wantCfg) {
+		t.Errorf("apply config: got %+v, want %+v", cfg, wantCfg)
+	}
+
+	web := wantCfg
+	web.CounterNames = "web.*"
+	web.SetElementLimit = 7
+	web.Filters = []filterEntry{{Action: "include", Kinds: []string{"sets"}, Table: "web"}, nat}
+	if want := map[string]collectorConfig{"web": web}; !reflect.DeepEqual(modules, want) {
+		t.Errorf("apply modules: got %+v, want %+v", modules, want)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tsts := []struct {
+		name string
+		f    configFile
+		want string
+	}{
+		{"action", configFile{Filters: []filterEntry{{Action: "include"}, {Action: "drop"}}}, `filters[1]: action must be include or exclude: "drop"`},
+		{"kind", configFile{Filters: []filterEntry{{Action: "include", Kinds: []string{"chains"}}}}, `filters[0]: unknown kind: "chains"`},
+		{"family", configFile{Filters: []filterEntry{{Action: "include", Family: "ipv4"}}}, `filters[0]: unknown family: "ipv4"`},
+		{"chain", configFile{Filters: []filterEntry{{Action: "include", Kinds: []string{"sets"}, Chain: "input"}}}, `filters[0]: chain requires kinds: [rules]`},
+		{"table", configFile{Filters: []filterEntry{{Action: "include", Table: "("}}}, `filters[0]: invalid table: `},
+		{"moduleSetting", configFile{Modules: map[string]moduleEntry{"web": {Settings: map[string]string{"set-name": "a"}}}}, `modules.web: unknown setting: "set-name"`},
+		{"moduleFilter", configFile{Modules: map[string]moduleEntry{"web": {Filters: []filterEntry{{Action: "include", Name: "["}}}}}, `modules.web.filters[0]: invalid name: `},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			_, _, err := tst.f.apply(testCollectorConfig, moduleFlag{})
+			if err == nil || !strings.HasPrefix(err.Error(), tst.want) {
+				t.Errorf("apply: got %v, want %q", err, tst.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileUnknownField(t *testing.T) {
+	f, err := ioutil.TempFile("", "config_test")
+	if err != nil {
+		t.Fatalf("TempFile failed: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString("filter: []\n"); err != nil {
+		t.Fatalf("WriteString failed: %v", err)
+	}
+	f.Close()
+
+	if _, err := readConfigFile(f.Name()); err == nil || !strings.Contains(err.Error(), "filter") {
+		t.Errorf("readConfigFile: got %v, want unknown field error", err)
+	}
+}
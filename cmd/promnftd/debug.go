@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// serveDebug handles /debug/ruleset. It takes the same parameters
+// as /probe. With format=json, the report is JSON, and otherwise HTML.
+func (h *probeHandler) serveDebug(w http.ResponseWriter, r *http.Request) {
+	coll, cl, ok := h.collector(w, r)
+	if !ok {
+		return
+	}
+	defer cl.Close()
+
+	rep, err := coll.Report()
+	if err != nil {
+		selfMetrics.CollectionFailures.WithLabelValues(r.URL.Query().Get("netns")).Inc()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			log.Printf("Writing debug report failed: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := debugTemplate.Execute(w, rep); err != nil {
+		log.Printf("Writing debug report failed: %v", err)
+	}
+}
+
+// debugTemplate renders an nftcollector.RulesetReport.
+var debugTemplate = template.Must(template.New("ruleset").Parse(`<!DOCTYPE html>
+<html>
+<head><title>nftables rule set</title></head>
+<body>
+<h1>nftables rule set</h1>
+<p>What is exported, and why not. Add <code>format=json</code> to the query for JSON.</p>
+{{range .Tables}}
+<h2>table {{.Family}} {{.Name}}{{with .Flags}} ({{.}}){{end}}</h2>
+{{range .Errors}}<p><strong>Error:</strong> {{.}}</p>{{end}}
+{{if .Counters}}<h3>Counters</h3>
+<table>
+<tr><th>Name</th><th>Exported</th><th>Reason</th></tr>
+{{range .Counters}}<tr><td>{{.Name}}</td><td>{{.Exported}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Quotas}}<h3>Quotas</h3>
+<table>
+<tr><th>Name</th><th>Exported</th><th>Reason</th></tr>
+{{range .Quotas}}<tr><td>{{.Name}}</td><td>{{.Exported}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Sets}}<h3>Sets</h3>
+<table>
+<tr><th>Name</th><th>Size</th><th>Element counters</th><th>Exported</th><th>Reason</th></tr>
+{{range .Sets}}<tr><td>{{.Name}}</td><td>{{.Size}}</td><td>{{.ElementCounters}}</td><td>{{.Exported}}</td><td>{{.Reason}}{{with .Error}}: {{.}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{range .Chains}}
+<h3>chain {{.Name}}{{if .Type}} ({{.Type}} hook {{.Hook}}, priority {{.Priority}}, policy {{.Policy}}){{end}}</h3>
+<table>
+<tr><th>Handle</th><th>Comment</th><th>Counter</th><th>Exported</th><th>Reason</th></tr>
+{{range .Rules}}<tr><td>{{.Handle}}</td><td>{{.Comment}}</td><td>{{.Counter}}</td><td>{{.Exported}}</td><td>{{.Reason}}{{with .Error}}: {{.}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
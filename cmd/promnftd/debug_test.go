@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+)
+
+func TestProbeHandlerServeDebug(t *testing.T) {
+	table := &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}
+	conn := &fakeNFTConn{
+		tables: []*nftables.Table{table},
+		chains: []*nftables.Chain{
+			{Name: "input", Table: table, Hooknum: nftables.ChainHookInput, Type: nftables.ChainTypeFilter},
+			{Name: "web", Table: table},
+		},
+		objs: map[string][]nftables.Obj{
+			"table1": []nftables.Obj{
+				&nftables.CounterObj{Name: "web1"},
+				&nftables.CounterObj{Name: "db1"},
+			},
+		},
+	}
+	web := testCollectorConfig
+	web.CounterNames = "web.*"
+
+	h, err := newProbeHandler(conn, testCollectorConfig, map[string]collectorConfig{"web": web}, &netnsSource{procRoot: "/proc"}, nil)
+	if err != nil {
+		t.Fatalf("newProbeHandler failed: %v", err)
+	}
+
+	tsts := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantType   string
+		want       []string
+	}{
+		{"html", "module=web", http.StatusOK, "text/html; charset=utf-8", []string{"<td>db1</td><td>false</td><td>name-filter</td>", "<h3>chain input (filter hook input, priority 0, policy accept)</h3>", "<h3>chain web</h3>"}},
+		{"json", "module=web&format=json", http.StatusOK, "application/json", []string{`"name": "db1",`, `"reason": "name-filter"`}},
+		{"unknownModule", "module=db", http.StatusBadRequest, "text/plain; charset=utf-8", []string{"unknown module"}},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.serveDebug(w, httptest.NewRequest(http.MethodGet, "/debug/ruleset?"+tst.query, nil))
+
+			if w.Code != tst.wantStatus {
+				t.Errorf("serveDebug status: got %v, want %v", w.Code, tst.wantStatus)
+			}
+			if got := w.Header().Get("Content-Type"); got != tst.wantType {
+				t.Errorf("serveDebug Content-Type: got %q, want %q", got, tst.wantType)
+			}
+			for _, want := range tst.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("serveDebug: want %q, got:\n%s", want, w.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tommie/prometheus-nftables-exporter/internal/nfmsg"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
+	"golang.org/x/sys/unix"
+)
+
+// An eventSource receives NFTables netlink notifications. It is
+// implemented by *netlink.Conn.
+type eventSource interface {
+	Receive() ([]netlink.Message, error)
+	Close() error
+}
+
+// newNFTEventSource subscribes to NFTables notifications in the
+// network namespace of the process.
+func newNFTEventSource() (eventSource, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.JoinGroup(unix.NFNLGRP_NFTABLES); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+const (
+	// eventRedialMinBackoff and eventRedialMaxBackoff bound the wait
+	// between attempts to subscribe again after a failure.
+	eventRedialMinBackoff = time.Second
+	eventRedialMaxBackoff = time.Minute
+)
+
+// An eventWatcher counts NFTables notifications, i.e. changes to the
+// rule set. It is a prometheus.Collector.
+type eventWatcher struct {
+	dial       func() (eventSource, error)
+	invalidate func()
+	now        func() time.Time
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu  sync.Mutex
+	src eventSource // Nil while subscribing again.
+
+	events     *prometheus.CounterVec
+	lastChange prometheus.Gauge
+	overflows  prometheus.Counter
+	up         prometheus.Gauge
+}
+
+// newEventWatcher creates a watcher of events from src. If src fails,
+// dial is used to subscribe again. The metadata cache is invalidated
+// through invalidate when notifications may have been lost. Run must
+// be called to process them.
+func newEventWatcher(src eventSource, dial func() (eventSource, error), invalidate func()) *eventWatcher {
+	w := &eventWatcher{
+		dial:       dial,
+		invalidate: invalidate,
+		now:        time.Now,
+		minBackoff: eventRedialMinBackoff,
+		maxBackoff: eventRedialMaxBackoff,
+		src:        src,
+
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nftables",
+			Name:      "ruleset_events",
+			Help:      "Number of rule set change notifications received.",
+		}, []string{"family", "table", "type"}),
+		lastChange: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "nftables",
+			Name:      "ruleset_last_change_timestamp_seconds",
+			Help:      "Timestamp of the last rule set change notification.",
+		}),
+		overflows: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "nftables",
+			Name:      "ruleset_events_overflows",
+			Help:      "Number of times notifications were lost because the socket buffer was full.",
+		}),
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "nftables",
+			Name:      "ruleset_events_up",
+			Help:      "Whether the exporter is subscribed to rule set change notifications. Value is 0 or 1.",
+		}),
+	}
+	w.up.Set(1)
+	return w
+}
+
+// Describe implements prometheus.Collector.
+func (w *eventWatcher) Describe(ch chan<- *prometheus.Desc) {
+	w.events.Describe(ch)
+	w.lastChange.Describe(ch)
+	w.overflows.Describe(ch)
+	w.up.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (w *eventWatcher) Collect(ch chan<- prometheus.Metric) {
+	w.events.Collect(ch)
+	w.lastChange.Collect(ch)
+	w.overflows.Collect(ch)
+	w.up.Collect(ch)
+}
+
+// run processes events until the context is cancelled. When the
+// source fails, it subscribes again. The source is closed on return.
+func (w *eventWatcher) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		w.closeSource()
+	}()
+
+	for {
+		w.mu.Lock()
+		src := w.src
+		w.mu.Unlock()
+
+		msgs, err := src.Receive()
+		if ctx.Err() != nil {
+			return
+		} else if errors.Is(err, unix.ENOBUFS) {
+			// The socket is still usable, but some notifications
+			// were dropped by the kernel.
+			log.Printf("Lost NFTables events: %v", err)
+			w.overflows.Inc()
+			w.invalidate()
+			continue
+		} else if err != nil {
+			log.Printf("Receiving NFTables events failed: %v", err)
+			if !w.redial(ctx) {
+				return
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			if err := w.handle(msg); err != nil {
+				log.Printf("%v (ignored)", err)
+			}
+		}
+	}
+}
+
+// redial closes the failed source and subscribes again, with
+// exponential backoff. It returns false if the context is done first.
+func (w *eventWatcher) redial(ctx context.Context) bool {
+	w.up.Set(0)
+	w.closeSource()
+
+	backoff := w.minBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+
+		src, err := w.dial()
+		if err != nil {
+			log.Printf("Subscribing to NFTables events failed: %v", err)
+			if backoff *= 2; backoff > w.maxBackoff {
+				backoff = w.maxBackoff
+			}
+			continue
+		}
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if ctx.Err() != nil {
+			src.Close()
+			return false
+		}
+		w.src = src
+		w.up.Set(1)
+
+		// Changes while we weren't subscribed went unnoticed.
+		w.invalidate()
+
+		return true
+	}
+}
+
+// closeSource closes the current source, if any.
+func (w *eventWatcher) closeSource() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.src != nil {
+		w.src.Close()
+		w.src = nil
+	}
+}
+
+// handle counts a single notification.
+func (w *eventWatcher) handle(msg netlink.Message) error {
+	if msg.Header.Type>>8 != unix.NFNL_SUBSYS_NFTABLES {
+		return nil
+	}
+	typ := uint16(msg.Header.Type & 0xFF)
+
+	ad, err := nfmsg.NewDecoder(msg)
+	if err != nil {
+		return err
+	}
+
+	var family, table string
+	if typ != unix.NFT_MSG_NEWGEN {
+		family = nftcollector.TableFamilyString(nftables.TableFamily(msg.Data[0]))
+
+		// All other messages carry the table name in an
+		// attribute of the same type as NFTA_TABLE_NAME, e.g.
+		// NFTA_RULE_TABLE and NFTA_SET_TABLE.
+		for ad.Next() {
+			if ad.Type() == unix.NFTA_TABLE_NAME {
+				table = ad.String()
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return fmt.Errorf("decoding NFTables event: %v", err)
+		}
+	}
+
+	w.events.WithLabelValues(family, table, eventTypeString(typ)).Inc()
+	w.lastChange.Set(float64(w.now().UnixNano()) / 1e9)
+
+	return nil
+}
+
+// eventTypeString returns the lower-cased name of an NFT_MSG_*
+// message type.
+func eventTypeString(typ uint16) string {
+	switch typ {
+	case unix.NFT_MSG_NEWTABLE:
+		return "newtable"
+	case unix.NFT_MSG_DELTABLE:
+		return "deltable"
+	case unix.NFT_MSG_NEWCHAIN:
+		return "newchain"
+	case unix.NFT_MSG_DELCHAIN:
+		return "delchain"
+	case unix.NFT_MSG_NEWRULE:
+		return "newrule"
+	case unix.NFT_MSG_DELRULE:
+		return "delrule"
+	case unix.NFT_MSG_NEWSET:
+		return "newset"
+	case unix.NFT_MSG_DELSET:
+		return "delset"
+	case unix.NFT_MSG_NEWSETELEM:
+		return "newsetelem"
+	case unix.NFT_MSG_DELSETELEM:
+		return "delsetelem"
+	case unix.NFT_MSG_NEWGEN:
+		return "newgen"
+	case unix.NFT_MSG_NEWOBJ:
+		return "newobj"
+	case unix.NFT_MSG_DELOBJ:
+		return "delobj"
+	default:
+		return fmt.Sprintf("unknown(%d)", typ)
+	}
+}
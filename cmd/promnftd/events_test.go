@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/sys/unix"
+)
+
+func TestEventWatcher(t *testing.T) {
+	inet := netlink.Message{Data: []byte{byte(nftables.TableFamilyINet)}}
+	src := &fakeEventSource{
+		msgs: make(chan []netlink.Message, 2),
+		done: make(chan struct{}),
+	}
+	src.msgs <- []netlink.Message{
+		makeNLReply(inet, unix.NFT_MSG_NEWTABLE, func(ae *netlink.AttributeEncoder) {
+			ae.String(unix.NFTA_TABLE_NAME, "filter")
+		}),
+		makeNLReply(inet, unix.NFT_MSG_NEWRULE, func(ae *netlink.AttributeEncoder) {
+			ae.String(unix.NFTA_RULE_TABLE, "filter")
+			ae.String(unix.NFTA_RULE_CHAIN, "input")
+		}),
+		makeNLReply(inet, unix.NFT_MSG_NEWRULE, func(ae *netlink.AttributeEncoder) {
+			ae.String(unix.NFTA_RULE_TABLE, "filter")
+			ae.String(unix.NFTA_RULE_CHAIN, "input")
+		}),
+	}
+	src.msgs <- []netlink.Message{
+		makeNLReply(netlink.Message{Data: []byte{unix.AF_UNSPEC}}, unix.NFT_MSG_NEWGEN, func(ae *netlink.AttributeEncoder) {
+			ae.Uint32(unix.NFTA_GEN_ID, 42)
+		}),
+	}
+	close(src.msgs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Stops when the closed source is dialed again.
+	w := newEventWatcher(src, func() (eventSource, error) {
+		cancel()
+		return nil, errors.New("mocked")
+	}, func() {})
+	w.now = func() time.Time { return time.Unix(1600000000, 0) }
+	w.minBackoff = time.Millisecond
+
+	w.run(ctx)
+
+	want := `
+# HELP nftables_ruleset_events Number of rule set change notifications received.
+# TYPE nftables_ruleset_events counter
+nftables_ruleset_events{family="",table="",type="newgen"} 1
+nftables_ruleset_events{family="inet",table="filter",type="newrule"} 2
+nftables_ruleset_events{family="inet",table="filter",type="newtable"} 1
+# HELP nftables_ruleset_last_change_timestamp_seconds Timestamp of the last rule set change notification.
+# TYPE nftables_ruleset_last_change_timestamp_seconds gauge
+nftables_ruleset_last_change_timestamp_seconds 1.6e+09
+`
+	if err := testutil.CollectAndCompare(w, strings.NewReader(want), "nftables_ruleset_events", "nftables_ruleset_last_change_timestamp_seconds"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+}
+
+func TestEventWatcherCancel(t *testing.T) {
+	src := &fakeEventSource{
+		msgs: make(chan []netlink.Message),
+		done: make(chan struct{}),
+	}
+	w := newEventWatcher(src, func() (eventSource, error) {
+		t.Fatal("dial called")
+		return nil, nil
+	}, func() {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w.run(ctx)
+}
+
+func TestEventWatcherRecover(t *testing.T) {
+	inet := netlink.Message{Data: []byte{byte(nftables.TableFamilyINet)}}
+	newTable := []netlink.Message{
+		makeNLReply(inet, unix.NFT_MSG_NEWTABLE, func(ae *netlink.AttributeEncoder) {
+			ae.String(unix.NFTA_TABLE_NAME, "filter")
+		}),
+	}
+
+	src := &fakeEventSource{
+		errs: []error{&netlink.OpError{Op: "receive", Err: unix.ENOBUFS}},
+		msgs: make(chan []netlink.Message, 1),
+		done: make(chan struct{}),
+	}
+	src.msgs <- newTable
+	close(src.msgs)
+
+	redialed := &fakeEventSource{
+		msgs: make(chan []netlink.Message, 1),
+		done: make(chan struct{}),
+	}
+	redialed.msgs <- newTable
+	close(redialed.msgs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var dials, invalidations int
+	w := newEventWatcher(src, func() (eventSource, error) {
+		dials++
+		switch dials {
+		case 1:
+			return nil, errors.New("mocked")
+		case 2:
+			return redialed, nil
+		default:
+			cancel()
+			return nil, errors.New("mocked")
+		}
+	}, func() { invalidations++ })
+	w.minBackoff = time.Millisecond
+	w.maxBackoff = time.Millisecond
+
+	w.run(ctx)
+
+	if dials != 3 {
+		t.Errorf("dials: got %d, want 3", dials)
+	}
+	// One for the overflow, and one for subscribing again.
+	if invalidations != 2 {
+		t.Errorf("invalidations: got %d, want 2", invalidations)
+	}
+
+	want := `
+# HELP nftables_ruleset_events Number of rule set change notifications received.
+# TYPE nftables_ruleset_events counter
+nftables_ruleset_events{family="inet",table="filter",type="newtable"} 2
+# HELP nftables_ruleset_events_overflows Number of times notifications were lost because the socket buffer was full.
+# TYPE nftables_ruleset_events_overflows counter
+nftables_ruleset_events_overflows 1
+# HELP nftables_ruleset_events_up Whether the exporter is subscribed to rule set change notifications. Value is 0 or 1.
+# TYPE nftables_ruleset_events_up gauge
+nftables_ruleset_events_up 0
+`
+	if err := testutil.CollectAndCompare(w, strings.NewReader(want), "nftables_ruleset_events", "nftables_ruleset_events_overflows", "nftables_ruleset_events_up"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+}
+
+// A fakeEventSource returns the errors, and then messages from a
+// channel until it's closed.
+type fakeEventSource struct {
+	errs []error
+	msgs chan []netlink.Message
+	done chan struct{}
+}
+
+func (src *fakeEventSource) Receive() ([]netlink.Message, error) {
+	if len(src.errs) > 0 {
+		err := src.errs[0]
+		src.errs = src.errs[1:]
+		return nil, err
+	}
+	select {
+	case msgs, ok := <-src.msgs:
+		if !ok {
+			return nil, errors.New("closed")
+		}
+		return msgs, nil
+	case <-src.done:
+		return nil, errors.New("use of closed connection")
+	}
+}
+
+func (src *fakeEventSource) Close() error {
+	close(src.done)
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/google/nftables"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
+)
+
+const (
+	// netnsDir is where iproute2 keeps named network namespaces.
+	netnsDir = "/run/netns"
+)
+
+// A netnsSource finds network namespaces. The namespace of the
+// process is always included.
+type netnsSource struct {
+	// dir is a directory of named namespaces to include, if not
+	// empty. Namespaces are named by their file names.
+	dir string
+
+	// glob is a pattern of namespace paths to include, if not
+	// empty. Namespaces are named by their paths.
+	glob string
+
+	// procs includes the namespaces of processes, found in
+	// procRoot. Namespaces are named by their inode numbers.
+	procs bool
+
+	// procRoot is where procfs is mounted. It is also used to find
+	// the namespace of this process.
+	procRoot string
+}
+
+// newNetNSSource creates a source of the namespace of the process,
+// the named namespaces if named is true, namespaces matching glob,
+// if not empty, and namespaces of processes if procs is true.
+func newNetNSSource(named bool, glob string, procs bool, procRoot string) (*netnsSource, error) {
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid netns-glob: %v", err)
+	}
+
+	src := &netnsSource{
+		glob:     glob,
+		procs:    procs,
+		procRoot: procRoot,
+	}
+	if named {
+		src.dir = netnsDir
+	}
+	return src, nil
+}
+
+// multi returns true if other namespaces than that of the process
+// are included.
+func (src *netnsSource) multi() bool {
+	return src.dir != "" || src.glob != "" || src.procs
+}
+
+// A netnsCollector runs an nftcollector.Collector in each namespace of a
+// source, adding a netns label to all metrics. The namespace of the
+// process has an empty label. If processes are included, the
+// container_id and pod_uid labels are also added.
+type netnsCollector struct {
+	coll *nftcollector.Collector // A template. The connection is replaced.
+	src  *netnsSource
+
+	// newConn opens a connection to a namespace. An empty path
+	// means the namespace of the process.
+	newConn func(path string) (nftcollector.Conn, io.Closer, error)
+}
+
+// newNetNSCollector creates a collector for the namespaces of src.
+func newNetNSCollector(coll *nftcollector.Collector, src *netnsSource) *netnsCollector {
+	return &netnsCollector{
+		coll:    coll,
+		src:     src,
+		newConn: newNetNSConn,
+	}
+}
+
+// newNetNSConn opens a connection in the network namespace at path.
+func newNetNSConn(path string) (nftcollector.Conn, io.Closer, error) {
+	if path == "" {
+		return &nftcollector.NLConn{}, ioutil.NopCloser(nil), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &nftcollector.NLConn{Conn: nftables.Conn{NetNS: int(f.Fd())}}, f, nil
+}
+
+// Describe implements prometheus.Collector. Since the set of
+// namespaces changes, this is an unchecked collector.
+func (c *netnsCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c *netnsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+// CollectContext implements contextCollector. Namespaces not reached
+// before the context is done are skipped, and the netns section of
+// the namespace of the process is marked truncated.
+func (c *netnsCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	var truncated float64
+	for _, ns := range c.src.list() {
+		if ctx.Err() != nil {
+			truncated = 1
+			break
+		}
+		if err := c.collectNetNS(ctx, ch, ns); err != nil {
+			log.Printf("%v (ignored)", err)
+			selfMetrics.CollectionFailures.WithLabelValues(ns.Name).Inc()
+		}
+	}
+
+	m := prometheus.MustNewConstMetric(nftcollector.TruncatedDesc, prometheus.GaugeValue, truncated, sectionNetNS)
+	ch <- labeledMetric{m, c.labels(netns{})}
+}
+
+// collectNetNS runs the collector in a single namespace.
+func (c *netnsCollector) collectNetNS(ctx context.Context, ch chan<- prometheus.Metric, ns netns) error {
+	conn, cl, err := c.newConn(ns.Path)
+	if err != nil {
+		return fmt.Errorf("opening network namespace %q: %v", ns.Name, err)
+	}
+	defer cl.Close()
+
+	coll := c.coll.WithConn(conn, ns.Name)
+
+	mch := make(chan prometheus.Metric)
+	go func() {
+		defer close(mch)
+		coll.CollectContext(ctx, mch)
+	}()
+
+	labels := c.labels(ns)
+	for m := range mch {
+		ch <- labeledMetric{m, labels}
+	}
+
+	return nil
+}
+
+// labels returns the labels added to metrics of the namespace.
+func (c *netnsCollector) labels(ns netns) []*dto.LabelPair {
+	labels := []*dto.LabelPair{makeLabelPair("netns", ns.Name)}
+	if c.src.procs {
+		labels = append(labels, makeLabelPair("container_id", ns.ContainerID), makeLabelPair("pod_uid", ns.PodUID))
+	}
+	return labels
+}
+
+// A labeledMetric is a metric with additional labels. The
+// description is not updated, so it only works in unchecked
+// collectors.
+type labeledMetric struct {
+	prometheus.Metric
+	labels []*dto.LabelPair
+}
+
+// Write implements prometheus.Metric.
+func (m labeledMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+
+	out.Label = append(out.Label, m.labels...)
+	sort.Slice(out.Label, func(i, j int) bool {
+		return out.Label[i].GetName() < out.Label[j].GetName()
+	})
+
+	return nil
+}
+
+// makeLabelPair returns a label with the given name and value.
+func makeLabelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// A netns is a network namespace to collect from.
+type netns struct {
+	Name string
+	Path string // Empty for the namespace of the process.
+
+	// ContainerID and PodUID are set for namespaces of processes
+	// in containers, if known.
+	ContainerID string
+	PodUID      string
+}
+
+// list returns the namespaces of the source. The same namespace is
+// only returned once, the first name winning.
+func (src *netnsSource) list() []netns {
+	nss := []netns{{}}
+	seen := map[netnsID]bool{}
+	if id, err := statNetNS(filepath.Join(src.procRoot, "self/ns/net")); err == nil {
+		seen[id] = true
+	}
+
+	add := func(name, path string) {
+		id, err := statNetNS(path)
+		if err != nil {
+			log.Printf("Failed to stat network namespace %q: %v (ignored)", name, err)
+			selfMetrics.CollectionFailures.WithLabelValues(name).Inc()
+			return
+		}
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		nss = append(nss, netns{Name: name, Path: path})
+	}
+
+	if src.dir != "" {
+		fis, err := ioutil.ReadDir(src.dir)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to list network namespaces: %v (ignored)", err)
+			selfMetrics.CollectionFailures.WithLabelValues("").Inc()
+		}
+		for _, fi := range fis {
+			add(fi.Name(), filepath.Join(src.dir, fi.Name()))
+		}
+	}
+
+	if src.glob != "" {
+		// The pattern was checked in newNetNSSource.
+		paths, _ := filepath.Glob(src.glob)
+		sort.Strings(paths)
+		for _, path := range paths {
+			add(path, path)
+		}
+	}
+
+	if src.procs {
+		pnss, err := discoverProcNetNS(src.procRoot, seen)
+		if err != nil {
+			log.Printf("Failed to list processes: %v (ignored)", err)
+			selfMetrics.CollectionFailures.WithLabelValues("").Inc()
+		}
+		nss = append(nss, pnss...)
+	}
+
+	return nss
+}
+
+// find returns the namespace with the given name.
+func (src *netnsSource) find(name string) (netns, bool) {
+	if name == "" {
+		return netns{}, true
+	}
+	for _, ns := range src.list() {
+		if ns.Name == name {
+			return ns, true
+		}
+	}
+	return netns{}, false
+}
+
+// A netnsID identifies a network namespace.
+type netnsID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// statNetNS returns the identity of the namespace at path.
+func statNetNS(path string) (netnsID, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return netnsID{}, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return netnsID{}, fmt.Errorf("no inode information for %q", path)
+	}
+	return netnsID{Dev: uint64(st.Dev), Ino: st.Ino}, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
+)
+
+func TestNetNSCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	named := filepath.Join(dir, "named")
+	if err := os.Mkdir(named, 0700); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	for _, path := range []string{"named/ns1", "named/ns2", "named/bad", "other"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, path), nil, 0600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	// Second names of the same namespaces.
+	if err := os.Link(filepath.Join(named, "ns1"), filepath.Join(named, "ns3")); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if err := os.Link(filepath.Join(named, "ns2"), filepath.Join(dir, "other2")); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	conns := map[string]nftcollector.Conn{
+		"": &fakeNFTConn{tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		}},
+		filepath.Join(named, "ns1"): &fakeNFTConn{tables: []*nftables.Table{
+			{Name: "table2", Family: nftables.TableFamilyINet},
+		}},
+		filepath.Join(named, "ns2"): &fakeNFTConn{tables: []*nftables.Table{
+			{Name: "table3", Family: nftables.TableFamilyIPv4},
+		}},
+		filepath.Join(dir, "other"): &fakeNFTConn{tables: []*nftables.Table{
+			{Name: "table4", Family: nftables.TableFamilyIPv6},
+		}},
+	}
+
+	c := newNetNSCollector(newTestCollector(&fakeNFTConn{}), &netnsSource{dir: named, glob: filepath.Join(dir, "o*"), procRoot: "/proc"})
+	c.newConn = func(path string) (nftcollector.Conn, io.Closer, error) {
+		conn, ok := conns[path]
+		if !ok {
+			return nil, nil, errors.New("no such namespace")
+		}
+		return conn, ioutil.NopCloser(nil), nil
+	}
+
+	badBefore := testutil.ToFloat64(selfMetrics.CollectionFailures.WithLabelValues("bad"))
+
+	want := `
+# HELP nftables_table_metadata Metadata about each table. Value is always 1.
+# TYPE nftables_table_metadata gauge
+nftables_table_metadata{family="inet",flags="",netns="",table="table1"} 1
+nftables_table_metadata{family="inet",flags="",netns="ns1",table="table2"} 1
+nftables_table_metadata{family="ip",flags="",netns="ns2",table="table3"} 1
+nftables_table_metadata{family="ip6",flags="",netns="` + filepath.Join(dir, "other") + `",table="table4"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_table_metadata"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+
+	if got := testutil.ToFloat64(selfMetrics.CollectionFailures.WithLabelValues("bad")) - badBefore; got != 1 {
+		t.Errorf("collection_failures{netns=\"bad\"}: got %v, want %v", got, 1)
+	}
+}
+
+func TestNetNSCollectorProcs(t *testing.T) {
+	root := makeFakeProcfs(t, []fakeProcFile{
+		{Path: "self/ns/net"},
+		{Path: "1/ns/net", Link: "self/ns/net"},
+		{Path: "100/ns/net"},
+		{Path: "100/cgroup", Data: "0::/kubepods/pod" + testPodUID + "/" + testContainerID + "\n"},
+	})
+	defer os.RemoveAll(root)
+
+	id, err := statNetNS(filepath.Join(root, "100/ns/net"))
+	if err != nil {
+		t.Fatalf("statNetNS failed: %v", err)
+	}
+	name := "net:[" + strconv.FormatUint(id.Ino, 10) + "]"
+
+	conns := map[string]nftcollector.Conn{
+		"": &fakeNFTConn{tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		}},
+		filepath.Join(root, "100/ns/net"): &fakeNFTConn{tables: []*nftables.Table{
+			{Name: "table2", Family: nftables.TableFamilyINet},
+		}},
+	}
+
+	c := newNetNSCollector(newTestCollector(&fakeNFTConn{}), &netnsSource{procs: true, procRoot: root})
+	c.newConn = func(path string) (nftcollector.Conn, io.Closer, error) {
+		return conns[path], ioutil.NopCloser(nil), nil
+	}
+
+	want := `
+# HELP nftables_table_metadata Metadata about each table. Value is always 1.
+# TYPE nftables_table_metadata gauge
+nftables_table_metadata{container_id="",family="inet",flags="",netns="",pod_uid="",table="table1"} 1
+nftables_table_metadata{container_id="` + testContainerID + `",family="inet",flags="",netns="` + name + `",pod_uid="` + testPodUID + `",table="table2"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_table_metadata"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
+)
+
+// A probeHandler serves /probe, which exports the metrics of a
+// single network namespace, filtered by a module. Like the Blackbox
+// exporter, each request uses a fresh collector and registry. The
+// module query parameter selects the module, and empty means the
+// flags. The netns parameter selects a namespace by its netns label,
+// and empty means the namespace of the process.
+type probeHandler struct {
+	conn    nftcollector.Conn // For the namespace of the process.
+	def     collectorConfig
+	modules map[string]collectorConfig
+	src     *netnsSource
+	log     *log.Logger
+
+	// newConn opens a connection to a namespace.
+	newConn func(path string) (nftcollector.Conn, io.Closer, error)
+}
+
+// newProbeHandler creates a new handler. Modules are validated.
+func newProbeHandler(conn nftcollector.Conn, def collectorConfig, modules map[string]collectorConfig, src *netnsSource, log *log.Logger) (*probeHandler, error) {
+	for name, cfg := range modules {
+		if _, err := cfg.newCollector(conn, "", nil, nil); err != nil {
+			return nil, fmt.Errorf("module %q: %v", name, err)
+		}
+	}
+
+	return &probeHandler{
+		conn:    conn,
+		def:     def,
+		modules: modules,
+		src:     src,
+		log:     log,
+		newConn: newNetNSConn,
+	}, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	coll, cl, ok := h.collector(w, r)
+	if !ok {
+		return
+	}
+	defer cl.Close()
+
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(boundCollector{ctx, coll})
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{ErrorLog: h.log}).ServeHTTP(w, r)
+}
+
+// collector returns a collector for the module and namespace selected
+// by the request, and the closer of its connection. On failure, an
+// error response is written, and ok is false.
+func (h *probeHandler) collector(w http.ResponseWriter, r *http.Request) (coll *nftcollector.Collector, cl io.Closer, ok bool) {
+	q := r.URL.Query()
+
+	cfg := h.def
+	if name := q.Get("module"); name != "" {
+		cfg, ok = h.modules[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module: %q", name), http.StatusBadRequest)
+			return nil, nil, false
+		}
+	}
+
+	ns, ok := h.src.find(q.Get("netns"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown netns: %q", q.Get("netns")), http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	conn, cl := h.conn, ioutil.NopCloser(nil)
+	if ns.Path != "" {
+		c, ncl, err := h.newConn(ns.Path)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("opening network namespace %q: %v", ns.Name, err), http.StatusInternalServerError)
+			selfMetrics.CollectionFailures.WithLabelValues(ns.Name).Inc()
+			return nil, nil, false
+		}
+		conn, cl = c, ncl
+	}
+
+	coll, err := cfg.newCollector(conn, ns.Name, nil, nil)
+	if err != nil {
+		// Validated in newProbeHandler.
+		cl.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	return coll, cl, true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+)
+
+func TestProbeHandler(t *testing.T) {
+	conn := &fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		},
+		objs: map[string][]nftables.Obj{
+			"table1": []nftables.Obj{
+				&nftables.CounterObj{Name: "web1", Packets: 1},
+				&nftables.CounterObj{Name: "db1", Packets: 2},
+			},
+		},
+	}
+	web := testCollectorConfig
+	web.CounterNames = "web.*"
+
+	h, err := newProbeHandler(conn, testCollectorConfig, map[string]collectorConfig{"web": web}, &netnsSource{procRoot: "/proc"}, nil)
+	if err != nil {
+		t.Fatalf("newProbeHandler failed: %v", err)
+	}
+
+	tsts := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []string
+		wantNot    []string
+	}{
+		{"default", "", http.StatusOK, []string{`counter="web1"`, `counter="db1"`}, nil},
+		{"module", "module=web", http.StatusOK, []string{`counter="web1"`}, []string{`counter="db1"`}},
+		{"unknownModule", "module=db", http.StatusBadRequest, []string{"unknown module"}, nil},
+		{"unknownNetNS", "netns=other", http.StatusBadRequest, []string{"unknown netns"}, nil},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe?"+tst.query, nil))
+
+			if w.Code != tst.wantStatus {
+				t.Errorf("ServeHTTP status: got %v, want %v", w.Code, tst.wantStatus)
+			}
+			for _, want := range tst.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("ServeHTTP: want %q, got:\n%s", want, w.Body.String())
+				}
+			}
+			for _, want := range tst.wantNot {
+				if strings.Contains(w.Body.String(), want) {
+					t.Errorf("ServeHTTP: don't want %q, got:\n%s", want, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestNewProbeHandlerInvalidModule(t *testing.T) {
+	bad := testCollectorConfig
+	bad.QuotaNames = "("
+
+	_, err := newProbeHandler(&fakeNFTConn{}, testCollectorConfig, map[string]collectorConfig{"bad": bad}, &netnsSource{}, nil)
+	if err == nil || !strings.Contains(err.Error(), `module "bad"`) {
+		t.Errorf("newProbeHandler: got %v, want module error", err)
+	}
+}
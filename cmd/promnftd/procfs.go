@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// discoverProcNetNS returns the network namespaces of processes in
+// procRoot, except those in seen. Found namespaces are added to seen.
+// The container of the process with the lowest PID names the
+// namespace, which for Kubernetes is normally the pause container.
+func discoverProcNetNS(procRoot string, seen map[netnsID]bool) ([]netns, error) {
+	fis, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, fi := range fis {
+		if pid, err := strconv.Atoi(fi.Name()); err == nil && fi.IsDir() {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	var nss []netns
+	for _, pid := range pids {
+		dir := filepath.Join(procRoot, strconv.Itoa(pid))
+		path := filepath.Join(dir, "ns/net")
+		id, err := statNetNS(path)
+		if err != nil {
+			// Processes come and go, and we may not be
+			// allowed to look at all of them.
+			continue
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		ns := netns{Name: fmt.Sprintf("net:[%d]", id.Ino), Path: path}
+		if f, err := os.Open(filepath.Join(dir, "cgroup")); err == nil {
+			ns.ContainerID, ns.PodUID, err = parseCgroup(f)
+			f.Close()
+			if err != nil {
+				// The process probably exited. The namespace
+				// is still there, just without labels.
+				log.Printf("Reading cgroup of process %d: %v (ignored)", pid, err)
+				ns.ContainerID, ns.PodUID = "", ""
+			}
+		}
+		nss = append(nss, ns)
+	}
+
+	return nss, nil
+}
+
+var (
+	// containerIDRE matches container IDs of Docker, containerd
+	// and CRI-O, e.g. "/docker/<id>" and "cri-containerd-<id>.scope".
+	containerIDRE = regexp.MustCompile(`(?:^|[/-])([0-9a-f]{64})(?:\.scope)?$`)
+
+	// podUIDRE matches Kubernetes pod UIDs, e.g. "/pod<uid>/" and
+	// "kubepods-besteffort-pod<uid>.slice", where the dashes of
+	// the UID are replaced by underscores.
+	podUIDRE = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})(?:\.slice)?(?:/|$)`)
+)
+
+// parseCgroup returns the container ID and pod UID found in a
+// /proc/<pid>/cgroup file, or empty strings if not found.
+func parseCgroup(r io.Reader) (containerID, podUID string, err error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(sc.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		path := fields[2]
+
+		if containerID == "" {
+			if m := containerIDRE.FindStringSubmatch(path); m != nil {
+				containerID = m[1]
+			}
+		}
+		if podUID == "" {
+			if m := podUIDRE.FindStringSubmatch(path); m != nil {
+				podUID = strings.Replace(m[1], "_", "-", -1)
+			}
+		}
+	}
+	return containerID, podUID, sc.Err()
+}
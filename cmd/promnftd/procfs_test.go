@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	testContainerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testPodUID      = "12345678-9abc-def0-1234-56789abcdef0"
+)
+
+func TestDiscoverProcNetNS(t *testing.T) {
+	root := makeFakeProcfs(t, []fakeProcFile{
+		{Path: "self/ns/net"},
+		{Path: "1/ns/net", Link: "self/ns/net"},
+		{Path: "1/cgroup", Data: "0::/init.scope\n"},
+		{Path: "100/ns/net"},
+		{Path: "100/cgroup", Data: "0::/system.slice/docker-" + testContainerID + ".scope\n"},
+		{Path: "101/ns/net", Link: "100/ns/net"},
+		{Path: "101/cgroup", Data: "0::/system.slice/other.scope\n"},
+		{Path: "200/ns/net"},
+		{Path: "200/cgroup", Data: "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod" + strings.Replace(testPodUID, "-", "_", -1) + ".slice/cri-containerd-" + testContainerID + ".scope\n"},
+		{Path: "250/ns/net"},
+		{Path: "250/cgroup/unreadable"},
+		{Path: "300/ns/net"},
+		{Path: "abc/ns/net"},
+	})
+	defer os.RemoveAll(root)
+
+	self, err := statNetNS(filepath.Join(root, "self/ns/net"))
+	if err != nil {
+		t.Fatalf("statNetNS failed: %v", err)
+	}
+	seen := map[netnsID]bool{self: true}
+
+	got, err := discoverProcNetNS(root, seen)
+	if err != nil {
+		t.Fatalf("discoverProcNetNS failed: %v", err)
+	}
+
+	want := []netns{
+		{Path: filepath.Join(root, "100/ns/net"), ContainerID: testContainerID},
+		{Path: filepath.Join(root, "200/ns/net"), ContainerID: testContainerID, PodUID: testPodUID},
+		{Path: filepath.Join(root, "250/ns/net")},
+		{Path: filepath.Join(root, "300/ns/net")},
+	}
+	for i := range want {
+		id, err := statNetNS(want[i].Path)
+		if err != nil {
+			t.Fatalf("statNetNS failed: %v", err)
+		}
+		want[i].Name = "net:[" + strconv.FormatUint(id.Ino, 10) + "]"
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("discoverProcNetNS: got %+v, want %+v", got, want)
+	}
+	if len(seen) != 5 {
+		t.Errorf("seen: got %v entries, want %v", len(seen), 5)
+	}
+}
+
+func TestParseCgroup(t *testing.T) {
+	tsts := []struct {
+		name            string
+		data            string
+		wantContainerID string
+		wantPodUID      string
+	}{
+		{"host", "0::/init.scope\n", "", ""},
+		{"dockerV1", "12:pids:/docker/" + testContainerID + "\n1:name=systemd:/docker/" + testContainerID + "\n", testContainerID, ""},
+		{"dockerV2", "0::/system.slice/docker-" + testContainerID + ".scope\n", testContainerID, ""},
+		{"kubepodsV1", "4:memory:/kubepods/burstable/pod" + testPodUID + "/" + testContainerID + "\n", testContainerID, testPodUID},
+		{"kubepodsSystemd", "0::/kubepods.slice/kubepods-pod" + strings.Replace(testPodUID, "-", "_", -1) + ".slice/crio-" + testContainerID + ".scope\n", testContainerID, testPodUID},
+		{"malformed", "garbage\n", "", ""},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			gotContainerID, gotPodUID, err := parseCgroup(strings.NewReader(tst.data))
+			if err != nil {
+				t.Fatalf("parseCgroup failed: %v", err)
+			}
+			if gotContainerID != tst.wantContainerID {
+				t.Errorf("container ID: got %q, want %q", gotContainerID, tst.wantContainerID)
+			}
+			if gotPodUID != tst.wantPodUID {
+				t.Errorf("pod UID: got %q, want %q", gotPodUID, tst.wantPodUID)
+			}
+		})
+	}
+}
+
+// A fakeProcFile is a file in a fake procfs.
+type fakeProcFile struct {
+	Path string
+	Data string
+
+	// Link is a previous file to hard link to, if not empty. Used
+	// to make "ns/net" files refer to the same namespace.
+	Link string
+}
+
+// makeFakeProcfs creates a temporary directory with the files, in
+// order. The caller should remove it.
+func makeFakeProcfs(t *testing.T, files []fakeProcFile) string {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "procfs_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+
+	for _, f := range files {
+		path := filepath.Join(root, f.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if f.Link != "" {
+			if err := os.Link(filepath.Join(root, f.Link), path); err != nil {
+				t.Fatalf("Link failed: %v", err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(f.Data), 0600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	return root
+}
@@ -9,22 +9,70 @@ import (
 	"net/http"
 	"os"
 
-	"github.com/google/nftables"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
 )
 
 var (
+	configPath = flag.String("config.file", "", "Path to a YAML configuration file with filters and /probe modules.")
+
 	ruleCommentFilter = flag.String("rule-comments", ".*", "Regular expression of comments of rules to include (fully anchored).")
 	counterNameFilter = flag.String("counter-names", ".*", "Regular expression of names of counters to include (fully anchored).")
 	setNameFilter     = flag.String("set-names", ".*", "Regular expression of names of sets to include (fully anchored).")
+	quotaNameFilter   = flag.String("quota-names", ".*", "Regular expression of names of quotas to include (fully anchored).")
+	ruleText          = flag.String("rule-text", "", `Identify rules without comments by their expressions: "text" or "hash". Empty ignores them.`)
+	ruleCommentFmt    = flag.String("rule-comment-format", "", `Parse rule comments that are JSON objects as metric names and labels, if "json".`)
+	ruleDuplicates    = flag.String("rule-duplicates", "", `Tell apart rules in a chain with the same labels by adding a "handle" or "position" label. Empty sums them.`)
+
+	setElementFilter = flag.String("set-element-counters", "", "Regular expression of names of sets to export element counters for (fully anchored).")
+	setElementLimit  = flag.Int("set-element-limit", 100, "Maximum number of element counters to export per set. Zero means no limit.")
+	setElementTop    = flag.Bool("set-element-top", false, "If a set has too many elements, export the ones with the most bytes, instead of none.")
+
+	allNetNS  = flag.Bool("all-netns", false, "Also export metrics of the named network namespaces in /run/netns, with a netns label.")
+	netnsGlob = flag.String("netns-glob", "", "Also export metrics of network namespaces whose paths match this pattern, with a netns label.")
+	procNetNS = flag.Bool("proc-netns", false, "Also export metrics of network namespaces of all processes, with netns, container_id and pod_uid labels.")
+	procfs    = flag.String("procfs", "/proc", "Where procfs is mounted. Used to find network namespaces.")
+
+	monotonicRuleCounters = flag.Bool("monotonic-rule-counters", false, "Keep rule counters from decreasing when rules are replaced, e.g. by nft -f.")
+	ruleCounterState      = flag.String("rule-counter-state", "", "Path to a file where -monotonic-rule-counters keeps its state across restarts.")
+
+	watchEvents = flag.Bool("watch-events", false, "Export counters of rule set change notifications, received through netlink.")
+
+	nftJSON        = flag.String("nft-json", "", `Read the rule set from a file of "nft -j list ruleset" output on every collection, instead of using netlink. "-" reads stdin once.`)
+	nftJSONCommand = flag.String("nft-json-command", "", `Run this command, e.g. "nft -j list ruleset", on every collection and read the rule set from its output, instead of using netlink.`)
+	replayPath     = flag.String("replay", "", `Serve the rule set from a file written by "promnftd record", instead of using netlink.`)
+
+	refreshInterval = flag.Duration("refresh-interval", 0, "Collect metrics in the background at this interval, and serve the last collection. Zero collects on scrape.")
 
 	httpAddr         = flag.String("http-addr", "localhost:0", "TCP-address to listen for HTTP connections on.")
 	standaloneStderr = flag.Bool("standalone-log", false, "Log to stderr, with time prefix.")
 )
 
+// selfMetrics are the metrics about collections, shared by all
+// collectors.
+var selfMetrics = nftcollector.NewMetrics()
+
+// probeModules are the modules of /probe.
+var probeModules = moduleFlag{}
+
+func init() {
+	prometheus.MustRegister(selfMetrics)
+	flag.Var(probeModules, "probe-module", "Set a filter of a /probe module, as <module>.<flag name>=<value>. Can be repeated.")
+}
+
 func main() {
 	flag.Parse()
 
-	if err := run(context.Background()); err != nil {
+	var err error
+	switch flag.Arg(0) {
+	case "":
+		err = run(context.Background())
+	case "record":
+		err = runRecord(flag.Args()[1:])
+	default:
+		err = fmt.Errorf("unknown command: %q", flag.Arg(0))
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -38,12 +86,47 @@ func run(ctx context.Context) error {
 		ll = log.New(os.Stdout, "", 0)
 	}
 
-	var conn nftables.Conn
-	if _, err := conn.ListTables(); err != nil {
-		return fmt.Errorf("unable to access NF tables: %v", err)
+	conn, err := newConn()
+	if err != nil {
+		return err
+	}
+
+	src, err := newNetNSSource(*allNetNS, *netnsGlob, *procNetNS, *procfs)
+	if err != nil {
+		return err
+	}
+	if offline() && (src.multi() || *watchEvents) {
+		return fmt.Errorf("-nft-json, -nft-json-command and -replay can't be used with network namespaces or -watch-events")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cache := nftcollector.NewMetadataCache()
+
+	if *watchEvents {
+		es, err := newNFTEventSource()
+		if err != nil {
+			return fmt.Errorf("unable to subscribe to NF tables events: %v", err)
+		}
+		w := newEventWatcher(es, newNFTEventSource, cache.Invalidate)
+		if err := prometheus.Register(w); err != nil {
+			es.Close()
+			return err
+		}
+		defer prometheus.Unregister(w)
+		go w.run(ctx)
 	}
 
-	l, s, cleanup, err := startCollectorServer(ctx, &conn, *ruleCommentFilter, *counterNameFilter, *setNameFilter, *httpAddr, ll)
+	var counters *nftcollector.CounterTracker
+	if *monotonicRuleCounters {
+		counters, err = nftcollector.NewCounterTracker(*ruleCounterState)
+		if err != nil {
+			return fmt.Errorf("unable to load rule counter state: %v", err)
+		}
+	}
+
+	l, s, cleanup, err := startCollectorServer(ctx, conn, loadConfig, src, counters, cache, *refreshInterval, *httpAddr, ll)
 	if err != nil {
 		return err
 	}
@@ -56,3 +139,84 @@ func run(ctx context.Context) error {
 
 	return nil
 }
+
+// newConn returns the connection selected by the flags, after
+// checking that it works.
+func newConn() (nftcollector.Conn, error) {
+	var n int
+	for _, s := range []string{*nftJSON, *nftJSONCommand, *replayPath} {
+		if s != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("-nft-json, -nft-json-command and -replay are mutually exclusive")
+	}
+
+	var conn nftcollector.Conn = &nftcollector.NLConn{}
+	switch {
+
+	case *nftJSON != "":
+		jc, err := nftcollector.NewJSONFileConn(*nftJSON)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read -nft-json: %v", err)
+		}
+		conn = jc
+
+	case *nftJSONCommand != "":
+		jc, err := nftcollector.NewJSONCommandConn(*nftJSONCommand)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -nft-json-command: %v", err)
+		}
+		conn = jc
+
+	case *replayPath != "":
+		rec, err := nftcollector.ReadRecordingFile(*replayPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read -replay: %v", err)
+		}
+		conn = nftcollector.NewReplayConn(rec)
+	}
+
+	if _, err := conn.ListTables(); err != nil {
+		return nil, fmt.Errorf("unable to access NF tables: %v", err)
+	}
+	return conn, nil
+}
+
+// offline returns true if the rule set isn't read from the kernel
+// through netlink.
+func offline() bool {
+	return *nftJSON != "" || *nftJSONCommand != "" || *replayPath != ""
+}
+
+// loadConfig returns the configuration from the flags and
+// -config.file. The file is read on every call.
+func loadConfig() (collectorConfig, map[string]collectorConfig, error) {
+	cfg := collectorConfig{
+		RuleComments:       *ruleCommentFilter,
+		CounterNames:       *counterNameFilter,
+		SetNames:           *setNameFilter,
+		QuotaNames:         *quotaNameFilter,
+		RuleText:           *ruleText,
+		RuleCommentFormat:  *ruleCommentFmt,
+		RuleDuplicates:     *ruleDuplicates,
+		SetElementCounters: *setElementFilter,
+		SetElementLimit:    *setElementLimit,
+		SetElementTop:      *setElementTop,
+	}
+	modules := probeModules.configs(cfg)
+	if *configPath == "" {
+		return cfg, modules, nil
+	}
+
+	f, err := readConfigFile(*configPath)
+	if err != nil {
+		return collectorConfig{}, nil, err
+	}
+	cfg, modules, err = f.apply(cfg, probeModules)
+	if err != nil {
+		return collectorConfig{}, nil, fmt.Errorf("invalid %s: %v", *configPath, err)
+	}
+	return cfg, modules, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
+	"github.com/tommie/prometheus-nftables-exporter/udata"
+	"golang.org/x/sys/unix"
+)
+
+func TestSelfMetricsRegistered(t *testing.T) {
+	if err := prometheus.Register(selfMetrics); err == nil {
+		t.Errorf("Register(selfMetrics): got nil, want AlreadyRegisteredError")
+	} else if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+		t.Errorf("Register(selfMetrics): got %v, want AlreadyRegisteredError", err)
+	}
+}
+
+// newTestCollector returns a collector exporting everything except
+// set element counters.
+func newTestCollector(conn nftcollector.Conn) *nftcollector.Collector {
+	c, err := nftcollector.New(conn, nftcollector.Options{Metrics: selfMetrics})
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+type fakeNFTConn struct {
+	tables []*nftables.Table
+	chains []*nftables.Chain
+	objs   map[string][]nftables.Obj            // Key is "table".
+	quotas map[string][]*nftcollector.Quota     // Key is "table".
+	rules  map[string][]*nftcollector.Rule      // Key is "table/chain".
+	sets   map[string][]*nftcollector.Set       // Key is "table".
+	setEls map[string][]nftcollector.SetElement // Key is "set".
+	gen    uint32
+}
+
+func (c *fakeNFTConn) GetGen() (uint32, error) {
+	return c.gen, nil
+}
+
+func (c *fakeNFTConn) ListTables() ([]*nftables.Table, error) {
+	return c.tables, nil
+}
+
+func (c *fakeNFTConn) ListChains() ([]*nftables.Chain, error) {
+	return c.chains, nil
+}
+
+func (c *fakeNFTConn) GetObjects(t *nftables.Table) ([]nftables.Obj, error) {
+	return c.objs[t.Name], nil
+}
+
+func (c *fakeNFTConn) GetQuotas(t *nftables.Table) ([]*nftcollector.Quota, error) {
+	return c.quotas[t.Name], nil
+}
+
+func (c *fakeNFTConn) GetTableRules(t *nftables.Table) (map[string][]*nftcollector.Rule, error) {
+	rsm := map[string][]*nftcollector.Rule{}
+	for k, rs := range c.rules {
+		if strings.HasPrefix(k, t.Name+"/") {
+			rsm[strings.TrimPrefix(k, t.Name+"/")] = rs
+		}
+	}
+	return rsm, nil
+}
+
+func (c *fakeNFTConn) GetSets(t *nftables.Table) ([]*nftcollector.Set, error) {
+	return c.sets[t.Name], nil
+}
+
+func (c *fakeNFTConn) GetSetElements(st *nftables.Set) ([]nftcollector.SetElement, error) {
+	return c.setEls[st.Name], nil
+}
+
+func makeRuleComment(s string) []byte {
+	return append([]byte{byte(udata.RuleComment), byte(len(s) + 1)}, append([]byte(s), '\x00')...)
+}
+
+func makeNLReply(req netlink.Message, msgType uint16, f func(*netlink.AttributeEncoder)) netlink.Message {
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	f(ae)
+	bs, err := ae.Encode()
+	if err != nil {
+		panic(err)
+	}
+
+	return netlink.Message{
+		Header: netlink.Header{
+			Type:     netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | msgType),
+			Sequence: req.Header.Sequence,
+			PID:      req.Header.PID,
+		},
+		Data: append([]byte{req.Data[0], unix.NFNETLINK_V0, 0, 0}, bs...),
+	}
+}
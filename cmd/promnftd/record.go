@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
+	"golang.org/x/sys/unix"
+)
+
+// runRecord implements "promnftd record <file>". It runs a collection
+// through a recorder, exporting everything, so the recording can be
+// replayed with any filters. The file "-" is standard output.
+func runRecord(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: promnftd record <file>")
+	}
+
+	rec := nftcollector.NewRecorder(func() (*netlink.Conn, error) {
+		return netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	})
+	if err := recordCollection(rec); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := nftcollector.WriteRecording(&buf, rec.Recording()); err != nil {
+		return err
+	}
+	if args[0] == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := ioutil.WriteFile(args[0], buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	log.Printf("Recorded %d netlink requests to %q.", len(rec.Recording().Exchanges), args[0])
+	return nil
+}
+
+// recordCollection runs a collection through the recorder. Errors
+// from the kernel are recorded, and only fail the recording if the
+// tables can't be listed.
+func recordCollection(rec *nftcollector.Recorder) error {
+	conn := &nftcollector.NLConn{Conn: nftables.Conn{TestDial: rec.Exchange}}
+	if _, err := conn.ListTables(); err != nil {
+		return fmt.Errorf("unable to access NF tables: %v", err)
+	}
+
+	coll, err := nftcollector.New(conn, nftcollector.Options{
+		SetElementCounters: func(string) bool { return true },
+	})
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		coll.Collect(ch)
+	}()
+	for range ch {
+	}
+
+	return nil
+}
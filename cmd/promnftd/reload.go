@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nftables_exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful.",
+	})
+
+	configLastReloadSuccessTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nftables_exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(configLastReloadSuccessful)
+	prometheus.MustRegister(configLastReloadSuccessTime)
+}
+
+// A reloader holds the collector and module handler, and replaces
+// them when the configuration is reloaded. It is a
+// prometheus.Collector and an http.Handler for modules, delegating to
+// the current ones.
+type reloader struct {
+	// load returns the current configuration and modules.
+	load func() (collectorConfig, map[string]collectorConfig, error)
+
+	// build creates a collector and a handler of /probe and
+	// /debug/ruleset.
+	build func(collectorConfig, map[string]collectorConfig) (contextCollector, http.Handler, error)
+
+	mu    sync.RWMutex
+	coll  contextCollector
+	probe http.Handler
+}
+
+// newReloader creates a new reloader, and loads the configuration.
+func newReloader(load func() (collectorConfig, map[string]collectorConfig, error), build func(collectorConfig, map[string]collectorConfig) (contextCollector, http.Handler, error)) (*reloader, error) {
+	r := &reloader{load: load, build: build}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the configuration, and replaces the collector and
+// handler. On failure, the old ones are kept.
+func (r *reloader) reload() error {
+	coll, probe, err := r.loadAndBuild()
+	if err != nil {
+		configLastReloadSuccessful.Set(0)
+		return err
+	}
+
+	r.mu.Lock()
+	r.coll = coll
+	r.probe = probe
+	r.mu.Unlock()
+
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTime.SetToCurrentTime()
+	return nil
+}
+
+// loadAndBuild loads the configuration and builds new objects.
+func (r *reloader) loadAndBuild() (contextCollector, http.Handler, error) {
+	cfg, modules, err := r.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.build(cfg, modules)
+}
+
+// current returns the current collector and handler.
+func (r *reloader) current() (contextCollector, http.Handler) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.coll, r.probe
+}
+
+// Describe implements prometheus.Collector. Since labels depend on
+// the configuration, this is an unchecked collector.
+func (r *reloader) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (r *reloader) Collect(ch chan<- prometheus.Metric) {
+	r.CollectContext(context.Background(), ch)
+}
+
+// CollectContext implements contextCollector.
+func (r *reloader) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	coll, _ := r.current()
+	coll.CollectContext(ctx, ch)
+}
+
+// ServeHTTP implements http.Handler, serving /probe and
+// /debug/ruleset.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	_, probe := r.current()
+	probe.ServeHTTP(w, req)
+}
+
+// serveReload handles POST /-/reload.
+func (r *reloader) serveReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.reload(); err != nil {
+		log.Printf("Reloading configuration failed: %v", err)
+		http.Error(w, fmt.Sprintf("reloading configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Reloaded configuration.")
+	fmt.Fprintln(w, "OK")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReloader(t *testing.T) {
+	conn := &fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		},
+		objs: map[string][]nftables.Obj{
+			"table1": []nftables.Obj{
+				&nftables.CounterObj{Name: "web1", Packets: 1},
+				&nftables.CounterObj{Name: "db1", Packets: 2},
+			},
+		},
+	}
+
+	cfg := testCollectorConfig
+	var loadErr error
+	r, err := newReloader(func() (collectorConfig, map[string]collectorConfig, error) {
+		return cfg, nil, loadErr
+	}, func(cfg collectorConfig, modules map[string]collectorConfig) (contextCollector, http.Handler, error) {
+		coll, err := cfg.newCollector(conn, "", nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		return coll, http.NotFoundHandler(), nil
+	})
+	if err != nil {
+		t.Fatalf("newReloader failed: %v", err)
+	}
+	if got := testutil.ToFloat64(configLastReloadSuccessful); got != 1 {
+		t.Errorf("config_last_reload_successful: got %v, want 1", got)
+	}
+
+	countCounters := func() int {
+		return testutil.CollectAndCount(r, "nftables_counter_packet_count")
+	}
+	if got := countCounters(); got != 2 {
+		t.Errorf("counters: got %v, want 2", got)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		cfg.CounterNames = "web.*"
+
+		w := httptest.NewRecorder()
+		r.serveReload(w, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("serveReload status: got %v, want %v", w.Code, http.StatusOK)
+		}
+		if got := countCounters(); got != 1 {
+			t.Errorf("counters: got %v, want 1", got)
+		}
+		if got := testutil.ToFloat64(configLastReloadSuccessful); got != 1 {
+			t.Errorf("config_last_reload_successful: got %v, want 1", got)
+		}
+		if got := testutil.ToFloat64(configLastReloadSuccessTime); got == 0 {
+			t.Errorf("config_last_reload_success_timestamp_seconds: got %v, want non-zero", got)
+		}
+	})
+
+	t.Run("invalidFilter", func(t *testing.T) {
+		cfg.CounterNames = "("
+
+		w := httptest.NewRecorder()
+		r.serveReload(w, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("serveReload status: got %v, want %v", w.Code, http.StatusInternalServerError)
+		}
+		if want := "invalid counter-names"; !strings.Contains(w.Body.String(), want) {
+			t.Errorf("serveReload: want %q, got %q", want, w.Body.String())
+		}
+		if got := countCounters(); got != 1 {
+			t.Errorf("counters: got %v, want the old 1", got)
+		}
+		if got := testutil.ToFloat64(configLastReloadSuccessful); got != 0 {
+			t.Errorf("config_last_reload_successful: got %v, want 0", got)
+		}
+	})
+
+	t.Run("loadError", func(t *testing.T) {
+		cfg.CounterNames = ".*"
+		loadErr = errors.New("mocked")
+		defer func() { loadErr = nil }()
+
+		if err := r.reload(); err == nil {
+			t.Errorf("reload: got nil error, want one")
+		}
+		if got := countCounters(); got != 1 {
+			t.Errorf("counters: got %v, want the old 1", got)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.serveReload(w, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("serveReload status: got %v, want %v", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
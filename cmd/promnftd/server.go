@@ -2,62 +2,78 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
 	"syscall"
+	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
 )
 
-// startCollectorServer reads global flags and starts the HTTP
-// server. Callers should run the returned cleanup function once the
-// server is stopped.
-func startCollectorServer(ctx context.Context, conn nftConn, ruleCommentFilter, counterNameFilter, setNameFilter, httpAddr string, log *log.Logger) (net.Listener, *http.Server, func(), error) {
-	rcre, err := regexp.Compile("^(" + ruleCommentFilter + ")$")
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("invalid -rule-comments: %v", err)
-	}
-	cnre, err := regexp.Compile("^(" + counterNameFilter + ")$")
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("invalid -counter-names: %v", err)
-	}
-	stre, err := regexp.Compile("^(" + setNameFilter + ")$")
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("invalid -set-names: %v", err)
-	}
+// startCollectorServer starts the HTTP server, exporting the
+// namespaces of src, and serving modules through /probe and
+// /debug/ruleset. The configuration is obtained from load, and is
+// reloaded on SIGHUP and POST /-/reload. Rule counters are made
+// monotonic by counters, and metadata is kept in cache across
+// reloads, if not nil. The /probe endpoint uses neither. If refresh
+// is non-zero, metrics are collected in the background at that
+// interval, and /metrics serves the last collection. Collections are
+// abandoned when the scrape timeout from Prometheus is reached. The
+// web UI on / shows the same collections. Callers should run the
+// returned cleanup function once the server is stopped.
+func startCollectorServer(ctx context.Context, conn nftcollector.Conn, load func() (collectorConfig, map[string]collectorConfig, error), src *netnsSource, counters *nftcollector.CounterTracker, cache *nftcollector.MetadataCache, refresh time.Duration, httpAddr string, log *log.Logger) (net.Listener, *http.Server, func(), error) {
+	r, err := newReloader(load, func(cfg collectorConfig, modules map[string]collectorConfig) (contextCollector, http.Handler, error) {
+		nftColl, err := cfg.newCollector(conn, "", counters, cache)
+		if err != nil {
+			return nil, nil, err
+		}
+		var coll contextCollector = nftColl
+		if src.multi() {
+			coll = newNetNSCollector(nftColl, src)
+		}
+
+		probe, err := newProbeHandler(conn, cfg, modules, src, log)
+		if err != nil {
+			return nil, nil, err
+		}
 
-	nftColl := newNFTCollector(conn, rcre.MatchString, cnre.MatchString, stre.MatchString)
-	if err := prometheus.Register(nftColl); err != nil {
+		h := http.NewServeMux()
+		h.Handle("/probe", probe)
+		h.HandleFunc("/debug/ruleset", probe.serveDebug)
+
+		return coll, h, nil
+	})
+	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	http.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
-		ErrorLog: log,
-	}))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "/metrics", http.StatusFound)
-	})
+	snap := newSnapshotCollector(r)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", newMetricsHandler(snap, log))
+	mux.Handle("/probe", r)
+	mux.Handle("/debug/ruleset", r)
+	mux.HandleFunc("/-/reload", r.serveReload)
+	mux.Handle("/", newUIHandler(snap))
 
 	l, err := net.Listen("tcp", httpAddr)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	s := &http.Server{Addr: l.Addr().String()}
+	s := &http.Server{Addr: l.Addr().String(), Handler: mux}
 
 	cctx, cancel := context.WithCancel(ctx)
 	stopHTTPServerOnSignal(cctx, s, os.Interrupt, syscall.SIGTERM)
+	reloadOnSignal(cctx, r.reload, syscall.SIGHUP)
+	if refresh > 0 {
+		go snap.refreshEvery(cctx, refresh)
+	}
 
-	return l, s, func() {
-		cancel()
-		prometheus.Unregister(nftColl)
-	}, nil
+	return l, s, cancel, nil
 }
 
 // stopHTTPServerOnSignal listens for OS signals, and returns. On
@@ -93,6 +109,31 @@ func stopHTTPServerOnSignal(ctx context.Context, s shutdownerCloser, sigs ...os.
 	}()
 }
 
+// reloadOnSignal listens for OS signals, and returns. On each
+// signal, it runs reload. Honors context cancellation.
+func reloadOnSignal(ctx context.Context, reload func() error, sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		defer signal.Stop(ch)
+
+		for {
+			select {
+			case <-ch:
+				// continue
+			case <-ctx.Done():
+				return
+			}
+			if err := reload(); err != nil {
+				log.Printf("Reloading configuration failed: %v", err)
+			} else {
+				log.Printf("Reloaded configuration.")
+			}
+		}
+	}()
+}
+
 type shutdownerCloser interface {
 	Shutdown(context.Context) error
 	Close() error
@@ -6,10 +6,11 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/google/nftables"
-	"sync"
 )
 
 func TestStartCollectorServer(t *testing.T) {
@@ -19,7 +20,9 @@ func TestStartCollectorServer(t *testing.T) {
 		tables: []*nftables.Table{
 			{Name: "table1", Family: nftables.TableFamilyINet},
 		},
-	}, ".*", ".*", ".*", "localhost:0", nil)
+	}, func() (collectorConfig, map[string]collectorConfig, error) {
+		return testCollectorConfig, nil, nil
+	}, &netnsSource{procRoot: "/proc"}, nil, nil, 0, "localhost:0", nil)
 	if err != nil {
 		t.Fatalf("startCollectorServer failed: %v", err)
 	}
@@ -28,7 +31,7 @@ func TestStartCollectorServer(t *testing.T) {
 
 	go func() {
 		if err := s.Serve(l); err != nil && err != http.ErrServerClosed {
-			t.Fatalf("Serve failed: %v", err)
+			t.Errorf("Serve failed: %v", err)
 		}
 	}()
 
@@ -47,6 +50,16 @@ func TestStartCollectorServer(t *testing.T) {
 	if !strings.Contains(string(got), want) {
 		t.Errorf("Get: want %q, got:\n%s", want, string(got))
 	}
+
+	rresp, err := http.Post("http://"+s.Addr+"/-/reload", "", nil)
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	rresp.Body.Close()
+
+	if rresp.StatusCode != http.StatusOK {
+		t.Errorf("Post /-/reload: got status %v, want %v", rresp.StatusCode, http.StatusOK)
+	}
 }
 
 func TestStopHTTPServerOnSignal(t *testing.T) {
@@ -75,6 +88,29 @@ func TestStopHTTPServerOnSignal(t *testing.T) {
 	s.close.Wait()
 }
 
+func TestReloadOnSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloads := make(chan struct{})
+	reloadOnSignal(ctx, func() error {
+		reloads <- struct{}{}
+		return nil
+	}, syscall.SIGHUP)
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("FindProcess failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.Signal(syscall.SIGHUP); err != nil {
+			t.Fatalf("Signal failed: %v", err)
+		}
+		<-reloads
+	}
+}
+
 var _ shutdownerCloser = &http.Server{}
 
 type fakeShutdownerCloser struct {
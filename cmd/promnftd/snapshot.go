@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// sharedCollectionTimeout limits a shared collection that no
+	// joining scrape set a deadline for.
+	sharedCollectionTimeout = time.Minute
+)
+
+// A snapshotCollector collects from another collector, sharing the
+// result between concurrent scrapes. With refreshEvery running, it
+// serves the last snapshot instead of collecting on scrape.
+type snapshotCollector struct {
+	coll contextCollector
+	now  func() time.Time
+
+	mu         sync.Mutex
+	inflight   *snapshot // The running collection, if any.
+	last       *snapshot // The last completed collection.
+	background bool      // Whether refreshEvery is running.
+
+	ageDesc      *prometheus.Desc
+	durationDesc *prometheus.Desc
+}
+
+// A snapshot is the result of collecting once.
+type snapshot struct {
+	done     chan struct{} // Closed when the fields below are set.
+	metrics  []prometheus.Metric
+	end      time.Time
+	duration time.Duration
+
+	// prev is the collection before this, if any, for computing
+	// rates. Its own prev is nil.
+	prev *snapshot
+
+	// deadline is when a running collection is abandoned, the latest
+	// deadline of the scrapes sharing it. timer cancels it then. They
+	// are guarded by snapshotCollector.mu.
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// newSnapshotCollector creates a collector sharing the results of
+// coll.
+func newSnapshotCollector(coll contextCollector) *snapshotCollector {
+	return &snapshotCollector{
+		coll: coll,
+		now:  time.Now,
+
+		ageDesc:      prometheus.NewDesc("nftables_exporter_snapshot_age_seconds", "Time since the served metrics were collected.", nil, nil),
+		durationDesc: prometheus.NewDesc("nftables_exporter_refresh_duration_seconds", "Time it took to collect the served metrics.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector. Since the wrapped
+// collector may be unchecked, so is this.
+func (c *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+// CollectContext implements contextCollector. Concurrent scrapes
+// share a collection, which runs until the latest of their deadlines.
+// A scrape whose context is done before that gets nothing.
+func (c *snapshotCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	s := c.snapshot(ctx)
+	if s == nil {
+		return
+	}
+	for _, m := range s.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(c.ageDesc, prometheus.GaugeValue, c.now().Sub(s.end).Seconds())
+	ch <- prometheus.MustNewConstMetric(c.durationDesc, prometheus.GaugeValue, s.duration.Seconds())
+}
+
+// snapshot returns the collection a scrape would be served, collecting
+// if needed. It returns nil if the context is done first.
+func (c *snapshotCollector) snapshot(ctx context.Context) *snapshot {
+	c.mu.Lock()
+	s := c.last
+	if !c.background || s == nil {
+		s = c.startLocked(ctx)
+	}
+	c.mu.Unlock()
+
+	return c.wait(ctx, s)
+}
+
+// lastSnapshot returns the last collection, only collecting if there
+// is none yet. Unlike snapshot, it doesn't change what scrapes see.
+func (c *snapshotCollector) lastSnapshot(ctx context.Context) *snapshot {
+	c.mu.Lock()
+	s := c.last
+	if s == nil {
+		s = c.startLocked(ctx)
+	}
+	c.mu.Unlock()
+
+	return c.wait(ctx, s)
+}
+
+// wait returns the snapshot when it is done, or nil if the context is
+// done first. A collection abandoned at the context's own deadline is
+// still waited for, since it is about to finish with what it has.
+func (c *snapshotCollector) wait(ctx context.Context, s *snapshot) *snapshot {
+	select {
+	case <-s.done:
+		return s
+	case <-ctx.Done():
+	}
+
+	d, ok := ctx.Deadline()
+	c.mu.Lock()
+	abandoned := ok && ctx.Err() == context.DeadlineExceeded && !s.deadline.After(d)
+	c.mu.Unlock()
+	if !abandoned {
+		return nil
+	}
+
+	<-s.done
+	return s
+}
+
+// refreshEvery collects at the given interval, until the context is
+// cancelled. Scrapes are served from the last collection. Each
+// collection is abandoned after the interval.
+func (c *snapshotCollector) refreshEvery(ctx context.Context, interval time.Duration) {
+	c.mu.Lock()
+	c.background = true
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.background = false
+		c.mu.Unlock()
+	}()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		cctx, cancel := context.WithTimeout(ctx, interval)
+		c.mu.Lock()
+		s := c.startLocked(cctx)
+		c.mu.Unlock()
+		<-s.done
+		cancel()
+
+		select {
+		case <-t.C:
+			// continue
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// startLocked returns the running collection, or starts a new one.
+// The collection isn't cancelled with the context, but its deadline is
+// extended to the context's. c.mu must be held.
+func (c *snapshotCollector) startLocked(ctx context.Context) *snapshot {
+	if s := c.inflight; s != nil {
+		c.extendLocked(s, ctx)
+		return s
+	}
+
+	cctx, cancel := context.WithCancel(context.Background())
+	s := &snapshot{done: make(chan struct{})}
+	s.timer = time.AfterFunc(sharedCollectionTimeout, cancel)
+	c.extendLocked(s, ctx)
+	c.inflight = s
+
+	go func() {
+		defer cancel()
+		defer s.timer.Stop()
+
+		start := c.now()
+		mch := make(chan prometheus.Metric)
+		go func() {
+			defer close(mch)
+			c.coll.CollectContext(cctx, mch)
+		}()
+		for m := range mch {
+			s.metrics = append(s.metrics, m)
+		}
+		s.end = c.now()
+		s.duration = s.end.Sub(start)
+
+		c.mu.Lock()
+		if c.last != nil {
+			s.prev = &snapshot{metrics: c.last.metrics, end: c.last.end, duration: c.last.duration}
+		}
+		c.inflight = nil
+		c.last = s
+		c.mu.Unlock()
+
+		close(s.done)
+	}()
+
+	return s
+}
+
+// extendLocked moves the deadline of the running collection to the
+// deadline of the context, if that is later. A context without one
+// counts as sharedCollectionTimeout from now. c.mu must be held.
+func (c *snapshotCollector) extendLocked(s *snapshot, ctx context.Context) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		d = c.now().Add(sharedCollectionTimeout)
+	}
+	if !d.After(s.deadline) {
+		return
+	}
+	s.deadline = d
+	s.timer.Reset(d.Sub(c.now()))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSnapshotCollectorSingleFlight(t *testing.T) {
+	coll := &fakeCollector{release: make(chan struct{})}
+	c := newSnapshotCollector(coll)
+
+	c.mu.Lock()
+	c.startLocked(context.Background())
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := testutil.CollectAndCount(c, "test_metric"); got != 1 {
+				t.Errorf("CollectAndCount: got %v, want 1", got)
+			}
+		}()
+	}
+
+	// Lets the scrapes join the running collection.
+	time.Sleep(50 * time.Millisecond)
+	close(coll.release)
+	wg.Wait()
+
+	if got := coll.numCalls(); got != 1 {
+		t.Errorf("calls: got %v, want 1", got)
+	}
+
+	// Without background refresh, every scrape collects.
+	testutil.CollectAndCount(c)
+	if got := coll.numCalls(); got != 2 {
+		t.Errorf("calls: got %v, want 2", got)
+	}
+}
+
+func TestSnapshotCollectorDetached(t *testing.T) {
+	t.Run("cancelled", func(t *testing.T) {
+		coll := &fakeCollector{release: make(chan struct{})}
+		c := newSnapshotCollector(coll)
+
+		// The first scrape goes away, but the second one still gets
+		// the shared collection.
+		ctx, cancel := context.WithCancel(context.Background())
+		c.mu.Lock()
+		s := c.startLocked(ctx)
+		c.startLocked(context.Background())
+		c.mu.Unlock()
+
+		first := make(chan *snapshot)
+		go func() { first <- c.wait(ctx, s) }()
+		second := make(chan *snapshot)
+		go func() { second <- c.wait(context.Background(), s) }()
+
+		cancel()
+		if s := <-first; s != nil {
+			t.Errorf("first snapshot: got %+v, want nil", s)
+		}
+
+		close(coll.release)
+		if s := <-second; s == nil || len(s.metrics) != 1 {
+			t.Errorf("second snapshot: got %+v, want 1 metric", s)
+		}
+		if err := coll.lastCtxErr(); err != nil {
+			t.Errorf("collection context: got %v, want nil", err)
+		}
+		if got := coll.numCalls(); got != 1 {
+			t.Errorf("calls: got %v, want 1", got)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		coll := &fakeCollector{release: make(chan struct{})}
+		c := newSnapshotCollector(coll)
+
+		// The collection ends at the scrape's deadline, and the
+		// scrape gets what was collected.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		s := c.snapshot(ctx)
+		if s == nil || len(s.metrics) != 0 {
+			t.Errorf("snapshot: got %+v, want no metrics", s)
+		}
+		if err := coll.lastCtxErr(); err != context.Canceled {
+			t.Errorf("collection context: got %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestSnapshotCollectorRefreshEvery(t *testing.T) {
+	coll := &fakeCollector{release: make(chan struct{})}
+	close(coll.release)
+	c := newSnapshotCollector(coll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.refreshEvery(ctx, time.Hour)
+
+	for coll.numCalls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := testutil.CollectAndCount(c, "test_metric", "nftables_exporter_snapshot_age_seconds", "nftables_exporter_refresh_duration_seconds"); got != 3 {
+			t.Errorf("CollectAndCount: got %v, want 3", got)
+		}
+	}
+
+	if got := coll.numCalls(); got != 1 {
+		t.Errorf("calls: got %v, want 1", got)
+	}
+}
+
+func TestSnapshotCollectorPrev(t *testing.T) {
+	coll := &fakeCollector{release: make(chan struct{})}
+	close(coll.release)
+	c := newSnapshotCollector(coll)
+
+	first := c.snapshot(context.Background())
+	if first.prev != nil {
+		t.Errorf("first prev: got %+v, want nil", first.prev)
+	}
+
+	c.snapshot(context.Background())
+	s := c.snapshot(context.Background())
+	if s.prev == nil {
+		t.Fatalf("prev: got nil, want a snapshot")
+	}
+	if s.prev.prev != nil {
+		t.Errorf("prev.prev: got %+v, want nil", s.prev.prev)
+	}
+	if len(s.prev.metrics) != 1 {
+		t.Errorf("prev.metrics: got %v, want 1", len(s.prev.metrics))
+	}
+}
+
+// A fakeCollector exports a single metric, once release is closed, or
+// nothing if the context is done first.
+type fakeCollector struct {
+	release chan struct{}
+
+	mu     sync.Mutex
+	calls  int
+	ctxErr error // Of the last call.
+}
+
+func (c *fakeCollector) numCalls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func (c *fakeCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *fakeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+func (c *fakeCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+
+	select {
+	case <-c.release:
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("test_metric", "Test.", nil, nil), prometheus.GaugeValue, 1)
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	c.ctxErr = ctx.Err()
+	c.mu.Unlock()
+}
+
+func (c *fakeCollector) lastCtxErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ctxErr
+}
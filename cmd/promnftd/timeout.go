@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// scrapeTimeoutOffset is subtracted from the scrape timeout, to
+	// leave time for sending the response.
+	scrapeTimeoutOffset = 500 * time.Millisecond
+)
+
+// sectionNetNS is the section of nftables_scrape_truncated abandoned
+// when namespaces are skipped.
+const sectionNetNS = "netns"
+
+// A contextCollector is a prometheus.Collector that stops collecting
+// when the context is done. What was collected until then is still
+// exported.
+type contextCollector interface {
+	prometheus.Collector
+	CollectContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// scrapeContext returns a context with the deadline from the
+// X-Prometheus-Scrape-Timeout-Seconds header, if there is one.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	secs, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || secs <= 0 {
+		return context.WithCancel(r.Context())
+	}
+
+	timeout := time.Duration(secs * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// A boundCollector collects from a contextCollector with a fixed
+// context.
+type boundCollector struct {
+	ctx  context.Context
+	coll contextCollector
+}
+
+// Describe implements prometheus.Collector.
+func (c boundCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.coll.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c boundCollector) Collect(ch chan<- prometheus.Metric) {
+	c.coll.CollectContext(c.ctx, ch)
+}
+
+// newMetricsHandler serves the metrics of the default gatherer and
+// coll, honoring the scrape timeout.
+func newMetricsHandler(coll contextCollector, log *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+
+		reg := prometheus.NewRegistry()
+		if err := reg.Register(boundCollector{ctx, coll}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, reg}, promhttp.HandlerOpts{
+			ErrorLog: log,
+		}).ServeHTTP(w, r)
+	})
+}
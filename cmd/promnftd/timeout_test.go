@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScrapeContext(t *testing.T) {
+	tsts := []struct {
+		Name   string
+		Header string
+		Want   time.Duration // Zero for no deadline.
+	}{
+		{"none", "", 0},
+		{"invalid", "abc", 0},
+		{"zero", "0", 0},
+		{"offset", "10", 10*time.Second - scrapeTimeoutOffset},
+		{"short", "0.2", 200 * time.Millisecond},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tst.Header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tst.Header)
+			}
+
+			ctx, cancel := scrapeContext(r)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if tst.Want == 0 {
+				if ok {
+					t.Errorf("scrapeContext: got deadline %v, want none", deadline)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("scrapeContext: got no deadline, want %v", tst.Want)
+			}
+			if got := time.Until(deadline); got < tst.Want-time.Second || got > tst.Want {
+				t.Errorf("scrapeContext: got timeout %v, want %v", got, tst.Want)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+//go:embed ui/index.html
+var uiTemplateText string
+
+// uiTemplate renders a uiPage.
+var uiTemplate = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"rate": func(v float64) string { return fmt.Sprintf("%.1f/s", v) },
+}).Parse(uiTemplateText))
+
+// A uiHandler serves the web UI, showing the rule set tree from the
+// snapshots of a snapshotCollector. Rates are computed between the
+// served snapshot and the one before it. Page loads don't collect,
+// unless nothing has been collected yet, so they don't affect scrapes.
+type uiHandler struct {
+	snap *snapshotCollector
+	now  func() time.Time
+}
+
+// newUIHandler creates a handler for the snapshots of snap.
+func newUIHandler(snap *snapshotCollector) *uiHandler {
+	return &uiHandler{snap: snap, now: time.Now}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *uiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s := h.snap.lastSnapshot(r.Context())
+	page := newUIPage(s, h.now())
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := uiTemplate.Execute(w, page); err != nil {
+		log.Printf("Writing UI page failed: %v", err)
+	}
+}
+
+// A uiPage is the data of the UI template.
+type uiPage struct {
+	Age      time.Duration // Since the snapshot was collected.
+	Interval time.Duration // Between the snapshots rates are from, or zero.
+	Tables   []*uiTable
+}
+
+// A uiTable is a table, in a network namespace.
+type uiTable struct {
+	NetNS  string
+	Family string
+	Name   string
+	Flags  string
+	Chains []*uiChain
+	Sets   []*uiSet
+}
+
+// A uiChain is a chain, with its exported rules.
+type uiChain struct {
+	Name      string
+	Type      string // Empty for regular chains.
+	Hook      string
+	Policy    string
+	Priority  string
+	RuleCount float64
+	Rules     []*uiRule
+}
+
+// A uiRule is an exported rule metric, i.e. the sum of all rules with
+// the same labels.
+type uiRule struct {
+	Metric  string // The name from a JSON comment, or empty.
+	Labels  string // Like comment="web", or just web for plain comments.
+	Packets float64
+	Bytes   float64
+
+	HasRate    bool
+	PacketRate float64
+	ByteRate   float64
+}
+
+// A uiSet is a set and its size.
+type uiSet struct {
+	Name string
+	Size float64
+}
+
+// uiTableKey identifies a table across namespaces.
+type uiTableKey struct {
+	netns, family, table string
+}
+
+// uiChainKey identifies a chain across namespaces.
+type uiChainKey struct {
+	uiTableKey
+	chain string
+}
+
+// uiBuilder creates a uiPage, adding tables and chains on first use.
+type uiBuilder struct {
+	page   *uiPage
+	tables map[uiTableKey]*uiTable
+	chains map[uiChainKey]*uiChain
+}
+
+// newUIPage builds a page from the snapshot, and the one before it.
+func newUIPage(s *snapshot, now time.Time) *uiPage {
+	b := &uiBuilder{
+		page:   &uiPage{Age: now.Sub(s.end)},
+		tables: map[uiTableKey]*uiTable{},
+		chains: map[uiChainKey]*uiChain{},
+	}
+
+	fams := gatherSnapshot(s)
+	for _, mf := range fams {
+		for _, m := range mf.Metric {
+			b.add(mf.GetName(), m)
+		}
+	}
+
+	var prev map[string]*ruleSample
+	if s.prev != nil && s.end.After(s.prev.end) {
+		prev = ruleSamples(gatherSnapshot(s.prev))
+		b.page.Interval = s.end.Sub(s.prev.end)
+	}
+	for k, rs := range ruleSamples(fams) {
+		r := &uiRule{Metric: rs.metric, Labels: rs.labels, Packets: rs.packets, Bytes: rs.bytes}
+		if p := prev[k]; p != nil && rs.packets >= p.packets && rs.bytes >= p.bytes {
+			secs := b.page.Interval.Seconds()
+			r.HasRate = true
+			r.PacketRate = (rs.packets - p.packets) / secs
+			r.ByteRate = (rs.bytes - p.bytes) / secs
+		}
+		cn := b.chain(rs.chain)
+		cn.Rules = append(cn.Rules, r)
+	}
+
+	b.sort()
+	return b.page
+}
+
+// add adds the table, chain or set described by a metric.
+func (b *uiBuilder) add(name string, m *dto.Metric) {
+	tk := uiTableKey{labelValue(m, "netns"), labelValue(m, "family"), labelValue(m, "table")}
+	switch name {
+	case "nftables_table_metadata":
+		b.table(tk).Flags = labelValue(m, "flags")
+
+	case "nftables_chain_metadata":
+		cn := b.chain(uiChainKey{tk, labelValue(m, "chain")})
+		cn.Type = labelValue(m, "type")
+		cn.Hook = labelValue(m, "hook")
+		cn.Policy = labelValue(m, "policy")
+		cn.Priority = labelValue(m, "priority")
+
+	case "nftables_chain_rule_count":
+		b.chain(uiChainKey{tk, labelValue(m, "chain")}).RuleCount = m.GetGauge().GetValue()
+
+	case "nftables_set_size":
+		t := b.table(tk)
+		t.Sets = append(t.Sets, &uiSet{Name: labelValue(m, "set"), Size: m.GetGauge().GetValue()})
+	}
+}
+
+// table returns the table, adding it if needed.
+func (b *uiBuilder) table(k uiTableKey) *uiTable {
+	t := b.tables[k]
+	if t == nil {
+		t = &uiTable{NetNS: k.netns, Family: k.family, Name: k.table}
+		b.tables[k] = t
+		b.page.Tables = append(b.page.Tables, t)
+	}
+	return t
+}
+
+// chain returns the chain, adding it and its table if needed.
+func (b *uiBuilder) chain(k uiChainKey) *uiChain {
+	cn := b.chains[k]
+	if cn == nil {
+		cn = &uiChain{Name: k.chain}
+		b.chains[k] = cn
+		t := b.table(k.uiTableKey)
+		t.Chains = append(t.Chains, cn)
+	}
+	return cn
+}
+
+// sort orders everything by name, since collection order isn't
+// stable.
+func (b *uiBuilder) sort() {
+	sort.Slice(b.page.Tables, func(i, j int) bool {
+		ti, tj := b.page.Tables[i], b.page.Tables[j]
+		if ti.NetNS != tj.NetNS {
+			return ti.NetNS < tj.NetNS
+		}
+		if ti.Family != tj.Family {
+			return ti.Family < tj.Family
+		}
+		return ti.Name < tj.Name
+	})
+	for _, t := range b.page.Tables {
+		sort.Slice(t.Chains, func(i, j int) bool { return t.Chains[i].Name < t.Chains[j].Name })
+		sort.Slice(t.Sets, func(i, j int) bool { return t.Sets[i].Name < t.Sets[j].Name })
+		for _, cn := range t.Chains {
+			rs := cn.Rules
+			sort.Slice(rs, func(i, j int) bool {
+				if rs[i].Metric != rs[j].Metric {
+					return rs[i].Metric < rs[j].Metric
+				}
+				return rs[i].Labels < rs[j].Labels
+			})
+		}
+	}
+}
+
+// A ruleSample is the counters of a rule metric.
+type ruleSample struct {
+	chain          uiChainKey
+	metric, labels string
+	packets, bytes float64
+}
+
+// ruleSamples returns the rule counters of the metric families, by
+// chain, metric name and labels.
+func ruleSamples(fams []*dto.MetricFamily) map[string]*ruleSample {
+	rss := map[string]*ruleSample{}
+	for _, mf := range fams {
+		name := mf.GetName()
+		if !strings.HasPrefix(name, "nftables_rule_") {
+			continue
+		}
+
+		var metric string
+		var isBytes bool
+		switch {
+		case strings.HasSuffix(name, "_packet_count"):
+			metric = strings.TrimSuffix(name, "_packet_count")
+		case strings.HasSuffix(name, "_byte_count"):
+			metric = strings.TrimSuffix(name, "_byte_count")
+			isBytes = true
+		default:
+			continue
+		}
+		metric = strings.TrimPrefix(strings.TrimPrefix(metric, "nftables_rule"), "_")
+
+		for _, m := range mf.Metric {
+			ck := uiChainKey{uiTableKey{labelValue(m, "netns"), labelValue(m, "family"), labelValue(m, "table")}, labelValue(m, "chain")}
+			labels := ruleLabelsString(m)
+			k := strings.Join([]string{ck.netns, ck.family, ck.table, ck.chain, metric, labels}, "\x00")
+
+			rs := rss[k]
+			if rs == nil {
+				rs = &ruleSample{chain: ck, metric: metric, labels: labels}
+				rss[k] = rs
+			}
+			if isBytes {
+				rs.bytes = m.GetCounter().GetValue()
+			} else {
+				rs.packets = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return rss
+}
+
+// ruleLabelsString formats the labels identifying a rule within its
+// chain. A lone comment label is just its value.
+func ruleLabelsString(m *dto.Metric) string {
+	var lps []*dto.LabelPair
+	for _, lp := range m.Label {
+		switch lp.GetName() {
+		case "netns", "container_id", "pod_uid", "family", "table", "chain":
+			continue
+		}
+		lps = append(lps, lp)
+	}
+	if len(lps) == 1 && lps[0].GetName() == "comment" {
+		return lps[0].GetValue()
+	}
+
+	var ss []string
+	for _, lp := range lps {
+		ss = append(ss, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+	}
+	return strings.Join(ss, ", ")
+}
+
+// labelValue returns the value of a label, or empty.
+func labelValue(m *dto.Metric, name string) string {
+	for _, lp := range m.Label {
+		if lp.GetName() == name {
+			return lp.GetValue()
+		}
+	}
+	return ""
+}
+
+// gatherSnapshot returns the metric families of a snapshot. Problems
+// are logged, and the families gathered anyway are returned.
+func gatherSnapshot(s *snapshot) []*dto.MetricFamily {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(metricsCollector(s.metrics))
+	fams, err := reg.Gather()
+	if err != nil {
+		log.Printf("Gathering snapshot for the UI: %v (ignored)", err)
+	}
+	return fams
+}
+
+// A metricsCollector is an unchecked collector of fixed metrics.
+type metricsCollector []prometheus.Metric
+
+// Describe implements prometheus.Collector.
+func (c metricsCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tommie/prometheus-nftables-exporter/nftcollector"
+)
+
+func TestNewUIPage(t *testing.T) {
+	table := &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}
+	newConn := func(packets uint64) *fakeNFTConn {
+		return &fakeNFTConn{
+			tables: []*nftables.Table{table},
+			chains: []*nftables.Chain{
+				{Name: "input", Table: table, Hooknum: nftables.ChainHookInput, Type: nftables.ChainTypeFilter},
+			},
+			sets: map[string][]*nftcollector.Set{
+				"filter": {{Set: &nftables.Set{Name: "allow", KeyType: nftables.TypeIPAddr}}},
+			},
+			setEls: map[string][]nftcollector.SetElement{
+				"allow": {nftcollector.SetElement{}, nftcollector.SetElement{}},
+			},
+			rules: map[string][]*nftcollector.Rule{
+				"filter/input": {
+					{Rule: &nftables.Rule{Table: table, Chain: &nftables.Chain{Name: "input"},
+						Exprs:    []expr.Any{&expr.Counter{Packets: packets, Bytes: 100 * packets}},
+						UserData: makeRuleComment("web")}},
+				},
+			},
+		}
+	}
+
+	start := time.Unix(1000, 0)
+	prev := collectSnapshot(newTestCollector(newConn(10)), start)
+	s := collectSnapshot(newTestCollector(newConn(30)), start.Add(10*time.Second))
+	s.prev = prev
+
+	got := newUIPage(s, start.Add(12*time.Second))
+
+	if got.Age != 2*time.Second {
+		t.Errorf("Age: got %v, want %v", got.Age, 2*time.Second)
+	}
+	if got.Interval != 10*time.Second {
+		t.Errorf("Interval: got %v, want %v", got.Interval, 10*time.Second)
+	}
+	if len(got.Tables) != 1 {
+		t.Fatalf("Tables: got %+v, want 1", got.Tables)
+	}
+	tbl := got.Tables[0]
+	if tbl.Family != "inet" || tbl.Name != "filter" {
+		t.Errorf("Tables[0]: got %+v, want inet filter", tbl)
+	}
+	if len(tbl.Sets) != 1 || *tbl.Sets[0] != (uiSet{Name: "allow", Size: 2}) {
+		t.Errorf("Sets: got %+v, want allow of size 2", tbl.Sets)
+	}
+	if len(tbl.Chains) != 1 {
+		t.Fatalf("Chains: got %+v, want 1", tbl.Chains)
+	}
+	cn := tbl.Chains[0]
+	if cn.Name != "input" || cn.Type != "filter" || cn.Hook != "input" || cn.RuleCount != 1 {
+		t.Errorf("Chains[0]: got %+v, want filter input with hook input and 1 rule", cn)
+	}
+	if len(cn.Rules) != 1 {
+		t.Fatalf("Rules: got %+v, want 1", cn.Rules)
+	}
+	want := uiRule{Labels: "web", Packets: 30, Bytes: 3000, HasRate: true, PacketRate: 2, ByteRate: 200}
+	if *cn.Rules[0] != want {
+		t.Errorf("Rules[0]: got %+v, want %+v", *cn.Rules[0], want)
+	}
+}
+
+func TestRuleLabelsString(t *testing.T) {
+	tsts := []struct {
+		Name   string
+		Labels []string // Name and value pairs.
+		Want   string
+	}{
+		{"comment", []string{"chain", "input", "comment", "web"}, "web"},
+		{"extra", []string{"chain", "input", "comment", "web", "svc", "http"}, `comment="web", svc="http"`},
+		{"json", []string{"netns", "", "svc", "http"}, `svc="http"`},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			var m dto.Metric
+			for i := 0; i < len(tst.Labels); i += 2 {
+				m.Label = append(m.Label, makeLabelPair(tst.Labels[i], tst.Labels[i+1]))
+			}
+			if got := ruleLabelsString(&m); got != tst.Want {
+				t.Errorf("ruleLabelsString: got %q, want %q", got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestUIHandler(t *testing.T) {
+	table := &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}
+	conn := &fakeNFTConn{
+		tables: []*nftables.Table{table},
+		chains: []*nftables.Chain{
+			{Name: "input", Table: table, Hooknum: nftables.ChainHookInput, Type: nftables.ChainTypeFilter},
+			{Name: "web", Table: table},
+		},
+	}
+	h := newUIHandler(newSnapshotCollector(newTestCollector(conn)))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("ServeHTTP status: got %v, want %v", w.Code, http.StatusOK)
+	}
+	for _, want := range []string{"table inet filter", "filter hook input"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("ServeHTTP: want %q, got:\n%s", want, w.Body.String())
+		}
+	}
+	// The regular chain has hook number 0, but no hook.
+	if notWant := "prerouting"; strings.Contains(w.Body.String(), notWant) {
+		t.Errorf("ServeHTTP: don't want %q, got:\n%s", notWant, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP status: got %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUIHandlerLastSnapshot(t *testing.T) {
+	coll := &fakeCollector{release: make(chan struct{})}
+	close(coll.release)
+	h := newUIHandler(newSnapshotCollector(coll))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("ServeHTTP status: got %v, want %v", w.Code, http.StatusOK)
+		}
+	}
+	// Only the first page load collects.
+	if got := coll.numCalls(); got != 1 {
+		t.Errorf("calls: got %v, want 1", got)
+	}
+
+	// Scrapes still collect, and later page loads show the result.
+	testutil.CollectAndCount(h.snap)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := coll.numCalls(); got != 2 {
+		t.Errorf("calls: got %v, want 2", got)
+	}
+}
+
+// collectSnapshot collects from coll into a snapshot ending at end.
+func collectSnapshot(coll prometheus.Collector, end time.Time) *snapshot {
+	s := &snapshot{end: end}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		coll.Collect(ch)
+	}()
+	for m := range ch {
+		s.metrics = append(s.metrics, m)
+	}
+	return s
+}
@@ -1,12 +1,14 @@
 module github.com/tommie/prometheus-nftables-exporter
 
-go 1.15
+go 1.16
 
 require (
 	github.com/google/nftables v0.0.0-20210916140115-16a134723a96
-	github.com/mdlayher/netlink v1.4.1 // indirect
+	github.com/mdlayher/netlink v1.4.1
 	github.com/mdlayher/socket v0.0.0-20210624160740-9dbe287ded84 // indirect
 	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
 	golang.org/x/net v0.0.0-20210916014120-12bc252f5db8 // indirect
 	golang.org/x/sys v0.0.0-20210915083310-ed5796bab164
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,25 @@
+// Package nfmsg contains utilities to decode NFTables netlink messages.
+package nfmsg
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mdlayher/netlink"
+)
+
+// NewDecoder returns a decoder for the attributes of an NFTables
+// message, skipping the nfgenmsg header.
+func NewDecoder(msg netlink.Message) (*netlink.AttributeDecoder, error) {
+	if len(msg.Data) < 4 {
+		return nil, fmt.Errorf("short NFTables message: %d bytes", len(msg.Data))
+	}
+
+	ad, err := netlink.NewAttributeDecoder(msg.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	ad.ByteOrder = binary.BigEndian
+
+	return ad, nil
+}
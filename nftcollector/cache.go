@@ -0,0 +1,136 @@
+package nftcollector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/nftables"
+)
+
+const (
+	// metadataCacheTTL is how long an unused namespace stays in a
+	// metadataCache.
+	metadataCacheTTL = 10 * time.Minute
+)
+
+// A MetadataCache keeps tables, chains and sets between collections,
+// per network namespace, as long as the rule set generation is
+// unchanged. Rules, objects and set elements hold counters, and are
+// never cached. Dynamic sets change without a new generation. It is
+// safe for concurrent use, and can be shared by collectors.
+type MetadataCache struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*metadataEntry // Key is the netns label.
+}
+
+// A metadataEntry is the cached metadata of a namespace.
+type metadataEntry struct {
+	gen  uint32
+	used time.Time
+
+	mu         sync.Mutex
+	haveTables bool
+	tables     []*nftables.Table
+	haveChains bool
+	chains     []*nftables.Chain
+	sets       map[*nftables.Table][]*Set
+}
+
+// NewMetadataCache creates an empty cache.
+func NewMetadataCache() *MetadataCache {
+	return &MetadataCache{
+		now:     time.Now,
+		entries: map[string]*metadataEntry{},
+	}
+}
+
+// Invalidate drops all entries, e.g. when rule set changes may have
+// gone unnoticed. Collections in progress keep their entries.
+func (mc *MetadataCache) Invalidate() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.entries = map[string]*metadataEntry{}
+}
+
+// conn returns a connection caching metadata of the namespace, for the
+// given generation. Entries of other generations, and unused
+// namespaces, are dropped.
+func (mc *MetadataCache) conn(conn Conn, netns string, gen uint32) Conn {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	now := mc.now()
+	for k, e := range mc.entries {
+		if now.Sub(e.used) > metadataCacheTTL {
+			delete(mc.entries, k)
+		}
+	}
+
+	e := mc.entries[netns]
+	if e == nil || e.gen != gen {
+		e = &metadataEntry{gen: gen, sets: map[*nftables.Table][]*Set{}}
+		mc.entries[netns] = e
+	}
+	e.used = now
+
+	return &cachedConn{Conn: conn, e: e}
+}
+
+// A cachedConn is a Conn using a metadataEntry for tables, chains
+// and sets.
+type cachedConn struct {
+	Conn
+	e *metadataEntry
+}
+
+// ListTables implements Conn.
+func (c *cachedConn) ListTables() ([]*nftables.Table, error) {
+	c.e.mu.Lock()
+	defer c.e.mu.Unlock()
+
+	if !c.e.haveTables {
+		ts, err := c.Conn.ListTables()
+		if err != nil {
+			return nil, err
+		}
+		c.e.tables = ts
+		c.e.haveTables = true
+	}
+	return c.e.tables, nil
+}
+
+// ListChains implements Conn.
+func (c *cachedConn) ListChains() ([]*nftables.Chain, error) {
+	c.e.mu.Lock()
+	defer c.e.mu.Unlock()
+
+	if !c.e.haveChains {
+		cns, err := c.Conn.ListChains()
+		if err != nil {
+			return nil, err
+		}
+		c.e.chains = cns
+		c.e.haveChains = true
+	}
+	return c.e.chains, nil
+}
+
+// GetSets implements Conn. Tables must come from ListTables.
+func (c *cachedConn) GetSets(t *nftables.Table) ([]*Set, error) {
+	c.e.mu.Lock()
+	defer c.e.mu.Unlock()
+
+	sts, ok := c.e.sets[t]
+	if !ok {
+		var err error
+		sts, err = c.Conn.GetSets(t)
+		if err != nil {
+			return nil, err
+		}
+		c.e.sets[t] = sts
+	}
+	return sts, nil
+}
@@ -0,0 +1,108 @@
+package nftcollector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNFTCollectorCache(t *testing.T) {
+	conn := fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		},
+		chains: []*nftables.Chain{
+			{Name: "chain1", Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}},
+		},
+		sets: map[string][]*Set{
+			"table1": {{Set: &nftables.Set{Name: "set1", KeyType: nftables.TypeIPAddr}}},
+		},
+		setEls: map[string][]SetElement{
+			"set1": {SetElement{}},
+		},
+		gen: 42,
+	}
+	c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+
+	want := `
+# HELP nftables_ruleset_generation Generation ID of the rule set. Changes on every transaction.
+# TYPE nftables_ruleset_generation gauge
+nftables_ruleset_generation 42
+# HELP nftables_set_size Number of elements in the set.
+# TYPE nftables_set_size gauge
+nftables_set_size{family="inet",set="set1",table="table1"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_ruleset_generation", "nftables_set_size"); err != nil {
+		t.Fatalf("CollectAndCompare: %v", err)
+	}
+	if conn.metaCalls != 3 {
+		t.Fatalf("metaCalls: got %v, want 3", conn.metaCalls)
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		conn.metaCalls = 0
+		// Dynamic sets change without a new generation.
+		conn.setEls["set1"] = append(conn.setEls["set1"], SetElement{})
+
+		want := `
+# HELP nftables_set_size Number of elements in the set.
+# TYPE nftables_set_size gauge
+nftables_set_size{family="inet",set="set1",table="table1"} 2
+`
+		if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_set_size"); err != nil {
+			t.Errorf("CollectAndCompare: %v", err)
+		}
+		if conn.metaCalls != 0 {
+			t.Errorf("metaCalls: got %v, want 0", conn.metaCalls)
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		conn.metaCalls = 0
+		conn.gen++
+		conn.tables = append(conn.tables, &nftables.Table{Name: "table2", Family: nftables.TableFamilyIPv4})
+
+		want := `
+# HELP nftables_table_metadata Metadata about each table. Value is always 1.
+# TYPE nftables_table_metadata gauge
+nftables_table_metadata{family="inet",flags="",table="table1"} 1
+nftables_table_metadata{family="ip",flags="",table="table2"} 1
+`
+		if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_table_metadata"); err != nil {
+			t.Errorf("CollectAndCompare: %v", err)
+		}
+		if conn.metaCalls != 4 {
+			t.Errorf("metaCalls: got %v, want 4", conn.metaCalls)
+		}
+	})
+}
+
+func TestMetadataCacheExpiry(t *testing.T) {
+	mc := NewMetadataCache()
+	now := time.Unix(1600000000, 0)
+	mc.now = func() time.Time { return now }
+
+	mc.conn(&fakeNFTConn{}, "old", 1)
+	now = now.Add(metadataCacheTTL + time.Second)
+	mc.conn(&fakeNFTConn{}, "", 1)
+
+	if _, ok := mc.entries["old"]; ok {
+		t.Errorf("entries: got expired namespace %q", "old")
+	}
+	if _, ok := mc.entries[""]; !ok {
+		t.Errorf("entries: missing namespace %q", "")
+	}
+}
+
+func TestMetadataCacheInvalidate(t *testing.T) {
+	mc := NewMetadataCache()
+	mc.conn(&fakeNFTConn{}, "", 1)
+	mc.Invalidate()
+
+	if len(mc.entries) != 0 {
+		t.Errorf("entries: got %v, want none", mc.entries)
+	}
+}
@@ -0,0 +1,938 @@
+// Package nftcollector is a Prometheus collector exporting metrics
+// about the Netfilter Tables rule set. It doesn't register anything
+// globally, so several collectors can be used side by side.
+package nftcollector
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+// Descriptions of the exported metrics. They don't change between
+// releases. Rule metrics get more labels from Options.RuleLabels and
+// Options.RuleDuplicates, and other names from JSON comments.
+var (
+	GenerationDesc = prometheus.NewDesc("nftables_ruleset_generation", "Generation ID of the rule set. Changes on every transaction.", nil, nil)
+
+	TableDesc = prometheus.NewDesc("nftables_table_metadata", "Metadata about each table. Value is always 1.", []string{"family", "table" /* values: */, "flags"}, nil)
+	ChainDesc = prometheus.NewDesc("nftables_chain_metadata", "Metadata about each chain. Value is always 1.", []string{"family", "table", "chain" /* values: */, "hook", "policy", "priority", "type"}, nil)
+	SetDesc   = prometheus.NewDesc("nftables_set_metadata", "Metadata about each set. Value is always 1.", []string{"family", "table", "set" /* values: */, "ismap", "keytype", "datatype"}, nil)
+
+	ChainRuleCountDesc       = prometheus.NewDesc("nftables_chain_rule_count", "Total rule count in chain.", []string{"family", "table", "chain"}, nil)
+	RuleDuplicatesDesc       = prometheus.NewDesc("nftables_rule_duplicate_comments", "Number of exported rules in chain with the same labels as an earlier rule.", []string{"family", "table", "chain"}, nil)
+	PacketCounterDesc        = prometheus.NewDesc("nftables_counter_packet_count", "Number of packets triggering the counter.", []string{"family", "table", "counter"}, nil)
+	ByteCounterDesc          = prometheus.NewDesc("nftables_counter_byte_count", "Number of bytes triggering the counter.", []string{"family", "table", "counter"}, nil)
+	SetSizeDesc              = prometheus.NewDesc("nftables_set_size", "Number of elements in the set.", []string{"family", "table", "set"}, nil)
+	QuotaLimitDesc           = prometheus.NewDesc("nftables_quota_limit_bytes", "Number of bytes allowed by the quota.", []string{"family", "table", "quota"}, nil)
+	QuotaConsumedDesc        = prometheus.NewDesc("nftables_quota_consumed_bytes", "Number of bytes consumed from the quota.", []string{"family", "table", "quota"}, nil)
+	QuotaExceededDesc        = prometheus.NewDesc("nftables_quota_exceeded", "Whether the quota has been used up. Value is 0 or 1.", []string{"family", "table", "quota" /* values: */, "inverted"}, nil)
+	ElementPacketCounterDesc = prometheus.NewDesc("nftables_set_element_packet_count", "Number of packets matching the set element.", []string{"family", "table", "set", "element"}, nil)
+	ElementByteCounterDesc   = prometheus.NewDesc("nftables_set_element_byte_count", "Number of bytes matching the set element.", []string{"family", "table", "set", "element"}, nil)
+
+	RulePacketCounterDesc, RuleByteCounterDesc = ruleDescs("", []string{"comment"})
+
+	// TruncatedDesc is labeled by one of the Section* constants.
+	TruncatedDesc = prometheus.NewDesc("nftables_scrape_truncated", "Whether the collection of a section was abandoned because the scrape timed out. Value is 0 or 1.", []string{"section"}, nil)
+)
+
+// A Collector uses an NFTables connection to export some metadata
+// and statistics about Netfilters.
+type Collector struct {
+	conn              Conn
+	netns             string // Only used to label failures.
+	ruleCommentFilter func(string) bool
+	counterNameFilter func(string) bool
+	setNameFilter     func(string) bool
+	quotaNameFilter   func(string) bool
+
+	// ruleLabels extracts extra labels for rules from the comment,
+	// by its named capture groups. Nil if there are none.
+	ruleLabels *regexp.Regexp
+
+	// ruleLabelNames are the labels of rulePacketCounterDesc and
+	// ruleByteCounterDesc after family, table and chain.
+	ruleLabelNames []string
+
+	// ruleDuplicates selects what to do with rules in a chain
+	// having the same labels.
+	ruleDuplicates RuleDuplicatesMode
+
+	// counters makes rule counters monotonic, if not nil.
+	counters *CounterTracker
+
+	// cache keeps metadata while the generation is unchanged, if not
+	// nil.
+	cache *MetadataCache
+
+	// metrics receives metrics about collections.
+	metrics *Metrics
+
+	// ruleErrors logs errors of rules only once, as they would
+	// otherwise be logged on every scrape.
+	ruleErrors *logOnce
+
+	// filters are applied before the name filters. See Filter.
+	filters []Filter
+
+	// ruleText selects what identifies rules without comments.
+	ruleText RuleTextMode
+
+	// ruleCommentFormat selects how rule comments are parsed.
+	ruleCommentFormat RuleCommentFormat
+
+	// setElementFilter selects sets whose element counters are
+	// exported. At most setElementLimit elements are exported per
+	// set, unless it is zero or less. If setElementTop is true, the
+	// elements with the most bytes are exported when the limit is
+	// exceeded. Otherwise none are.
+	setElementFilter func(string) bool
+	setElementLimit  int
+	setElementTop    bool
+
+	// rulePacketCounterDesc and ruleByteCounterDesc are
+	// RulePacketCounterDesc and RuleByteCounterDesc, with the labels
+	// of ruleLabelNames.
+	rulePacketCounterDesc *prometheus.Desc
+	ruleByteCounterDesc   *prometheus.Desc
+}
+
+// A RuleTextMode selects how rules without comments are identified.
+type RuleTextMode string
+
+const (
+	// RuleTextNone ignores rules without comments.
+	RuleTextNone RuleTextMode = ""
+
+	// RuleTextPlain uses the rendered rule expressions.
+	RuleTextPlain RuleTextMode = "text"
+
+	// RuleTextHash uses a hash of the rendered rule expressions.
+	RuleTextHash RuleTextMode = "hash"
+)
+
+// A RuleDuplicatesMode selects what to do with rules in a chain having
+// the same labels.
+type RuleDuplicatesMode string
+
+const (
+	// RuleDuplicatesSum sums the counters of the rules.
+	RuleDuplicatesSum RuleDuplicatesMode = ""
+
+	// RuleDuplicatesHandle adds a handle label to all rules.
+	RuleDuplicatesHandle RuleDuplicatesMode = "handle"
+
+	// RuleDuplicatesPosition adds a position label to all rules,
+	// the zero-based index of the rule in the chain.
+	RuleDuplicatesPosition RuleDuplicatesMode = "position"
+)
+
+// A Conn reads the rule set. It is implemented by *NLConn, *JSONConn
+// and the connections of NewReplayConn.
+type Conn interface {
+	GetGen() (uint32, error)
+	ListTables() ([]*nftables.Table, error)
+	ListChains() ([]*nftables.Chain, error)
+	GetObjects(*nftables.Table) ([]nftables.Obj, error)
+	GetQuotas(*nftables.Table) ([]*Quota, error)
+	GetTableRules(*nftables.Table) (map[string][]*Rule, error)
+	GetSets(*nftables.Table) ([]*Set, error)
+	GetSetElements(*nftables.Set) ([]SetElement, error)
+}
+
+// A snapshotter is a Conn that can keep the rule set a collection
+// started with, even if another collection reads a newer one. It is
+// implemented by *JSONConn. The kernel has no such thing.
+type snapshotter interface {
+	snapshot() Conn
+}
+
+// collectionConn returns the connection a collection should use.
+func collectionConn(conn Conn) Conn {
+	if s, ok := conn.(snapshotter); ok {
+		return s.snapshot()
+	}
+	return conn
+}
+
+// Options select what a Collector exports. The zero value exports all
+// named objects and rules with comments, but no set element counters.
+type Options struct {
+	// RuleComments, CounterNames, SetNames and QuotaNames select
+	// objects by comment or name. Nil includes all.
+	RuleComments func(string) bool
+	CounterNames func(string) bool
+	SetNames     func(string) bool
+	QuotaNames   func(string) bool
+
+	// Filters are applied before the name filters, and the first
+	// matching one decides.
+	Filters []Filter
+
+	// RuleLabels adds a label to rule metrics for each named capture
+	// group, whose value is what the group matches in the comment.
+	// Group names must be valid label names, and not clash with
+	// other labels. Nil adds none.
+	RuleLabels *regexp.Regexp
+
+	// RuleText selects what identifies rules without comments.
+	RuleText RuleTextMode
+
+	// RuleCommentFormat selects how rule comments are parsed.
+	RuleCommentFormat RuleCommentFormat
+
+	// RuleDuplicates selects what to do with rules in a chain having
+	// the same labels.
+	RuleDuplicates RuleDuplicatesMode
+
+	// SetElementCounters selects sets whose element counters are
+	// exported. Nil selects none. At most SetElementLimit elements
+	// are exported per set. Zero or less means no limit, so a large
+	// set gives a metric per element. If SetElementTop is true, the
+	// elements with the most bytes are exported when the limit is
+	// exceeded. Otherwise none are.
+	SetElementCounters func(string) bool
+	SetElementLimit    int
+	SetElementTop      bool
+
+	// Counters makes rule counters monotonic, if not nil.
+	Counters *CounterTracker
+
+	// Cache keeps metadata between collections. Sharing one keeps
+	// it across collectors, e.g. configuration reloads. Nil uses a
+	// cache of this collector.
+	Cache *MetadataCache
+
+	// NetNS is the netns label of collection failures.
+	NetNS string
+
+	// Metrics receives metrics about collections, if not nil. It is
+	// up to the caller to register them.
+	Metrics *Metrics
+}
+
+// New creates a collector reading the rule set from conn. It doesn't
+// register anything.
+func New(conn Conn, opts Options) (*Collector, error) {
+	switch opts.RuleText {
+	case RuleTextNone, RuleTextPlain, RuleTextHash:
+	default:
+		return nil, fmt.Errorf("invalid rule text mode: %q", opts.RuleText)
+	}
+	switch opts.RuleCommentFormat {
+	case RuleCommentPlain, RuleCommentJSON:
+	default:
+		return nil, fmt.Errorf("invalid rule comment format: %q", opts.RuleCommentFormat)
+	}
+	switch opts.RuleDuplicates {
+	case RuleDuplicatesSum, RuleDuplicatesHandle, RuleDuplicatesPosition:
+	default:
+		return nil, fmt.Errorf("invalid rule duplicates mode: %q", opts.RuleDuplicates)
+	}
+
+	setElementFilter := opts.SetElementCounters
+	if setElementFilter == nil {
+		setElementFilter = func(string) bool { return false }
+	}
+
+	c := newCollector(conn, orAll(opts.RuleComments), orAll(opts.CounterNames), orAll(opts.SetNames), orAll(opts.QuotaNames), opts.RuleText, setElementFilter, opts.SetElementLimit, opts.SetElementTop)
+	c.netns = opts.NetNS
+	c.filters = opts.Filters
+	c.ruleCommentFormat = opts.RuleCommentFormat
+	c.counters = opts.Counters
+	if opts.Cache != nil {
+		c.cache = opts.Cache
+	}
+	if opts.Metrics != nil {
+		c.metrics = opts.Metrics
+	}
+	if opts.RuleLabels != nil {
+		if err := c.setRuleLabels(opts.RuleLabels); err != nil {
+			return nil, err
+		}
+	}
+	c.setRuleDuplicates(opts.RuleDuplicates)
+	return c, nil
+}
+
+// orAll returns the filter, or one including all if it is nil.
+func orAll(filter func(string) bool) func(string) bool {
+	if filter == nil {
+		return func(string) bool { return true }
+	}
+	return filter
+}
+
+// newCollector creates a new collector. Objects are exported if the
+// filter returns true. Rules without comments are identified as
+// selected by ruleText. Element counters are exported for sets
+// matching setElementFilter, see Collector. Metrics about collections
+// are not exported.
+func newCollector(conn Conn, ruleCommentFilter, counterNameFilter, setNameFilter, quotaNameFilter func(string) bool, ruleText RuleTextMode, setElementFilter func(string) bool, setElementLimit int, setElementTop bool) *Collector {
+	return &Collector{
+		conn:              conn,
+		ruleCommentFilter: ruleCommentFilter,
+		counterNameFilter: counterNameFilter,
+		setNameFilter:     setNameFilter,
+		quotaNameFilter:   quotaNameFilter,
+		ruleText:          ruleText,
+		setElementFilter:  setElementFilter,
+		setElementLimit:   setElementLimit,
+		setElementTop:     setElementTop,
+		ruleLabelNames:    []string{"comment"},
+		cache:             NewMetadataCache(),
+		metrics:           NewMetrics(),
+		ruleErrors:        &logOnce{},
+
+		rulePacketCounterDesc: RulePacketCounterDesc,
+		ruleByteCounterDesc:   RuleByteCounterDesc,
+	}
+}
+
+// WithConn returns a copy of the collector reading from conn. The
+// netns labels collection failures. The copy shares the metadata
+// cache and rule counter state, which are kept per namespace.
+func (c *Collector) WithConn(conn Conn, netns string) *Collector {
+	cc := *c
+	cc.conn = conn
+	cc.netns = netns
+	return &cc
+}
+
+// Describe implements prometheus.Collector. With JSON comments,
+// metric names are dynamic, and this is an unchecked collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	if c.ruleCommentFormat == RuleCommentJSON {
+		return
+	}
+
+	ch <- GenerationDesc
+	ch <- TableDesc
+	ch <- ChainDesc
+	ch <- SetDesc
+	ch <- ChainRuleCountDesc
+	ch <- RuleDuplicatesDesc
+	ch <- c.rulePacketCounterDesc
+	ch <- c.ruleByteCounterDesc
+	ch <- PacketCounterDesc
+	ch <- ByteCounterDesc
+	ch <- SetSizeDesc
+	ch <- QuotaLimitDesc
+	ch <- QuotaConsumedDesc
+	ch <- QuotaExceededDesc
+	ch <- ElementPacketCounterDesc
+	ch <- ElementByteCounterDesc
+	ch <- TruncatedDesc
+}
+
+// Collector implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is like Collect, but stops collecting when the
+// context is done. What was collected until then is still exported.
+// Abandoned sections are not counted as failures, but exported as
+// nftables_scrape_truncated.
+func (c *Collector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	trunc := truncatedSections{}
+	defer func() {
+		for _, s := range []string{SectionTables, SectionRules, SectionSetElements} {
+			ch <- prometheus.MustNewConstMetric(TruncatedDesc, prometheus.GaugeValue, trunc.value(s), s)
+		}
+	}()
+
+	// Collects through a copy, whose connection honors the context.
+	cc := *c
+	cc.conn = contextConn{ctx, statsConn{collectionConn(c.conn), c.metrics}}
+	c = &cc
+
+	if gen, err := c.conn.GetGen(); isContextError(err) {
+		// ListTables will fail as well.
+	} else if err != nil {
+		log.Printf("Failed to get NF generation: %v (ignored)", err)
+		c.metrics.CollectionFailures.WithLabelValues(c.netns).Inc()
+	} else {
+		ch <- prometheus.MustNewConstMetric(GenerationDesc, prometheus.GaugeValue, float64(gen))
+
+		if c.cache != nil {
+			c.conn = c.cache.conn(c.conn, c.netns, gen)
+		}
+	}
+
+	timer := newPhaseTimer(c.conn, c.metrics)
+	defer timer.observe()
+	c.conn = timer
+
+	ts, err := c.conn.ListTables()
+	if isContextError(err) {
+		trunc[SectionTables] = true
+		trunc[SectionRules] = true
+		return
+	} else if err != nil {
+		log.Printf("Failed to list NF tables: %v", err)
+		c.metrics.CollectionFailures.WithLabelValues(c.netns).Inc()
+		return
+	}
+
+	for _, t := range ts {
+		if err := c.collectTable(ctx, ch, t, trunc); err != nil {
+			log.Printf("%v (ignored)", err)
+			c.metrics.CollectionFailures.WithLabelValues(c.netns).Inc()
+		}
+	}
+
+	cns, err := c.conn.ListChains()
+	if isContextError(err) {
+		trunc[SectionRules] = true
+		return
+	} else if err != nil {
+		log.Printf("Failed to list NF chains: %v", err)
+		c.metrics.CollectionFailures.WithLabelValues(c.netns).Inc()
+		return
+	}
+
+	trs := map[tableKey]*tableRules{}
+	for _, cn := range cns {
+		if err := c.collectChain(ctx, ch, cn, c.tableRules(trs, cn.Table), trunc); err != nil {
+			log.Printf("%v (ignored)", err)
+			c.metrics.CollectionFailures.WithLabelValues(c.netns).Inc()
+		}
+	}
+
+	if c.counters != nil {
+		if err := c.counters.save(); err != nil {
+			log.Printf("Failed to save rule counter state: %v", err)
+		}
+	}
+}
+
+// collectTable exports metrics about a single table. If the context
+// is done, the tables section is marked truncated.
+func (c *Collector) collectTable(ctx context.Context, ch chan<- prometheus.Metric, t *nftables.Table, trunc truncatedSections) error {
+	if ctx.Err() != nil {
+		trunc[SectionTables] = true
+		return nil
+	}
+
+	ch <- prometheus.MustNewConstMetric(TableDesc, prometheus.GaugeValue, 1, TableFamilyString(t.Family), t.Name, TableFlagMaskString(t.Flags))
+
+	os, err := c.conn.GetObjects(t)
+	if isContextError(err) {
+		trunc[SectionTables] = true
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("listing objects for table %q: %v", t.Name, err)
+	}
+
+	fam := TableFamilyString(t.Family)
+	for _, o := range os {
+		if cnt, ok := o.(*nftables.CounterObj); ok {
+			if reason := c.objectReason(KindCounters, fam, t.Name, cnt.Name); reason != "" {
+				c.metrics.IneligibleCounters.WithLabelValues(fam, t.Name, reason).Inc()
+				continue
+			}
+
+			ch <- prometheus.MustNewConstMetric(PacketCounterDesc, prometheus.CounterValue, float64(cnt.Packets), fam, t.Name, cnt.Name)
+			ch <- prometheus.MustNewConstMetric(ByteCounterDesc, prometheus.CounterValue, float64(cnt.Bytes), fam, t.Name, cnt.Name)
+		}
+	}
+
+	qs, err := c.conn.GetQuotas(t)
+	if isContextError(err) {
+		trunc[SectionTables] = true
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("listing quotas for table %q: %v", t.Name, err)
+	}
+
+	for _, q := range qs {
+		c.collectQuota(ch, fam, t, q)
+	}
+
+	sts, err := c.conn.GetSets(t)
+	if isContextError(err) {
+		trunc[SectionTables] = true
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("listing sets for table %q: %v", t.Name, err)
+	}
+
+	for _, st := range sts {
+		if err := c.collectSet(ctx, ch, fam, t, st, trunc); err != nil {
+			log.Printf("%v (ignored)", err)
+			c.metrics.CollectionFailures.WithLabelValues(c.netns).Inc()
+		}
+	}
+
+	return nil
+}
+
+// A tableKey identifies a table.
+type tableKey struct {
+	family nftables.TableFamily
+	name   string
+}
+
+// tableRules are the rules of a table, by chain name, or the error
+// from listing them.
+type tableRules struct {
+	rules map[string][]*Rule
+	err   error
+}
+
+// tableRules returns the rules of the table, listing them on first
+// use. Listing all rules of a table at once is much faster than
+// listing each chain, when there are many chains.
+func (c *Collector) tableRules(trs map[tableKey]*tableRules, t *nftables.Table) *tableRules {
+	k := tableKey{t.Family, t.Name}
+	tr := trs[k]
+	if tr == nil {
+		tr = &tableRules{}
+		tr.rules, tr.err = c.conn.GetTableRules(t)
+		trs[k] = tr
+	}
+	return tr
+}
+
+// collectChain exports metrics about a single chain, given the rules
+// of its table. If the context is done, or was when listing the
+// rules, the rules section is marked truncated.
+func (c *Collector) collectChain(ctx context.Context, ch chan<- prometheus.Metric, cn *nftables.Chain, tr *tableRules, trunc truncatedSections) error {
+	if ctx.Err() != nil {
+		trunc[SectionRules] = true
+		return nil
+	}
+
+	ch <- prometheus.MustNewConstMetric(ChainDesc, prometheus.GaugeValue, 1, TableFamilyString(cn.Table.Family), cn.Table.Name, cn.Name, HookString(cn.Table.Family, cn.Hooknum), ChainPolicyString(cn.Policy), strconv.FormatInt(int64(cn.Priority), 10), string(cn.Type))
+
+	if isContextError(tr.err) {
+		trunc[SectionRules] = true
+		return nil
+	} else if tr.err != nil {
+		return fmt.Errorf("listing rules of chain %s:%s: %v", cn.Table.Name, cn.Name, tr.err)
+	}
+	rs := tr.rules[cn.Name]
+
+	fam := TableFamilyString(cn.Table.Family)
+	ch <- prometheus.MustNewConstMetric(ChainRuleCountDesc, prometheus.GaugeValue, float64(len(rs)), fam, cn.Table.Name, cn.Name)
+
+	sums := map[string]*ruleCounts{}
+	seen := map[string]bool{}
+	var dups int
+	for i, r := range rs {
+		rc := c.collectRule(cn.Table, r)
+		if rc == nil {
+			continue
+		}
+
+		if seen[rc.key()] {
+			dups++
+		}
+		seen[rc.key()] = true
+
+		switch c.ruleDuplicates {
+		case RuleDuplicatesHandle:
+			rc.addLabel("handle", strconv.FormatUint(r.Handle, 10))
+		case RuleDuplicatesPosition:
+			rc.addLabel("position", strconv.Itoa(i))
+		}
+
+		if prc := sums[rc.key()]; prc != nil {
+			prc.packets += rc.packets
+			prc.bytes += rc.bytes
+			prc.rules[r.Handle] = rc.rules[r.Handle]
+		} else {
+			sums[rc.key()] = rc
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(RuleDuplicatesDesc, prometheus.GaugeValue, float64(dups), fam, cn.Table.Name, cn.Name)
+
+	for _, rc := range sums {
+		pktDesc, byteDesc := c.rulePacketCounterDesc, c.ruleByteCounterDesc
+		if rc.names != nil {
+			pktDesc, byteDesc = ruleDescs(rc.metric, rc.names)
+		}
+		if c.counters != nil {
+			total := c.counters.update(counterKey(c.netns, fam, cn.Table.Name, cn.Name, rc.key()), rc.rules)
+			rc.packets, rc.bytes = total.Packets, total.Bytes
+		}
+		lvs := append([]string{fam, cn.Table.Name, cn.Name}, rc.labels...)
+		ch <- prometheus.MustNewConstMetric(pktDesc, prometheus.CounterValue, float64(rc.packets), lvs...)
+		ch <- prometheus.MustNewConstMetric(byteDesc, prometheus.CounterValue, float64(rc.bytes), lvs...)
+	}
+
+	return nil
+}
+
+// ruleCounts are the summed counters of rules with the same metric
+// and labels.
+type ruleCounts struct {
+	// metric and names are from a JSON comment. Names is nil for
+	// other rules.
+	metric string
+	names  []string
+
+	labels  []string // The values of ruleLabelNames, or of names.
+	packets uint64
+	bytes   uint64
+
+	// rules are the counters of the summed rules, by handle.
+	rules map[uint64]counterSample
+}
+
+// key returns a string identifying the metric and labels.
+func (rc *ruleCounts) key() string {
+	return strings.Join(append(append([]string{rc.metric}, rc.names...), rc.labels...), "\x00")
+}
+
+// addLabel adds a label. The name is only used for JSON comments,
+// since other rules have it in ruleLabelNames.
+func (rc *ruleCounts) addLabel(name, value string) {
+	if rc.names != nil {
+		rc.names = append(rc.names, name)
+	}
+	rc.labels = append(rc.labels, value)
+}
+
+// collectRule returns the counters of a single rule in table t, or
+// nil if it isn't exported. An invalid comment is a problem of the
+// rule, not of the collection, so it is only logged, once per rule.
+func (c *Collector) collectRule(t *nftables.Table, r *Rule) *ruleCounts {
+	rc, reason, err := c.ruleCounts(t, r)
+	if reason != "" {
+		c.metrics.IneligibleRules.WithLabelValues(TableFamilyString(t.Family), t.Name, reason).Inc()
+	}
+	if err != nil {
+		c.ruleErrors.printf(counterKey(c.netns, TableFamilyString(t.Family), t.Name, strconv.FormatUint(r.Handle, 10)), "Table %s %s rule %d: %v (ignored)", TableFamilyString(t.Family), t.Name, r.Handle, err)
+	}
+	return rc
+}
+
+// A logOnce logs a message once per key.
+type logOnce struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// printf logs the message, unless it was already logged for the key.
+func (l *logOnce) printf(key, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.seen[key] {
+		return
+	}
+	if l.seen == nil {
+		l.seen = map[string]bool{}
+	}
+	l.seen[key] = true
+	log.Printf(format, args...)
+}
+
+// ruleCounts returns the counters of a single rule in table t, or
+// the reason it isn't exported. It has no side effects, so the debug
+// report can use it as well.
+func (c *Collector) ruleCounts(t *nftables.Table, r *Rule) (*ruleCounts, string, error) {
+	family := TableFamilyString(t.Family)
+	cmnt, err := RuleComment(r)
+	if err != nil {
+		return nil, "comment-error", fmt.Errorf("extracting rule comment: %v", err)
+	}
+	if cmnt == "" {
+		cmnt = c.ruleIdentity(t.Family, r)
+	}
+	if cmnt == "" {
+		return nil, "no-comment", nil
+	}
+	if !c.include(KindRules, family, t.Name, r.Chain.Name, cmnt, c.ruleCommentFilter) {
+		return nil, "comment-filter", nil
+	}
+
+	cnt := ruleCounter(r.Rule)
+	if cnt == nil {
+		return nil, "no-counter", nil
+	}
+
+	rc := &ruleCounts{labels: append([]string{cmnt}, c.ruleCommentLabels(cmnt)...)}
+	if c.ruleCommentFormat == RuleCommentJSON && isJSONComment(cmnt) {
+		jc, err := parseJSONComment(cmnt)
+		if err != nil {
+			return nil, "comment-error", err
+		}
+		rc.metric = jc.Metric
+		rc.names = jc.labelNames()
+		rc.labels = make([]string, 0, len(rc.names))
+		for _, name := range rc.names {
+			rc.labels = append(rc.labels, jc.Labels[name])
+		}
+	}
+
+	rc.packets = cnt.Packets
+	rc.bytes = cnt.Bytes
+	rc.rules = map[uint64]counterSample{r.Handle: {Packets: cnt.Packets, Bytes: cnt.Bytes}}
+
+	return rc, "", nil
+}
+
+// ruleDescs returns the descriptions of rule metrics, with the given
+// name suffix, as from JSON comments, and extra labels.
+func ruleDescs(metric string, names []string) (*prometheus.Desc, *prometheus.Desc) {
+	prefix := "nftables_rule_"
+	if metric != "" {
+		prefix += metric + "_"
+	}
+	names = append([]string{"family", "table", "chain"}, names...)
+	return prometheus.NewDesc(prefix+"packet_count", "Number of packets matching the rule.", names, nil),
+		prometheus.NewDesc(prefix+"byte_count", "Number of bytes matching the rule.", names, nil)
+}
+
+// ruleCommentLabels returns the values of the ruleLabels capture
+// groups in the comment. Groups that don't match are empty.
+func (c *Collector) ruleCommentLabels(cmnt string) []string {
+	if c.ruleLabels == nil {
+		return nil
+	}
+
+	var vs []string
+	m := c.ruleLabels.FindStringSubmatch(cmnt)
+	for i, name := range c.ruleLabels.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if m == nil {
+			vs = append(vs, "")
+		} else {
+			vs = append(vs, m[i])
+		}
+	}
+	return vs
+}
+
+// setRuleLabels makes the named capture groups of re extra labels of
+// the rule metrics. Group names must be valid label names, and not
+// clash with other labels.
+func (c *Collector) setRuleLabels(re *regexp.Regexp) error {
+	names := append([]string(nil), c.ruleLabelNames...)
+	seen := map[string]bool{}
+	for _, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if err := checkLabelName(name); err != nil {
+			return err
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate label name: %q", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+
+	c.ruleLabels = re
+	c.setRuleLabelNames(names)
+	return nil
+}
+
+// setRuleDuplicates sets the duplicates mode, adding its label to the
+// rule metrics.
+func (c *Collector) setRuleDuplicates(mode RuleDuplicatesMode) {
+	c.ruleDuplicates = mode
+	if mode != RuleDuplicatesSum {
+		c.setRuleLabelNames(append(append([]string(nil), c.ruleLabelNames...), string(mode)))
+	}
+}
+
+// setRuleLabelNames updates the descriptions of rule metrics.
+func (c *Collector) setRuleLabelNames(names []string) {
+	c.ruleLabelNames = names
+	c.rulePacketCounterDesc, c.ruleByteCounterDesc = ruleDescs("", names)
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabelNames are used by the exporter, or by the netns
+// collector.
+var reservedLabelNames = map[string]bool{
+	"family":       true,
+	"table":        true,
+	"chain":        true,
+	"comment":      true,
+	"handle":       true,
+	"position":     true,
+	"netns":        true,
+	"container_id": true,
+	"pod_uid":      true,
+}
+
+// checkLabelName returns an error if name can't be used as an extra
+// label.
+func checkLabelName(name string) error {
+	if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid label name: %q", name)
+	}
+	if reservedLabelNames[name] {
+		return fmt.Errorf("reserved label name: %q", name)
+	}
+	return nil
+}
+
+// include returns true if the object should be exported. The first
+// matching filter decides, and otherwise the name filter does.
+func (c *Collector) include(kind ObjectKind, family, table, chain, name string, nameFilter func(string) bool) bool {
+	for i := range c.filters {
+		if c.filters[i].Match(kind, family, table, chain, name) {
+			return c.filters[i].Include
+		}
+	}
+	return nameFilter(name)
+}
+
+// objectReason returns why a named counter, quota or set isn't
+// exported, or an empty string if it is.
+func (c *Collector) objectReason(kind ObjectKind, family, table, name string) string {
+	nameFilter := c.counterNameFilter
+	switch kind {
+	case KindQuotas:
+		nameFilter = c.quotaNameFilter
+	case KindSets:
+		nameFilter = c.setNameFilter
+	}
+	if !c.include(kind, family, table, "", name, nameFilter) {
+		return "name-filter"
+	}
+	return ""
+}
+
+// ruleIdentity returns the identity of a rule without a comment, or
+// an empty string if it should be ignored.
+func (c *Collector) ruleIdentity(tf nftables.TableFamily, r *Rule) string {
+	switch c.ruleText {
+	case RuleTextPlain:
+		return RuleExprString(tf, r)
+	case RuleTextHash:
+		h := fnv.New64a()
+		io.WriteString(h, RuleExprString(tf, r))
+		return fmt.Sprintf("%016x", h.Sum64())
+	default:
+		return ""
+	}
+}
+
+// collectQuota exports metrics about a single named quota.
+func (c *Collector) collectQuota(ch chan<- prometheus.Metric, family string, t *nftables.Table, q *Quota) {
+	if reason := c.objectReason(KindQuotas, family, t.Name, q.Name); reason != "" {
+		c.metrics.IneligibleQuotas.WithLabelValues(family, t.Name, reason).Inc()
+		return
+	}
+
+	inv := "0"
+	if q.Flags&unix.NFT_QUOTA_F_INV != 0 {
+		inv = "1"
+	}
+	var exceeded float64
+	if q.Consumed >= q.Bytes || q.Flags&unix.NFT_QUOTA_F_DEPLETED != 0 {
+		exceeded = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(QuotaLimitDesc, prometheus.GaugeValue, float64(q.Bytes), family, t.Name, q.Name)
+	ch <- prometheus.MustNewConstMetric(QuotaConsumedDesc, prometheus.GaugeValue, float64(q.Consumed), family, t.Name, q.Name)
+	ch <- prometheus.MustNewConstMetric(QuotaExceededDesc, prometheus.GaugeValue, exceeded, family, t.Name, q.Name, inv)
+}
+
+// collectSet exports metrics about a single set/map. If the context
+// is done before the elements are listed, the set_elements section is
+// marked truncated.
+func (c *Collector) collectSet(ctx context.Context, ch chan<- prometheus.Metric, family string, t *nftables.Table, st *Set, trunc truncatedSections) error {
+	if reason := c.objectReason(KindSets, family, t.Name, st.Name); reason != "" {
+		c.metrics.IneligibleSets.WithLabelValues(family, t.Name, reason).Inc()
+		return nil
+	}
+
+	isMap := "0"
+	if st.IsMap {
+		isMap = "1"
+	}
+	ch <- prometheus.MustNewConstMetric(SetDesc, prometheus.GaugeValue, 1, family, t.Name, st.Name, isMap, st.KeyType.Name, st.DataType.Name)
+
+	if ctx.Err() != nil {
+		trunc[SectionSetElements] = true
+		return nil
+	}
+
+	els, err := c.conn.GetSetElements(st.Set)
+	if isContextError(err) {
+		trunc[SectionSetElements] = true
+		return nil
+	} else if err != nil {
+		c.metrics.IneligibleSets.WithLabelValues(family, t.Name, "elements-error").Inc()
+		return fmt.Errorf("getting elements for set %s/%s/%s: %v", family, t.Name, st.Name, err)
+	}
+
+	ch <- prometheus.MustNewConstMetric(SetSizeDesc, prometheus.GaugeValue, float64(len(els)), family, t.Name, st.Name)
+
+	if c.setElementFilter(st.Name) {
+		c.collectSetElements(ch, family, t, st, els)
+	}
+
+	return nil
+}
+
+// collectSetElements exports the counters of set elements, honoring
+// the cardinality limit.
+func (c *Collector) collectSetElements(ch chan<- prometheus.Metric, family string, t *nftables.Table, st *Set, els []SetElement) {
+	cels, reason := c.setElementCounters(st, els)
+	if reason != "" {
+		c.metrics.IneligibleSets.WithLabelValues(family, t.Name, reason).Inc()
+		return
+	}
+
+	for _, el := range cels {
+		key := SetElementKeyString(st, el)
+		ch <- prometheus.MustNewConstMetric(ElementPacketCounterDesc, prometheus.CounterValue, float64(el.Counter.Packets), family, t.Name, st.Name, key)
+		ch <- prometheus.MustNewConstMetric(ElementByteCounterDesc, prometheus.CounterValue, float64(el.Counter.Bytes), family, t.Name, st.Name, key)
+	}
+}
+
+// setElementCounters returns the elements whose counters are
+// exported, or the reason none are.
+func (c *Collector) setElementCounters(st *Set, els []SetElement) ([]SetElement, string) {
+	if st.Interval {
+		els = setIntervalElements(els)
+	}
+
+	var cels []SetElement
+	for _, el := range els {
+		if el.Counter != nil && !el.IntervalEnd {
+			cels = append(cels, el)
+		}
+	}
+
+	if c.setElementLimit > 0 && len(cels) > c.setElementLimit {
+		if !c.setElementTop {
+			return nil, "element-limit"
+		}
+
+		sort.SliceStable(cels, func(i, j int) bool {
+			return cels[i].Counter.Bytes > cels[j].Counter.Bytes
+		})
+		cels = cels[:c.setElementLimit]
+	}
+
+	return cels, ""
+}
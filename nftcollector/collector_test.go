@@ -0,0 +1,685 @@
+package nftcollector
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/sys/unix"
+)
+
+func TestNFTCollector(t *testing.T) {
+	drop := nftables.ChainPolicyDrop
+	conn := fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+			{Name: "table2", Family: nftables.TableFamilyINet, Flags: unix.NFT_TABLE_F_DORMANT},
+		},
+		chains: []*nftables.Chain{
+			{Name: "chain1", Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}},
+			{Name: "chain2", Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}, Type: nftables.ChainTypeFilter, Hooknum: nftables.ChainHookInput, Priority: 42, Policy: &drop},
+		},
+		objs: map[string][]nftables.Obj{
+			"table1": []nftables.Obj{
+				&nftables.CounterObj{Name: "counter1", Packets: 42, Bytes: 4711},
+			},
+		},
+		rules: map[string][]*Rule{
+			"table1/chain1": []*Rule{
+				{Rule: &nftables.Rule{Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}, Chain: &nftables.Chain{Name: "chain1"}}},
+				{Rule: &nftables.Rule{Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}, Chain: &nftables.Chain{Name: "chain1"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 4, Bytes: 2}},
+					UserData: makeRuleComment("test comment")}},
+			},
+		},
+		sets: map[string][]*Set{
+			"table1": {
+				{Set: &nftables.Set{Name: "set1", IsMap: false, KeyType: nftables.SetDatatype{Name: "ipv4_addr"}}},
+				{Set: &nftables.Set{Name: "map1", IsMap: true, KeyType: nftables.SetDatatype{Name: "ipv4_addr"}, DataType: nftables.SetDatatype{Name: "string"}}},
+			},
+		},
+		setEls: map[string][]SetElement{
+			"set1": {
+				SetElement{},
+				SetElement{},
+			},
+		},
+	}
+	c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+	want := `
+# HELP nftables_chain_metadata Metadata about each chain. Value is always 1.
+# TYPE nftables_chain_metadata gauge
+nftables_chain_metadata{chain="chain1",family="inet",hook="prerouting",policy="accept",priority="0",table="table1",type=""} 1
+nftables_chain_metadata{chain="chain2",family="inet",hook="input",policy="drop",priority="42",table="table1",type="filter"} 1
+
+# HELP nftables_set_metadata Metadata about each set. Value is always 1.
+# TYPE nftables_set_metadata gauge
+nftables_set_metadata{datatype="",family="inet",ismap="0",keytype="ipv4_addr",set="set1",table="table1"} 1
+nftables_set_metadata{datatype="string",family="inet",ismap="1",keytype="ipv4_addr",set="map1",table="table1"} 1
+
+# HELP nftables_table_metadata Metadata about each table. Value is always 1.
+# TYPE nftables_table_metadata gauge
+nftables_table_metadata{family="inet",flags="",table="table1"} 1
+nftables_table_metadata{family="inet",flags="dormant",table="table2"} 1
+
+# HELP nftables_chain_rule_count Total rule count in chain.
+# TYPE nftables_chain_rule_count gauge
+nftables_chain_rule_count{chain="chain1",family="inet",table="table1"} 2
+nftables_chain_rule_count{chain="chain2",family="inet",table="table1"} 0
+
+# HELP nftables_rule_byte_count Number of bytes matching the rule.
+# TYPE nftables_rule_byte_count counter
+nftables_rule_byte_count{chain="chain1",comment="test comment",family="inet",table="table1"} 2
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter
+nftables_rule_packet_count{chain="chain1",comment="test comment",family="inet",table="table1"} 4
+
+# HELP nftables_counter_byte_count Number of bytes triggering the counter.
+# TYPE nftables_counter_byte_count counter
+nftables_counter_byte_count{counter="counter1",family="inet",table="table1"} 4711
+# HELP nftables_counter_packet_count Number of packets triggering the counter.
+# TYPE nftables_counter_packet_count counter
+nftables_counter_packet_count{counter="counter1",family="inet",table="table1"} 42
+
+# HELP nftables_set_size Number of elements in the set.
+# TYPE nftables_set_size gauge
+nftables_set_size{family="inet",set="map1",table="table1"} 0
+nftables_set_size{family="inet",set="set1",table="table1"} 2
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want),
+		"nftables_table_metadata",
+		"nftables_chain_metadata",
+		"nftables_set_metadata",
+		"nftables_chain_rule_count",
+		"nftables_rule_packet_count",
+		"nftables_rule_byte_count",
+		"nftables_counter_packet_count",
+		"nftables_counter_byte_count",
+		"nftables_set_size"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+
+	if conn.ruleCalls != 1 {
+		t.Errorf("ruleCalls: got %v, want one per table", conn.ruleCalls)
+	}
+}
+
+func allFilter(string) bool { return true }
+
+func noneFilter(string) bool { return false }
+
+func TestNFTCollectorRuleCommentFilter(t *testing.T) {
+	conn := fakeNFTConn{
+		chains: []*nftables.Chain{
+			{Name: "chain1", Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}},
+		},
+		rules: map[string][]*Rule{
+			"table1/chain1": []*Rule{
+				{Rule: &nftables.Rule{Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}, Chain: &nftables.Chain{Name: "chain1"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 4, Bytes: 2}},
+					UserData: makeRuleComment("match")}},
+				{Rule: &nftables.Rule{Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}, Chain: &nftables.Chain{Name: "chain1"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 42, Bytes: 4711}},
+					UserData: makeRuleComment("nomatch")}},
+			},
+		},
+	}
+	c := newCollector(&conn, func(s string) bool { return s == "match" }, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+	ineligible := c.metrics.IneligibleRules.WithLabelValues("inet", "table1", "comment-filter")
+	before := testutil.ToFloat64(ineligible)
+	want := `
+# HELP nftables_rule_byte_count Number of bytes matching the rule.
+# TYPE nftables_rule_byte_count counter
+nftables_rule_byte_count{chain="chain1",comment="match",family="inet",table="table1"} 2
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter
+nftables_rule_packet_count{chain="chain1",comment="match",family="inet",table="table1"} 4
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_rule_packet_count", "nftables_rule_byte_count"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+	if got := testutil.ToFloat64(ineligible) - before; got != 1 {
+		t.Errorf("IneligibleRules comment-filter: got %v, want 1", got)
+	}
+}
+
+func TestNFTCollectorRuleText(t *testing.T) {
+	conn := fakeNFTConn{
+		chains: []*nftables.Chain{
+			{Name: "chain1", Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyIPv4}},
+		},
+		rules: map[string][]*Rule{
+			"table1/chain1": []*Rule{
+				{Rule: &nftables.Rule{Table: &nftables.Table{Name: "table1"}, Chain: &nftables.Chain{Name: "chain1"},
+					Exprs: []expr.Any{
+						&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+						&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{10, 0, 0, 1}},
+						&expr.Counter{Packets: 4, Bytes: 2},
+						&expr.Verdict{Kind: expr.VerdictAccept},
+					}}},
+				{Rule: &nftables.Rule{Table: &nftables.Table{Name: "table1"}, Chain: &nftables.Chain{Name: "chain1"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 42, Bytes: 4711}},
+					UserData: makeRuleComment("test comment")}},
+			},
+		},
+	}
+
+	tsts := []struct {
+		Name string
+		Mode RuleTextMode
+		Want string
+	}{
+		{"none", RuleTextNone, `
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter
+nftables_rule_packet_count{chain="chain1",comment="test comment",family="ip",table="table1"} 42
+`},
+		{"text", RuleTextPlain, `
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter
+nftables_rule_packet_count{chain="chain1",comment="ip saddr 10.0.0.1 counter accept",family="ip",table="table1"} 4
+nftables_rule_packet_count{chain="chain1",comment="test comment",family="ip",table="table1"} 42
+`},
+		{"hash", RuleTextHash, `
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter
+nftables_rule_packet_count{chain="chain1",comment="ed2d1eddbbc44c36",family="ip",table="table1"} 4
+nftables_rule_packet_count{chain="chain1",comment="test comment",family="ip",table="table1"} 42
+`},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, tst.Mode, noneFilter, 0, false)
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tst.Want), "nftables_rule_packet_count"); err != nil {
+				t.Errorf("CollectAndCompare: %v", err)
+			}
+		})
+	}
+}
+
+func TestNFTCollectorCounterNameFilter(t *testing.T) {
+	conn := fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		},
+		objs: map[string][]nftables.Obj{
+			"table1": []nftables.Obj{
+				&nftables.CounterObj{Name: "nomatch", Packets: 4, Bytes: 2},
+				&nftables.CounterObj{Name: "match", Packets: 42, Bytes: 4711},
+			},
+		},
+	}
+	c := newCollector(&conn, allFilter, func(s string) bool { return s == "match" }, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+	ineligible := c.metrics.IneligibleCounters.WithLabelValues("inet", "table1", "name-filter")
+	before := testutil.ToFloat64(ineligible)
+	want := `
+# HELP nftables_counter_byte_count Number of bytes triggering the counter.
+# TYPE nftables_counter_byte_count counter
+nftables_counter_byte_count{counter="match",family="inet",table="table1"} 4711
+# HELP nftables_counter_packet_count Number of packets triggering the counter.
+# TYPE nftables_counter_packet_count counter
+nftables_counter_packet_count{counter="match",family="inet",table="table1"} 42
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_counter_packet_count", "nftables_counter_byte_count"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+	if got := testutil.ToFloat64(ineligible) - before; got != 1 {
+		t.Errorf("IneligibleCounters name-filter: got %v, want 1", got)
+	}
+}
+
+func TestNFTCollectorSetNameFilter(t *testing.T) {
+	conn := fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		},
+		sets: map[string][]*Set{
+			"table1": {
+				{Set: &nftables.Set{Name: "nomatch", IsMap: false, KeyType: nftables.SetDatatype{Name: "ipv4_addr"}}},
+				{Set: &nftables.Set{Name: "match", IsMap: true, KeyType: nftables.SetDatatype{Name: "ipv4_addr"}, DataType: nftables.SetDatatype{Name: "string"}}},
+			},
+		},
+	}
+	c := newCollector(&conn, allFilter, allFilter, func(s string) bool { return s == "match" }, allFilter, RuleTextNone, noneFilter, 0, false)
+	ineligible := c.metrics.IneligibleSets.WithLabelValues("inet", "table1", "name-filter")
+	before := testutil.ToFloat64(ineligible)
+	want := `
+# HELP nftables_set_metadata Metadata about each set. Value is always 1.
+# TYPE nftables_set_metadata gauge
+nftables_set_metadata{datatype="string",family="inet",ismap="1",keytype="ipv4_addr",set="match",table="table1"} 1
+# HELP nftables_set_size Number of elements in the set.
+# TYPE nftables_set_size gauge
+nftables_set_size{family="inet",set="match",table="table1"} 0
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_set_metadata", "nftables_set_size"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+	if got := testutil.ToFloat64(ineligible) - before; got != 1 {
+		t.Errorf("IneligibleSets name-filter: got %v, want 1", got)
+	}
+}
+
+func TestNFTCollectorFilters(t *testing.T) {
+	conn := fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "fail2ban", Family: nftables.TableFamilyINet},
+			{Name: "nat", Family: nftables.TableFamilyIPv4},
+			{Name: "nat", Family: nftables.TableFamilyINet},
+		},
+		chains: []*nftables.Chain{
+			{Name: "input", Table: &nftables.Table{Name: "fail2ban", Family: nftables.TableFamilyINet}},
+			{Name: "output", Table: &nftables.Table{Name: "fail2ban", Family: nftables.TableFamilyINet}},
+		},
+		rules: map[string][]*Rule{
+			"fail2ban/input": []*Rule{
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 4, Bytes: 2}},
+					UserData: makeRuleComment("ban")}},
+			},
+			"fail2ban/output": []*Rule{
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "output"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 4, Bytes: 2}},
+					UserData: makeRuleComment("ban")}},
+			},
+		},
+		sets: map[string][]*Set{
+			"fail2ban": {{Set: &nftables.Set{Name: "addr-set-sshd", KeyType: nftables.TypeIPAddr}}},
+			"nat":      {{Set: &nftables.Set{Name: "masq", KeyType: nftables.TypeIPAddr}}},
+		},
+	}
+	c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+	c.filters = []Filter{
+		{Kinds: map[ObjectKind]bool{KindSets: true}, Family: "ip", Table: regexp.MustCompile(`^nat$`)},
+		{Include: true, Kinds: map[ObjectKind]bool{KindSets: true}, Family: "inet", Table: regexp.MustCompile(`^fail2ban$`)},
+		{Kinds: map[ObjectKind]bool{KindSets: true}},
+		{Kinds: map[ObjectKind]bool{KindRules: true}, Chain: regexp.MustCompile(`^out.*$`)},
+	}
+
+	want := `
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter
+nftables_rule_packet_count{chain="input",comment="ban",family="inet",table="fail2ban"} 4
+# HELP nftables_set_size Number of elements in the set.
+# TYPE nftables_set_size gauge
+nftables_set_size{family="inet",set="addr-set-sshd",table="fail2ban"} 0
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_rule_packet_count", "nftables_set_size"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+}
+
+func TestNFTCollectorRuleLabels(t *testing.T) {
+	conn := fakeNFTConn{
+		chains: []*nftables.Chain{
+			{Name: "input", Table: &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}},
+		},
+		rules: map[string][]*Rule{
+			"filter/input": []*Rule{
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 1, Bytes: 10}},
+					UserData: makeRuleComment("svc=web dir=in")}},
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 2, Bytes: 20}},
+					UserData: makeRuleComment("svc=web dir=in")}},
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 4, Bytes: 40}},
+					UserData: makeRuleComment("svc=db")}},
+			},
+		},
+	}
+	re := regexp.MustCompile(`^(svc=(?P<svc>\w+)(?: dir=(?P<dir>\w+))?)$`)
+	c, err := New(&conn, Options{RuleComments: re.MatchString, RuleLabels: re})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	want := `
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter
+nftables_rule_packet_count{chain="input",comment="svc=db",dir="",family="inet",svc="db",table="filter"} 4
+nftables_rule_packet_count{chain="input",comment="svc=web dir=in",dir="in",family="inet",svc="web",table="filter"} 3
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_rule_packet_count"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+}
+
+func TestNFTCollectorJSONComments(t *testing.T) {
+	conn := fakeNFTConn{
+		chains: []*nftables.Chain{
+			{Name: "input", Table: &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}},
+		},
+		rules: map[string][]*Rule{
+			"filter/input": []*Rule{
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 1, Bytes: 10}},
+					UserData: makeRuleComment(`{"metric":"http_in","labels":{"svc":"web"}}`)}},
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"}, Handle: 2,
+					Exprs:    []expr.Any{&expr.Counter{Packets: 2, Bytes: 20}},
+					UserData: makeRuleComment(`{"labels":{"svc":"db"}}`)}},
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"},
+					Exprs:    []expr.Any{&expr.Counter{Packets: 4, Bytes: 40}},
+					UserData: makeRuleComment("plain")}},
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"}, Handle: 4,
+					Exprs:    []expr.Any{&expr.Counter{Packets: 8, Bytes: 80}},
+					UserData: makeRuleComment(`{"labels":`)}},
+			},
+		},
+	}
+	c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+	c.ruleCommentFormat = RuleCommentJSON
+
+	want := `
+# HELP nftables_rule_http_in_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_http_in_packet_count counter
+nftables_rule_http_in_packet_count{chain="input",family="inet",svc="web",table="filter"} 1
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter
+nftables_rule_packet_count{chain="input",comment="plain",family="inet",table="filter"} 4
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_rule_packet_count", "nftables_rule_http_in_packet_count"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+	if got := testutil.ToFloat64(c.metrics.IneligibleRules.WithLabelValues("inet", "filter", "comment-error")); got != 2 {
+		t.Errorf("IneligibleRules comment-error: got %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(c.metrics.CollectionFailures.WithLabelValues("")); got != 0 {
+		t.Errorf("CollectionFailures: got %v, want 0", got)
+	}
+
+	// Logged once per rule, not on every collection.
+	testutil.CollectAndCount(c)
+	if got := len(c.ruleErrors.seen); got != 2 {
+		t.Errorf("ruleErrors: got %d logged, want 2", got)
+	}
+}
+
+func TestNFTCollectorRuleDuplicates(t *testing.T) {
+	conn := fakeNFTConn{
+		chains: []*nftables.Chain{
+			{Name: "input", Table: &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}},
+		},
+		rules: map[string][]*Rule{
+			"filter/input": []*Rule{
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"}, Handle: 4,
+					Exprs:    []expr.Any{&expr.Counter{Packets: 1, Bytes: 10}},
+					UserData: makeRuleComment("web")}},
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"}, Handle: 7,
+					Exprs:    []expr.Any{&expr.Counter{Packets: 2, Bytes: 20}},
+					UserData: makeRuleComment("web")}},
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"}, Handle: 9,
+					Exprs:    []expr.Any{&expr.Counter{Packets: 4, Bytes: 40}},
+					UserData: makeRuleComment("db")}},
+			},
+		},
+	}
+
+	tsts := []struct {
+		mode RuleDuplicatesMode
+		want string
+	}{
+		{RuleDuplicatesSum, `
+nftables_rule_packet_count{chain="input",comment="db",family="inet",table="filter"} 4
+nftables_rule_packet_count{chain="input",comment="web",family="inet",table="filter"} 3
+`},
+		{RuleDuplicatesHandle, `
+nftables_rule_packet_count{chain="input",comment="db",family="inet",handle="9",table="filter"} 4
+nftables_rule_packet_count{chain="input",comment="web",family="inet",handle="4",table="filter"} 1
+nftables_rule_packet_count{chain="input",comment="web",family="inet",handle="7",table="filter"} 2
+`},
+		{RuleDuplicatesPosition, `
+nftables_rule_packet_count{chain="input",comment="db",family="inet",position="2",table="filter"} 4
+nftables_rule_packet_count{chain="input",comment="web",family="inet",position="0",table="filter"} 1
+nftables_rule_packet_count{chain="input",comment="web",family="inet",position="1",table="filter"} 2
+`},
+	}
+	for _, tst := range tsts {
+		t.Run(string(tst.mode), func(t *testing.T) {
+			c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+			c.setRuleDuplicates(tst.mode)
+
+			want := `
+# HELP nftables_rule_duplicate_comments Number of exported rules in chain with the same labels as an earlier rule.
+# TYPE nftables_rule_duplicate_comments gauge
+nftables_rule_duplicate_comments{chain="input",family="inet",table="filter"} 1
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter` + tst.want
+
+			if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_rule_duplicate_comments", "nftables_rule_packet_count"); err != nil {
+				t.Errorf("CollectAndCompare: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckLabelName(t *testing.T) {
+	tsts := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"svc", false},
+		{"_svc2", false},
+		{"2svc", true},
+		{"__svc", true},
+		{"comment", true},
+		{"netns", true},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			err := checkLabelName(tst.name)
+			if (err != nil) != tst.wantErr {
+				t.Errorf("checkLabelName: got %v, want error %v", err, tst.wantErr)
+			}
+		})
+	}
+}
+
+func TestNFTCollectorQuotaNameFilter(t *testing.T) {
+	conn := fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		},
+		quotas: map[string][]*Quota{
+			"table1": {
+				&Quota{Name: "nomatch", Bytes: 4711, Consumed: 42},
+				&Quota{Name: "match", Bytes: 4711, Consumed: 42},
+				&Quota{Name: "matchover", Bytes: 4711, Consumed: 4711, Flags: unix.NFT_QUOTA_F_INV},
+			},
+		},
+	}
+	c := newCollector(&conn, allFilter, allFilter, allFilter, func(s string) bool { return s != "nomatch" }, RuleTextNone, noneFilter, 0, false)
+	ineligible := c.metrics.IneligibleQuotas.WithLabelValues("inet", "table1", "name-filter")
+	before := testutil.ToFloat64(ineligible)
+	want := `
+# HELP nftables_quota_consumed_bytes Number of bytes consumed from the quota.
+# TYPE nftables_quota_consumed_bytes gauge
+nftables_quota_consumed_bytes{family="inet",quota="match",table="table1"} 42
+nftables_quota_consumed_bytes{family="inet",quota="matchover",table="table1"} 4711
+# HELP nftables_quota_exceeded Whether the quota has been used up. Value is 0 or 1.
+# TYPE nftables_quota_exceeded gauge
+nftables_quota_exceeded{family="inet",inverted="0",quota="match",table="table1"} 0
+nftables_quota_exceeded{family="inet",inverted="1",quota="matchover",table="table1"} 1
+# HELP nftables_quota_limit_bytes Number of bytes allowed by the quota.
+# TYPE nftables_quota_limit_bytes gauge
+nftables_quota_limit_bytes{family="inet",quota="match",table="table1"} 4711
+nftables_quota_limit_bytes{family="inet",quota="matchover",table="table1"} 4711
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_quota_limit_bytes", "nftables_quota_consumed_bytes", "nftables_quota_exceeded"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+	if got := testutil.ToFloat64(ineligible) - before; got != 1 {
+		t.Errorf("IneligibleQuotas name-filter: got %v, want 1", got)
+	}
+}
+
+func TestNFTCollectorTableFailures(t *testing.T) {
+	conn := failingTableConn{fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		},
+	}}
+	c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+
+	testutil.CollectAndCount(c)
+	if got := testutil.ToFloat64(c.metrics.CollectionFailures.WithLabelValues("")); got != 1 {
+		t.Errorf("CollectionFailures: got %v, want 1", got)
+	}
+}
+
+// failingTableConn fails listing quotas.
+type failingTableConn struct {
+	fakeNFTConn
+}
+
+func (c *failingTableConn) GetQuotas(t *nftables.Table) ([]*Quota, error) {
+	return nil, errors.New("mocked")
+}
+
+func TestNFTCollectorSetElementCounters(t *testing.T) {
+	conn := fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		},
+		sets: map[string][]*Set{
+			"table1": {
+				{Set: &nftables.Set{Name: "nomatch", KeyType: nftables.TypeIPAddr}},
+				{Set: &nftables.Set{Name: "match", KeyType: nftables.TypeIPAddr}},
+				{Set: &nftables.Set{Name: "large", KeyType: nftables.TypeIPAddr}},
+			},
+		},
+		setEls: map[string][]SetElement{
+			"nomatch": {
+				SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 1}}, Counter: &expr.Counter{Packets: 1, Bytes: 2}},
+			},
+			"match": {
+				SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 1}}, Counter: &expr.Counter{Packets: 1, Bytes: 2}},
+				SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 2}}},
+			},
+			"large": {
+				SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 1}}, Counter: &expr.Counter{Packets: 1, Bytes: 2}},
+				SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 2}}, Counter: &expr.Counter{Packets: 3, Bytes: 4}},
+				SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 3}}, Counter: &expr.Counter{Packets: 5, Bytes: 6}},
+			},
+		},
+	}
+
+	t.Run("limit", func(t *testing.T) {
+		c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, func(s string) bool { return s != "nomatch" }, 2, false)
+		ineligible := c.metrics.IneligibleSets.WithLabelValues("inet", "table1", "element-limit")
+		before := testutil.ToFloat64(ineligible)
+		want := `
+# HELP nftables_set_element_byte_count Number of bytes matching the set element.
+# TYPE nftables_set_element_byte_count counter
+nftables_set_element_byte_count{element="10.0.0.1",family="inet",set="match",table="table1"} 2
+# HELP nftables_set_element_packet_count Number of packets matching the set element.
+# TYPE nftables_set_element_packet_count counter
+nftables_set_element_packet_count{element="10.0.0.1",family="inet",set="match",table="table1"} 1
+`
+
+		if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_set_element_packet_count", "nftables_set_element_byte_count"); err != nil {
+			t.Errorf("CollectAndCompare: %v", err)
+		}
+		if got := testutil.ToFloat64(ineligible) - before; got != 1 {
+			t.Errorf("IneligibleSets element-limit: got %v, want 1", got)
+		}
+	})
+
+	t.Run("noLimit", func(t *testing.T) {
+		c, err := New(&conn, Options{SetElementCounters: func(s string) bool { return s == "large" }})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+
+		if got, want := testutil.CollectAndCount(c, "nftables_set_element_packet_count"), 3; got != want {
+			t.Errorf("CollectAndCount: got %d, want %d", got, want)
+		}
+	})
+
+	t.Run("top", func(t *testing.T) {
+		c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, func(s string) bool { return s == "large" }, 2, true)
+		want := `
+# HELP nftables_set_element_byte_count Number of bytes matching the set element.
+# TYPE nftables_set_element_byte_count counter
+nftables_set_element_byte_count{element="10.0.0.2",family="inet",set="large",table="table1"} 4
+nftables_set_element_byte_count{element="10.0.0.3",family="inet",set="large",table="table1"} 6
+# HELP nftables_set_element_packet_count Number of packets matching the set element.
+# TYPE nftables_set_element_packet_count counter
+nftables_set_element_packet_count{element="10.0.0.2",family="inet",set="large",table="table1"} 3
+nftables_set_element_packet_count{element="10.0.0.3",family="inet",set="large",table="table1"} 5
+`
+
+		if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_set_element_packet_count", "nftables_set_element_byte_count"); err != nil {
+			t.Errorf("CollectAndCompare: %v", err)
+		}
+	})
+}
+
+var _ Conn = &NLConn{}
+
+type fakeNFTConn struct {
+	tables []*nftables.Table
+	chains []*nftables.Chain
+	objs   map[string][]nftables.Obj // Key is "table".
+	quotas map[string][]*Quota       // Key is "table".
+	rules  map[string][]*Rule        // Key is "table/chain".
+	sets   map[string][]*Set         // Key is "table".
+	setEls map[string][]SetElement   // Key is "set".
+	gen    uint32
+
+	metaCalls int // Calls of ListTables, ListChains and GetSets.
+	ruleCalls int // Calls of GetTableRules.
+}
+
+func (c *fakeNFTConn) GetGen() (uint32, error) {
+	return c.gen, nil
+}
+
+func (c *fakeNFTConn) ListTables() ([]*nftables.Table, error) {
+	c.metaCalls++
+	return c.tables, nil
+}
+
+func (c *fakeNFTConn) ListChains() ([]*nftables.Chain, error) {
+	c.metaCalls++
+	return c.chains, nil
+}
+
+func (c *fakeNFTConn) GetObjects(t *nftables.Table) ([]nftables.Obj, error) {
+	return c.objs[t.Name], nil
+}
+
+func (c *fakeNFTConn) GetQuotas(t *nftables.Table) ([]*Quota, error) {
+	return c.quotas[t.Name], nil
+}
+
+func (c *fakeNFTConn) GetTableRules(t *nftables.Table) (map[string][]*Rule, error) {
+	c.ruleCalls++
+	rsm := map[string][]*Rule{}
+	for k, rs := range c.rules {
+		if strings.HasPrefix(k, t.Name+"/") {
+			rsm[strings.TrimPrefix(k, t.Name+"/")] = rs
+		}
+	}
+	return rsm, nil
+}
+
+func (c *fakeNFTConn) GetSets(t *nftables.Table) ([]*Set, error) {
+	c.metaCalls++
+	return c.sets[t.Name], nil
+}
+
+func (c *fakeNFTConn) GetSetElements(st *nftables.Set) ([]SetElement, error) {
+	return c.setEls[st.Name], nil
+}
@@ -0,0 +1,131 @@
+package nftcollector
+
+import (
+	"context"
+
+	"github.com/google/nftables"
+)
+
+// Sections of a collection that can be abandoned when the context is
+// done. They are the section label of TruncatedDesc.
+const (
+	SectionTables      = "tables"
+	SectionRules       = "rules"
+	SectionSetElements = "set_elements"
+)
+
+// truncatedSections records which sections were abandoned.
+type truncatedSections map[string]bool
+
+// value returns 1 if the section was abandoned, and 0 otherwise.
+func (ts truncatedSections) value(section string) float64 {
+	if ts[section] {
+		return 1
+	}
+	return 0
+}
+
+// callContext runs f, returning early with the context error if the
+// context is done first. Netlink calls can't be cancelled, so f keeps
+// running in the background, and its results must then be ignored.
+func callContext(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isContextError returns true if err is from a done context.
+func isContextError(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded
+}
+
+// A contextConn is a Conn whose calls return early when the
+// context is done. See callContext.
+type contextConn struct {
+	ctx  context.Context
+	conn Conn
+}
+
+// GetGen implements Conn.
+func (c contextConn) GetGen() (uint32, error) {
+	var gen uint32
+	if err := callContext(c.ctx, func() (err error) { gen, err = c.conn.GetGen(); return err }); err != nil {
+		return 0, err
+	}
+	return gen, nil
+}
+
+// ListTables implements Conn.
+func (c contextConn) ListTables() ([]*nftables.Table, error) {
+	var ts []*nftables.Table
+	if err := callContext(c.ctx, func() (err error) { ts, err = c.conn.ListTables(); return err }); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// ListChains implements Conn.
+func (c contextConn) ListChains() ([]*nftables.Chain, error) {
+	var cns []*nftables.Chain
+	if err := callContext(c.ctx, func() (err error) { cns, err = c.conn.ListChains(); return err }); err != nil {
+		return nil, err
+	}
+	return cns, nil
+}
+
+// GetObjects implements Conn.
+func (c contextConn) GetObjects(t *nftables.Table) ([]nftables.Obj, error) {
+	var os []nftables.Obj
+	if err := callContext(c.ctx, func() (err error) { os, err = c.conn.GetObjects(t); return err }); err != nil {
+		return nil, err
+	}
+	return os, nil
+}
+
+// GetQuotas implements Conn.
+func (c contextConn) GetQuotas(t *nftables.Table) ([]*Quota, error) {
+	var qs []*Quota
+	if err := callContext(c.ctx, func() (err error) { qs, err = c.conn.GetQuotas(t); return err }); err != nil {
+		return nil, err
+	}
+	return qs, nil
+}
+
+// GetTableRules implements Conn.
+func (c contextConn) GetTableRules(t *nftables.Table) (map[string][]*Rule, error) {
+	var rs map[string][]*Rule
+	if err := callContext(c.ctx, func() (err error) { rs, err = c.conn.GetTableRules(t); return err }); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// GetSets implements Conn.
+func (c contextConn) GetSets(t *nftables.Table) ([]*Set, error) {
+	var sts []*Set
+	if err := callContext(c.ctx, func() (err error) { sts, err = c.conn.GetSets(t); return err }); err != nil {
+		return nil, err
+	}
+	return sts, nil
+}
+
+// GetSetElements implements Conn.
+func (c contextConn) GetSetElements(s *nftables.Set) ([]SetElement, error) {
+	var els []SetElement
+	if err := callContext(c.ctx, func() (err error) { els, err = c.conn.GetSetElements(s); return err }); err != nil {
+		return nil, err
+	}
+	return els, nil
+}
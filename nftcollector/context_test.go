@@ -0,0 +1,112 @@
+package nftcollector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNFTCollectorTimeout(t *testing.T) {
+	conn := &slowSetConn{
+		fakeNFTConn: fakeNFTConn{
+			tables: []*nftables.Table{
+				{Name: "table1", Family: nftables.TableFamilyINet},
+			},
+			chains: []*nftables.Chain{
+				{Name: "chain1", Table: &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}},
+			},
+			sets: map[string][]*Set{
+				"table1": {
+					{Set: &nftables.Set{Name: "small", KeyType: nftables.TypeIPAddr}},
+					{Set: &nftables.Set{Name: "giant", KeyType: nftables.TypeIPAddr}},
+				},
+			},
+			setEls: map[string][]SetElement{
+				"small": {SetElement{}},
+			},
+		},
+		slow:    "giant",
+		release: make(chan struct{}),
+	}
+	defer close(conn.release)
+
+	c := newCollector(conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	want := `
+# HELP nftables_scrape_truncated Whether the collection of a section was abandoned because the scrape timed out. Value is 0 or 1.
+# TYPE nftables_scrape_truncated gauge
+nftables_scrape_truncated{section="rules"} 1
+nftables_scrape_truncated{section="set_elements"} 1
+nftables_scrape_truncated{section="tables"} 0
+# HELP nftables_set_size Number of elements in the set.
+# TYPE nftables_set_size gauge
+nftables_set_size{family="inet",set="small",table="table1"} 1
+`
+	if err := testutil.CollectAndCompare(boundCollector{ctx, c}, strings.NewReader(want), "nftables_scrape_truncated", "nftables_set_size"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+	if got := testutil.CollectAndCount(boundCollector{ctx, c}, "nftables_chain_metadata"); got != 0 {
+		t.Errorf("CollectAndCount(nftables_chain_metadata): got %v, want 0", got)
+	}
+
+	want = `
+# HELP nftables_scrape_truncated Whether the collection of a section was abandoned because the scrape timed out. Value is 0 or 1.
+# TYPE nftables_scrape_truncated gauge
+nftables_scrape_truncated{section="rules"} 0
+nftables_scrape_truncated{section="set_elements"} 0
+nftables_scrape_truncated{section="tables"} 0
+`
+	c = newCollector(&conn.fakeNFTConn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_scrape_truncated"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	if err := callContext(ctx, func() error { <-release; return nil }); err != context.Canceled {
+		t.Errorf("callContext: got %v, want %v", err, context.Canceled)
+	}
+}
+
+// A slowSetConn blocks listing the elements of the slow set until
+// release is closed.
+type slowSetConn struct {
+	fakeNFTConn
+	slow    string
+	release chan struct{}
+}
+
+func (c *slowSetConn) GetSetElements(st *nftables.Set) ([]SetElement, error) {
+	if st.Name == c.slow {
+		<-c.release
+	}
+	return c.fakeNFTConn.GetSetElements(st)
+}
+
+// A boundCollector collects from a Collector with a fixed context.
+type boundCollector struct {
+	ctx  context.Context
+	coll *Collector
+}
+
+func (c boundCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.coll.Describe(ch)
+}
+
+func (c boundCollector) Collect(ch chan<- prometheus.Metric) {
+	c.coll.CollectContext(c.ctx, ch)
+}
@@ -0,0 +1,181 @@
+package nftcollector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// counterStateTTL is how long a rule counter is remembered
+	// after it was last seen.
+	counterStateTTL = 24 * time.Hour
+)
+
+// A CounterTracker makes rule counters monotonic. When a rule is
+// replaced, e.g. by "nft -f", the new rule starts counting from zero.
+// The tracker remembers the last values of the old rules, by handle,
+// and adds them to the counters of the new ones.
+type CounterTracker struct {
+	// path is where the state is saved, if not empty.
+	path string
+
+	// now returns the current time.
+	now func() time.Time
+
+	mu    sync.Mutex
+	state map[string]*trackedCounter
+	dirty bool
+}
+
+// A trackedCounter is the state of a single rule metric.
+type trackedCounter struct {
+	// Carried are the totals of rules that have been replaced.
+	Carried counterSample `json:"carried"`
+
+	// Rules are the last values of the current rules, by handle.
+	Rules map[uint64]counterSample `json:"rules"`
+
+	// Seen is when the counter was last updated.
+	Seen time.Time `json:"seen"`
+}
+
+// A counterSample is the value of a counter.
+type counterSample struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// NewCounterTracker creates a tracker, loading the state from path
+// if it exists. An empty path keeps the state in memory only.
+func NewCounterTracker(path string) (*CounterTracker, error) {
+	t := &CounterTracker{
+		path:  path,
+		now:   time.Now,
+		state: map[string]*trackedCounter{},
+	}
+	if path == "" {
+		return t, nil
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &t.state); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// counterKey returns the key of a metric, from its identifying
+// parts.
+func counterKey(parts ...string) string {
+	return strings.Join(parts, "\x00")
+}
+
+// update records the current values of the rules, by handle, making
+// up the metric with the given key. It returns the monotonic total.
+// A rule that disappeared, or whose counter decreased, is considered
+// replaced.
+func (t *CounterTracker) update(key string, rules map[uint64]counterSample) counterSample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tc := t.state[key]
+	if tc == nil {
+		tc = &trackedCounter{}
+		t.state[key] = tc
+	}
+
+	carried := tc.Carried
+	for h, last := range tc.Rules {
+		if cur, ok := rules[h]; !ok || cur.Packets < last.Packets || cur.Bytes < last.Bytes {
+			tc.Carried.Packets += last.Packets
+			tc.Carried.Bytes += last.Bytes
+		}
+	}
+
+	// Growing counters of the same rules don't change the offsets,
+	// so the file isn't rewritten on every scrape for them.
+	if tc.Carried != carried || !sameHandles(tc.Rules, rules) {
+		t.dirty = true
+	}
+
+	tc.Rules = rules
+	tc.Seen = t.now()
+
+	total := tc.Carried
+	for _, cur := range rules {
+		total.Packets += cur.Packets
+		total.Bytes += cur.Bytes
+	}
+	return total
+}
+
+// sameHandles returns true if a and b have the same keys.
+func sameHandles(a, b map[uint64]counterSample) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for h := range a {
+		if _, ok := b[h]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// save writes the state to the file, if there is one and the offsets
+// or rule handles have changed. The saved rule values may then be
+// older than the current ones, which at most loses what was counted
+// since by a rule replaced while the exporter wasn't running.
+// Counters not seen for counterStateTTL are forgotten. The file is
+// replaced atomically.
+func (t *CounterTracker) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for key, tc := range t.state {
+		if now.Sub(tc.Seen) > counterStateTTL {
+			delete(t.state, key)
+			t.dirty = true
+		}
+	}
+
+	if t.path == "" || !t.dirty {
+		return nil
+	}
+
+	bs, err := json.Marshal(t.state)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(t.path), "."+filepath.Base(t.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(bs); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), t.path); err != nil {
+		return err
+	}
+
+	t.dirty = false
+	return nil
+}
@@ -0,0 +1,142 @@
+package nftcollector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCounterTrackerUpdate(t *testing.T) {
+	tr, err := NewCounterTracker("")
+	if err != nil {
+		t.Fatalf("NewCounterTracker failed: %v", err)
+	}
+
+	tsts := []struct {
+		name  string
+		rules map[uint64]counterSample
+		want  counterSample
+	}{
+		{"initial", map[uint64]counterSample{1: {10, 100}, 2: {1, 10}}, counterSample{11, 110}},
+		{"increase", map[uint64]counterSample{1: {20, 200}, 2: {1, 10}}, counterSample{21, 210}},
+		{"replaced", map[uint64]counterSample{3: {2, 20}, 2: {1, 10}}, counterSample{23, 230}},
+		{"reset", map[uint64]counterSample{3: {0, 0}, 2: {1, 10}}, counterSample{23, 230}},
+		{"removed", map[uint64]counterSample{}, counterSample{23, 230}},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			if got := tr.update("key", tst.rules); got != tst.want {
+				t.Errorf("update: got %+v, want %+v", got, tst.want)
+			}
+		})
+	}
+}
+
+func TestCounterTrackerSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promnftd-")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	tr, err := NewCounterTracker(path)
+	if err != nil {
+		t.Fatalf("NewCounterTracker failed: %v", err)
+	}
+	now := time.Unix(1600000000, 0)
+	tr.now = func() time.Time { return now }
+	tr.update("old", map[uint64]counterSample{1: {1, 10}})
+	now = now.Add(counterStateTTL + time.Second)
+	tr.update("key", map[uint64]counterSample{1: {10, 100}})
+
+	if err := tr.save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	tr, err = NewCounterTracker(path)
+	if err != nil {
+		t.Fatalf("NewCounterTracker failed: %v", err)
+	}
+	if _, ok := tr.state["old"]; ok {
+		t.Errorf("state: got expired counter %q", "old")
+	}
+	if got, want := tr.update("key", map[uint64]counterSample{2: {1, 10}}), (counterSample{11, 110}); got != want {
+		t.Errorf("update: got %+v, want %+v", got, want)
+	}
+}
+
+func TestCounterTrackerDirty(t *testing.T) {
+	tr, err := NewCounterTracker("")
+	if err != nil {
+		t.Fatalf("NewCounterTracker failed: %v", err)
+	}
+
+	tsts := []struct {
+		name  string
+		rules map[uint64]counterSample
+		want  bool
+	}{
+		{"initial", map[uint64]counterSample{1: {10, 100}}, true},
+		{"increase", map[uint64]counterSample{1: {20, 200}}, false},
+		{"added", map[uint64]counterSample{1: {20, 200}, 2: {1, 10}}, true},
+		{"replaced", map[uint64]counterSample{1: {20, 200}, 3: {1, 10}}, true},
+		{"reset", map[uint64]counterSample{1: {0, 0}, 3: {1, 10}}, true},
+		{"unchanged", map[uint64]counterSample{1: {0, 0}, 3: {1, 10}}, false},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			tr.dirty = false
+			tr.update("key", tst.rules)
+			if tr.dirty != tst.want {
+				t.Errorf("update dirty: got %v, want %v", tr.dirty, tst.want)
+			}
+		})
+	}
+}
+
+func TestNFTCollectorMonotonicRuleCounters(t *testing.T) {
+	tr, err := NewCounterTracker("")
+	if err != nil {
+		t.Fatalf("NewCounterTracker failed: %v", err)
+	}
+	conn := fakeNFTConn{
+		chains: []*nftables.Chain{
+			{Name: "input", Table: &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}},
+		},
+		rules: map[string][]*Rule{
+			"filter/input": []*Rule{
+				{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"}, Handle: 4,
+					Exprs:    []expr.Any{&expr.Counter{Packets: 5, Bytes: 50}},
+					UserData: makeRuleComment("web")}},
+			},
+		},
+	}
+	c := newCollector(&conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+	c.counters = tr
+
+	if got := testutil.CollectAndCount(c, "nftables_rule_packet_count"); got != 1 {
+		t.Fatalf("CollectAndCount: got %v, want 1", got)
+	}
+
+	// Simulates "nft -f", replacing the rule.
+	conn.rules["filter/input"][0] = &Rule{Rule: &nftables.Rule{Chain: &nftables.Chain{Name: "input"}, Handle: 8,
+		Exprs:    []expr.Any{&expr.Counter{Packets: 1, Bytes: 10}},
+		UserData: makeRuleComment("web")}}
+
+	want := `
+# HELP nftables_rule_packet_count Number of packets matching the rule.
+# TYPE nftables_rule_packet_count counter
+nftables_rule_packet_count{chain="input",comment="web",family="inet",table="filter"} 6
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nftables_rule_packet_count"); err != nil {
+		t.Errorf("CollectAndCompare: %v", err)
+	}
+}
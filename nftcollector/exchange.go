@@ -0,0 +1,64 @@
+package nftcollector
+
+import (
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+)
+
+// An exchangeFunc answers netlink requests instead of the kernel. It
+// has the signature of nftables.Conn.TestDial, and is how recordings
+// are made and replayed.
+type exchangeFunc func(reqs []netlink.Message) ([]netlink.Message, error)
+
+// dialExchange returns a netlink connection whose requests are
+// answered by fn.
+func dialExchange(fn exchangeFunc) *netlink.Conn {
+	return netlink.NewConn(&exchangeSocket{fn: fn}, 1)
+}
+
+// An exchangeSocket is a netlink.Socket passing requests to an
+// exchangeFunc. A multi-part response is received at once, ending
+// with its Done message.
+type exchangeSocket struct {
+	fn exchangeFunc
+
+	msgs []netlink.Message
+	err  error
+}
+
+var _ netlink.Socket = &exchangeSocket{}
+
+func (s *exchangeSocket) Close() error { return nil }
+
+func (s *exchangeSocket) Send(m netlink.Message) error {
+	return s.SendMessages([]netlink.Message{m})
+}
+
+func (s *exchangeSocket) SendMessages(ms []netlink.Message) error {
+	s.msgs, s.err = s.fn(ms)
+	return nil
+}
+
+func (s *exchangeSocket) Receive() ([]netlink.Message, error) {
+	msgs, err := s.msgs, s.err
+	s.msgs, s.err = nil, nil
+	return msgs, err
+}
+
+// errorReply returns a netlink error reply to the request, with the
+// error number.
+func errorReply(errno int, req netlink.Message) []netlink.Message {
+	req.Header.Type = netlink.Error
+	req.Data = append(nlenc.Int32Bytes(-int32(errno)), req.Data...)
+	return []netlink.Message{req}
+}
+
+// multipartReply marks the messages as a multi-part reply, the last
+// one being the Done message. An empty dump is only the Done message.
+func multipartReply(msgs []netlink.Message) []netlink.Message {
+	for i := range msgs {
+		msgs[i].Header.Flags |= netlink.Multi
+	}
+	msgs[len(msgs)-1].Header.Type = netlink.Done
+	return msgs
+}
@@ -0,0 +1,41 @@
+package nftcollector
+
+import "regexp"
+
+// An ObjectKind is a kind of object a Filter can select.
+type ObjectKind string
+
+const (
+	KindRules    ObjectKind = "rules"
+	KindCounters ObjectKind = "counters"
+	KindSets     ObjectKind = "sets"
+	KindQuotas   ObjectKind = "quotas"
+)
+
+// A Filter includes or excludes the objects it matches. Filters are
+// applied in order before the name filters of Options, and the first
+// matching one decides.
+type Filter struct {
+	Include bool
+	Kinds   map[ObjectKind]bool // Nil means all.
+
+	// Family is a table family name, e.g. "inet". Empty means all.
+	Family string
+
+	// Table, Chain and Name match the names of objects. Nil means
+	// all. Chain only applies to rules, and Name is the comment of
+	// rules.
+	Table *regexp.Regexp
+	Chain *regexp.Regexp
+	Name  *regexp.Regexp
+}
+
+// Match returns true if the filter applies to the object. The chain
+// is empty, except for rules.
+func (f *Filter) Match(kind ObjectKind, family, table, chain, name string) bool {
+	return (f.Kinds == nil || f.Kinds[kind]) &&
+		(f.Family == "" || f.Family == family) &&
+		(f.Table == nil || f.Table.MatchString(table)) &&
+		(f.Chain == nil || f.Chain.MatchString(chain)) &&
+		(f.Name == nil || f.Name.MatchString(name))
+}
@@ -0,0 +1,278 @@
+package nftcollector
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+// Metrics are metrics about collections, rather than about the rule
+// set. They can be shared by collectors, and are not registered by
+// this package.
+type Metrics struct {
+	CollectionFailures *prometheus.CounterVec // Labels: netns.
+
+	// Labels: family, table, reason.
+	IneligibleRules    *prometheus.CounterVec
+	IneligibleCounters *prometheus.CounterVec
+	IneligibleSets     *prometheus.CounterVec
+	IneligibleQuotas   *prometheus.CounterVec
+
+	CollectionDuration *prometheus.HistogramVec // Labels: phase.
+	NetlinkCalls       *prometheus.CounterVec   // Labels: operation.
+	NetlinkErrors      *prometheus.CounterVec   // Labels: operation, errno.
+}
+
+// NewMetrics creates metrics with no samples.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		CollectionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nftables",
+			Name:      "collection_failures",
+			Help:      "Collection failures while reading from nftables.",
+		}, []string{"netns"}),
+
+		IneligibleRules: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nftables",
+			Name:      "ineligible_rules",
+			Help:      "Number of rules that were not exported for some reason.",
+		}, []string{"family", "table", "reason"}),
+
+		IneligibleCounters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nftables",
+			Name:      "ineligible_counters",
+			Help:      "Number of counters that were not exported for some reason.",
+		}, []string{"family", "table", "reason"}),
+
+		IneligibleSets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nftables",
+			Name:      "ineligible_sets",
+			Help:      "Number of sets that were not exported for some reason.",
+		}, []string{"family", "table", "reason"}),
+
+		IneligibleQuotas: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nftables",
+			Name:      "ineligible_quotas",
+			Help:      "Number of quotas that were not exported for some reason.",
+		}, []string{"family", "table", "reason"}),
+
+		CollectionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nftables",
+			Name:      "collection_duration_seconds",
+			Help:      "Time spent in each phase of a collection.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"phase"}),
+
+		NetlinkCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nftables",
+			Name:      "netlink_calls",
+			Help:      "Number of NFTables netlink requests made.",
+		}, []string{"operation"}),
+
+		NetlinkErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nftables",
+			Name:      "netlink_errors",
+			Help:      "Number of NFTables netlink requests that failed.",
+		}, []string{"operation", "errno"}),
+	}
+}
+
+// collectors returns all the metrics.
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.CollectionFailures,
+		m.IneligibleRules,
+		m.IneligibleCounters,
+		m.IneligibleSets,
+		m.IneligibleQuotas,
+		m.CollectionDuration,
+		m.NetlinkCalls,
+		m.NetlinkErrors,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// Phases of a collection, the phase label of
+// nftables_collection_duration_seconds.
+const (
+	phaseTables   = "tables"
+	phaseChains   = "chains"
+	phaseRules    = "rules"
+	phaseObjects  = "objects"
+	phaseSets     = "sets"
+	phaseElements = "elements"
+)
+
+// A statsConn is a Conn counting calls and errors by the
+// NFT_MSG_GET* message sent.
+type statsConn struct {
+	conn    Conn
+	metrics *Metrics
+}
+
+// count records the outcome of an operation, and returns err.
+func (c statsConn) count(op string, err error) error {
+	c.metrics.NetlinkCalls.WithLabelValues(op).Inc()
+	if err != nil {
+		c.metrics.NetlinkErrors.WithLabelValues(op, errnoString(err)).Inc()
+	}
+	return err
+}
+
+// GetGen implements Conn.
+func (c statsConn) GetGen() (uint32, error) {
+	gen, err := c.conn.GetGen()
+	return gen, c.count("getgen", err)
+}
+
+// ListTables implements Conn.
+func (c statsConn) ListTables() ([]*nftables.Table, error) {
+	ts, err := c.conn.ListTables()
+	return ts, c.count("gettable", err)
+}
+
+// ListChains implements Conn.
+func (c statsConn) ListChains() ([]*nftables.Chain, error) {
+	cns, err := c.conn.ListChains()
+	return cns, c.count("getchain", err)
+}
+
+// GetObjects implements Conn.
+func (c statsConn) GetObjects(t *nftables.Table) ([]nftables.Obj, error) {
+	os, err := c.conn.GetObjects(t)
+	return os, c.count("getobj", err)
+}
+
+// GetQuotas implements Conn.
+func (c statsConn) GetQuotas(t *nftables.Table) ([]*Quota, error) {
+	qs, err := c.conn.GetQuotas(t)
+	return qs, c.count("getobj", err)
+}
+
+// GetTableRules implements Conn.
+func (c statsConn) GetTableRules(t *nftables.Table) (map[string][]*Rule, error) {
+	rs, err := c.conn.GetTableRules(t)
+	return rs, c.count("getrule", err)
+}
+
+// GetSets implements Conn.
+func (c statsConn) GetSets(t *nftables.Table) ([]*Set, error) {
+	sts, err := c.conn.GetSets(t)
+	return sts, c.count("getset", err)
+}
+
+// GetSetElements implements Conn.
+func (c statsConn) GetSetElements(s *nftables.Set) ([]SetElement, error) {
+	els, err := c.conn.GetSetElements(s)
+	return els, c.count("getsetelem", err)
+}
+
+// errnoString returns the name of the errno in err, e.g. "ENOENT", or
+// "other" if there is none.
+func errnoString(err error) string {
+	var errno unix.Errno
+	if !errors.As(err, &errno) {
+		return "other"
+	}
+	if name := unix.ErrnoName(errno); name != "" {
+		return name
+	}
+	return strconv.Itoa(int(errno))
+}
+
+// A phaseTimer is a Conn summing the time spent in calls, by
+// collection phase. It is not safe for concurrent use.
+type phaseTimer struct {
+	conn      Conn
+	metrics   *Metrics
+	now       func() time.Time
+	durations map[string]time.Duration
+}
+
+// newPhaseTimer creates a timer of calls to conn, observed in
+// metrics.
+func newPhaseTimer(conn Conn, metrics *Metrics) *phaseTimer {
+	return &phaseTimer{
+		conn:      conn,
+		metrics:   metrics,
+		now:       time.Now,
+		durations: map[string]time.Duration{},
+	}
+}
+
+// add adds the time since start to the phase.
+func (t *phaseTimer) add(phase string, start time.Time) {
+	t.durations[phase] += t.now().Sub(start)
+}
+
+// observe records the summed durations of the phases that were
+// reached.
+func (t *phaseTimer) observe() {
+	for phase, d := range t.durations {
+		t.metrics.CollectionDuration.WithLabelValues(phase).Observe(d.Seconds())
+	}
+}
+
+// GetGen implements Conn. It is not part of any phase.
+func (t *phaseTimer) GetGen() (uint32, error) {
+	return t.conn.GetGen()
+}
+
+// ListTables implements Conn.
+func (t *phaseTimer) ListTables() ([]*nftables.Table, error) {
+	defer t.add(phaseTables, t.now())
+	return t.conn.ListTables()
+}
+
+// ListChains implements Conn.
+func (t *phaseTimer) ListChains() ([]*nftables.Chain, error) {
+	defer t.add(phaseChains, t.now())
+	return t.conn.ListChains()
+}
+
+// GetObjects implements Conn.
+func (t *phaseTimer) GetObjects(tbl *nftables.Table) ([]nftables.Obj, error) {
+	defer t.add(phaseObjects, t.now())
+	return t.conn.GetObjects(tbl)
+}
+
+// GetQuotas implements Conn.
+func (t *phaseTimer) GetQuotas(tbl *nftables.Table) ([]*Quota, error) {
+	defer t.add(phaseObjects, t.now())
+	return t.conn.GetQuotas(tbl)
+}
+
+// GetTableRules implements Conn.
+func (t *phaseTimer) GetTableRules(tbl *nftables.Table) (map[string][]*Rule, error) {
+	defer t.add(phaseRules, t.now())
+	return t.conn.GetTableRules(tbl)
+}
+
+// GetSets implements Conn.
+func (t *phaseTimer) GetSets(tbl *nftables.Table) ([]*Set, error) {
+	defer t.add(phaseSets, t.now())
+	return t.conn.GetSets(tbl)
+}
+
+// GetSetElements implements Conn.
+func (t *phaseTimer) GetSetElements(s *nftables.Set) ([]SetElement, error) {
+	defer t.add(phaseElements, t.now())
+	return t.conn.GetSetElements(s)
+}
@@ -0,0 +1,150 @@
+package nftcollector
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/sys/unix"
+)
+
+func TestNewMetrics(t *testing.T) {
+	m := NewMetrics()
+	c, err := New(&fakeNFTConn{}, Options{Metrics: m})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	testutil.CollectAndCount(c)
+
+	if got := testutil.ToFloat64(m.NetlinkCalls.WithLabelValues("gettable")); got != 1 {
+		t.Errorf("NetlinkCalls gettable: got %v, want 1", got)
+	}
+
+	// Nothing is in the default registry.
+	for _, coll := range []prometheus.Collector{c, m} {
+		if err := prometheus.Register(coll); err != nil {
+			t.Errorf("Register(%T) failed: %v", coll, err)
+		} else {
+			prometheus.Unregister(coll)
+		}
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(m)
+	if _, err := reg.Gather(); err != nil {
+		t.Errorf("Gather failed: %v", err)
+	}
+}
+
+func TestNFTCollectorNetlinkStats(t *testing.T) {
+	conn := &failingSetConn{
+		fakeNFTConn: fakeNFTConn{
+			tables: []*nftables.Table{
+				{Name: "table1", Family: nftables.TableFamilyINet},
+			},
+		},
+		err: fmt.Errorf("listing sets: %w", &netlink.OpError{Op: "receive", Err: unix.EPERM}),
+	}
+	c := newCollector(conn, allFilter, allFilter, allFilter, allFilter, RuleTextNone, noneFilter, 0, false)
+
+	tableCalls := c.metrics.NetlinkCalls.WithLabelValues("gettable")
+	setCalls := c.metrics.NetlinkCalls.WithLabelValues("getset")
+	setErrors := c.metrics.NetlinkErrors.WithLabelValues("getset", "EPERM")
+	beforeTables, beforeSets, beforeErrors := testutil.ToFloat64(tableCalls), testutil.ToFloat64(setCalls), testutil.ToFloat64(setErrors)
+
+	testutil.CollectAndCount(c)
+
+	if got := testutil.ToFloat64(tableCalls) - beforeTables; got != 1 {
+		t.Errorf("NetlinkCalls gettable: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(setCalls) - beforeSets; got != 1 {
+		t.Errorf("NetlinkCalls getset: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(setErrors) - beforeErrors; got != 1 {
+		t.Errorf("NetlinkErrors getset EPERM: got %v, want 1", got)
+	}
+}
+
+func TestErrnoString(t *testing.T) {
+	tsts := []struct {
+		Name string
+		Err  error
+		Want string
+	}{
+		{"errno", unix.ENOENT, "ENOENT"},
+		{"wrapped", fmt.Errorf("listing rules: %w", &netlink.OpError{Op: "receive", Err: unix.EBUSY}), "EBUSY"},
+		{"unknown", unix.Errno(4095), "4095"},
+		{"other", errors.New("short NFTables message"), "other"},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.Name, func(t *testing.T) {
+			if got := errnoString(tst.Err); got != tst.Want {
+				t.Errorf("errnoString: got %q, want %q", got, tst.Want)
+			}
+		})
+	}
+}
+
+func TestPhaseTimer(t *testing.T) {
+	conn := fakeNFTConn{
+		tables: []*nftables.Table{
+			{Name: "table1", Family: nftables.TableFamilyINet},
+		},
+	}
+	m := NewMetrics()
+	timer := newPhaseTimer(&conn, m)
+	now := time.Unix(0, 0)
+	timer.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	ts, _ := timer.ListTables()
+	timer.GetObjects(ts[0])
+	timer.GetQuotas(ts[0])
+
+	want := map[string]time.Duration{
+		phaseTables:  time.Second,
+		phaseObjects: 2 * time.Second,
+	}
+	if len(timer.durations) != len(want) {
+		t.Errorf("durations: got %v, want %v", timer.durations, want)
+	}
+	for phase, d := range want {
+		if got := timer.durations[phase]; got != d {
+			t.Errorf("durations[%q]: got %v, want %v", phase, got, d)
+		}
+	}
+
+	timer.observe()
+	if got := histogramSampleCount(t, m.CollectionDuration.WithLabelValues(phaseObjects)); got != 1 {
+		t.Errorf("CollectionDuration objects: got %v samples, want 1", got)
+	}
+}
+
+// histogramSampleCount returns the number of observations of h.
+func histogramSampleCount(t *testing.T, h prometheus.Observer) uint64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := h.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// A failingSetConn fails listing sets with err.
+type failingSetConn struct {
+	fakeNFTConn
+	err error
+}
+
+func (c *failingSetConn) GetSets(t *nftables.Table) ([]*Set, error) {
+	return nil, c.err
+}
@@ -0,0 +1,80 @@
+package nftcollector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A RuleCommentFormat selects how rule comments are parsed.
+type RuleCommentFormat string
+
+const (
+	// RuleCommentPlain uses comments as the comment label.
+	RuleCommentPlain RuleCommentFormat = ""
+
+	// RuleCommentJSON parses comments that are JSON objects as
+	// jsonComment. Other comments are plain.
+	RuleCommentJSON RuleCommentFormat = "json"
+)
+
+// A jsonComment is a rule comment selecting the metric name and
+// labels of the rule, e.g.
+//
+//	{"metric":"http_in","labels":{"svc":"web"}}
+type jsonComment struct {
+	// Metric is a suffix of the metric names, as in
+	// nftables_rule_<metric>_packet_count. It is required, so the
+	// labels never clash with those of the normal names.
+	Metric string `json:"metric"`
+
+	// Labels replace the comment label.
+	Labels map[string]string `json:"labels"`
+}
+
+var metricSuffixRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// isJSONComment returns true if the comment looks like a JSON object.
+func isJSONComment(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), "{")
+}
+
+// parseJSONComment parses and validates a JSON comment.
+func parseJSONComment(s string) (jc jsonComment, err error) {
+	dec := json.NewDecoder(bytes.NewBufferString(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&jc); err != nil {
+		return jsonComment{}, fmt.Errorf("parsing JSON comment %q: %v", s, err)
+	}
+	if dec.More() {
+		return jsonComment{}, fmt.Errorf("parsing JSON comment %q: trailing data", s)
+	}
+
+	if jc.Metric == "" {
+		return jsonComment{}, fmt.Errorf("missing metric in JSON comment %q", s)
+	}
+	if !metricSuffixRE.MatchString(jc.Metric) {
+		return jsonComment{}, fmt.Errorf("invalid metric in JSON comment %q", s)
+	}
+
+	for name := range jc.Labels {
+		if err := checkLabelName(name); err != nil {
+			return jsonComment{}, fmt.Errorf("in JSON comment %q: %v", s, err)
+		}
+	}
+
+	return jc, nil
+}
+
+// labelNames returns the sorted names of the labels.
+func (jc *jsonComment) labelNames() []string {
+	names := make([]string, 0, len(jc.Labels))
+	for name := range jc.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
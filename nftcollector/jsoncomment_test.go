@@ -0,0 +1,37 @@
+package nftcollector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONComment(t *testing.T) {
+	tsts := []struct {
+		name    string
+		s       string
+		want    jsonComment
+		wantErr bool
+	}{
+		{"full", `{"metric":"http_in","labels":{"svc":"web"}}`, jsonComment{Metric: "http_in", Labels: map[string]string{"svc": "web"}}, false},
+		{"metricOnly", `{"metric":"http_in"}`, jsonComment{Metric: "http_in"}, false},
+		{"labelsOnly", `{"labels":{"svc":"web","dir":"in"}}`, jsonComment{}, true},
+		{"truncated", `{"metric":"http_in"`, jsonComment{}, true},
+		{"unknownField", `{"name":"http_in"}`, jsonComment{}, true},
+		{"numberLabel", `{"labels":{"port":80}}`, jsonComment{}, true},
+		{"trailing", `{} {}`, jsonComment{}, true},
+		{"invalidMetric", `{"metric":"http-in"}`, jsonComment{}, true},
+		{"invalidLabel", `{"metric":"http_in","labels":{"1svc":"web"}}`, jsonComment{}, true},
+		{"reservedLabel", `{"metric":"http_in","labels":{"table":"web"}}`, jsonComment{}, true},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			got, err := parseJSONComment(tst.s)
+			if (err != nil) != tst.wantErr {
+				t.Fatalf("parseJSONComment err: got %v, want error %v", err, tst.wantErr)
+			}
+			if !reflect.DeepEqual(got, tst.want) {
+				t.Errorf("parseJSONComment: got %+v, want %+v", got, tst.want)
+			}
+		})
+	}
+}
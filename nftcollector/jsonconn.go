@@ -0,0 +1,1135 @@
+package nftcollector
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/tommie/prometheus-nftables-exporter/udata"
+	"golang.org/x/sys/unix"
+)
+
+// A JSONConn is a Conn reading the rule set from the output of
+// "nft -j list ruleset", i.e. the libnftables JSON schema, instead of
+// using netlink. It needs no privileges.
+//
+// The output has no rule set generation. Instead, GetGen reads the
+// rule set, and the generation is bumped whenever the output changes,
+// other than counter values and the like. The other methods use what
+// GetGen read last, though a collection keeps the rule set it started
+// with.
+//
+// Rule expressions are not decoded, except counters and verdicts.
+// The others are kept as otherExprs, by name.
+type JSONConn struct {
+	read func() ([]byte, error)
+
+	mu  sync.Mutex
+	gen uint32
+	sum [sha256.Size]byte // Of the output, without counter values.
+	raw []byte
+	rs  *jsonRuleset
+}
+
+// NewJSONConn creates a connection reading the JSON rule set with
+// read.
+func NewJSONConn(read func() ([]byte, error)) *JSONConn {
+	return &JSONConn{read: read}
+}
+
+// NewJSONFileConn creates a connection reading the JSON rule set from
+// a file, on every collection. As a special case, "-" reads standard
+// input until EOF, once.
+func NewJSONFileConn(path string) (*JSONConn, error) {
+	if path != "-" {
+		return NewJSONConn(func() ([]byte, error) { return ioutil.ReadFile(path) }), nil
+	}
+
+	bs, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONConn(func() ([]byte, error) { return bs, nil }), nil
+}
+
+// NewJSONCommandConn creates a connection running a command, like
+// "nft -j list ruleset", on every collection. The command is split
+// into arguments by whitespace, and is not run by a shell.
+func NewJSONCommandConn(command string) (*JSONConn, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	return NewJSONConn(func() ([]byte, error) {
+		var stderr bytes.Buffer
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = &stderr
+		bs, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("running %q: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+		}
+		return bs, nil
+	}), nil
+}
+
+// GetGen implements Conn. It reads the rule set.
+func (c *JSONConn) GetGen() (uint32, error) {
+	gen, _, err := c.reload()
+	return gen, err
+}
+
+// reload reads the rule set, and returns it with its generation.
+func (c *JSONConn) reload() (uint32, *jsonRuleset, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.reloadLocked(); err != nil {
+		return 0, nil, err
+	}
+	return c.gen, c.rs, nil
+}
+
+// reloadLocked reads the rule set, and parses it if it has changed.
+// The generation is only bumped if more than counter values changed,
+// so metadata stays cached.
+func (c *JSONConn) reloadLocked() error {
+	bs, err := c.read()
+	if err != nil {
+		return err
+	}
+	if c.rs != nil && bytes.Equal(bs, c.raw) {
+		return nil
+	}
+
+	rs, err := parseJSONRuleset(bs)
+	if err != nil {
+		return err
+	}
+	sum, err := jsonMetadataSum(bs)
+	if err != nil {
+		return err
+	}
+	if c.rs == nil || sum != c.sum {
+		c.gen++
+		c.sum = sum
+	}
+	c.raw = bs
+	c.rs = rs
+	return nil
+}
+
+// ruleset returns the rule set GetGen read last. If it was never
+// called, the rule set is read now.
+func (c *JSONConn) ruleset() (*jsonRuleset, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rs == nil {
+		if err := c.reloadLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return c.rs, nil
+}
+
+// snapshot implements snapshotter.
+func (c *JSONConn) snapshot() Conn {
+	return &jsonSnapshot{c: c}
+}
+
+// ListTables implements Conn.
+func (c *JSONConn) ListTables() ([]*nftables.Table, error) {
+	return c.snapshot().ListTables()
+}
+
+// ListChains implements Conn.
+func (c *JSONConn) ListChains() ([]*nftables.Chain, error) {
+	return c.snapshot().ListChains()
+}
+
+// GetObjects implements Conn.
+func (c *JSONConn) GetObjects(t *nftables.Table) ([]nftables.Obj, error) {
+	return c.snapshot().GetObjects(t)
+}
+
+// GetQuotas implements Conn.
+func (c *JSONConn) GetQuotas(t *nftables.Table) ([]*Quota, error) {
+	return c.snapshot().GetQuotas(t)
+}
+
+// GetTableRules implements Conn.
+func (c *JSONConn) GetTableRules(t *nftables.Table) (map[string][]*Rule, error) {
+	return c.snapshot().GetTableRules(t)
+}
+
+// GetSets implements Conn.
+func (c *JSONConn) GetSets(t *nftables.Table) ([]*Set, error) {
+	return c.snapshot().GetSets(t)
+}
+
+// GetSetElements implements Conn.
+func (c *JSONConn) GetSetElements(s *nftables.Set) ([]SetElement, error) {
+	return c.snapshot().GetSetElements(s)
+}
+
+// A jsonSnapshot is a Conn serving a single rule set of a JSONConn:
+// the one its GetGen read, or else the one the JSONConn had when it
+// was first used. Later reloads don't affect it.
+type jsonSnapshot struct {
+	c *JSONConn
+
+	mu sync.Mutex
+	rs *jsonRuleset
+}
+
+// GetGen implements Conn. It reads the rule set.
+func (s *jsonSnapshot) GetGen() (uint32, error) {
+	gen, rs, err := s.c.reload()
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rs = rs
+	return gen, nil
+}
+
+// ruleset returns the rule set of the snapshot.
+func (s *jsonSnapshot) ruleset() (*jsonRuleset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rs == nil {
+		rs, err := s.c.ruleset()
+		if err != nil {
+			return nil, err
+		}
+		s.rs = rs
+	}
+	return s.rs, nil
+}
+
+// ListTables implements Conn.
+func (s *jsonSnapshot) ListTables() ([]*nftables.Table, error) {
+	rs, err := s.ruleset()
+	if err != nil {
+		return nil, err
+	}
+	return rs.tables, nil
+}
+
+// ListChains implements Conn.
+func (s *jsonSnapshot) ListChains() ([]*nftables.Chain, error) {
+	rs, err := s.ruleset()
+	if err != nil {
+		return nil, err
+	}
+	return rs.chains, nil
+}
+
+// GetObjects implements Conn.
+func (s *jsonSnapshot) GetObjects(t *nftables.Table) ([]nftables.Obj, error) {
+	rs, err := s.ruleset()
+	if err != nil {
+		return nil, err
+	}
+	return rs.objs[newJSONTableKey(t)], nil
+}
+
+// GetQuotas implements Conn.
+func (s *jsonSnapshot) GetQuotas(t *nftables.Table) ([]*Quota, error) {
+	rs, err := s.ruleset()
+	if err != nil {
+		return nil, err
+	}
+	return rs.quotas[newJSONTableKey(t)], nil
+}
+
+// GetTableRules implements Conn.
+func (s *jsonSnapshot) GetTableRules(t *nftables.Table) (map[string][]*Rule, error) {
+	rs, err := s.ruleset()
+	if err != nil {
+		return nil, err
+	}
+	rules := rs.rules[newJSONTableKey(t)]
+	if rules == nil {
+		rules = map[string][]*Rule{}
+	}
+	return rules, nil
+}
+
+// GetSets implements Conn.
+func (s *jsonSnapshot) GetSets(t *nftables.Table) ([]*Set, error) {
+	rs, err := s.ruleset()
+	if err != nil {
+		return nil, err
+	}
+	return rs.sets[newJSONTableKey(t)], nil
+}
+
+// GetSetElements implements Conn.
+func (s *jsonSnapshot) GetSetElements(st *nftables.Set) ([]SetElement, error) {
+	rs, err := s.ruleset()
+	if err != nil {
+		return nil, err
+	}
+	k := jsonSetKey{newJSONTableKey(st.Table), st.Name}
+	if err := rs.elemErrs[k]; err != nil {
+		return nil, err
+	}
+	return rs.elems[k], nil
+}
+
+// jsonMetadataSum returns a hash of a JSON rule set, without the
+// values that change while the rule set doesn't, i.e. without a new
+// generation in the kernel: counters, used quota bytes and set
+// element expiry.
+func jsonMetadataSum(bs []byte) ([sha256.Size]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("parsing JSON rule set: %w", err)
+	}
+
+	stripJSONState(v)
+
+	// Object keys are sorted, so this is deterministic.
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(bs), nil
+}
+
+// stripJSONState removes the values jsonMetadataSum ignores.
+func stripJSONState(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		delete(v, "expires")
+		for k, vv := range v {
+			if m, ok := vv.(map[string]interface{}); ok {
+				switch k {
+				case "counter":
+					delete(m, "packets")
+					delete(m, "bytes")
+				case "quota":
+					delete(m, "used")
+					delete(m, "used_unit")
+				}
+			}
+			stripJSONState(vv)
+		}
+	case []interface{}:
+		for _, vv := range v {
+			stripJSONState(vv)
+		}
+	}
+}
+
+// A jsonRuleset is a parsed JSON rule set, in the shape of Conn.
+type jsonRuleset struct {
+	tables []*nftables.Table
+	chains []*nftables.Chain
+	objs   map[jsonTableKey][]nftables.Obj
+	quotas map[jsonTableKey][]*Quota
+	rules  map[jsonTableKey]map[string][]*Rule
+	sets   map[jsonTableKey][]*Set
+	elems  map[jsonSetKey][]SetElement
+
+	// elemErrs are the errors from encoding elements. They are
+	// returned by GetSetElements, so other sets are still exported.
+	elemErrs map[jsonSetKey]error
+}
+
+// A jsonTableKey identifies a table. Table pointers can't be used,
+// since callers may have them from an earlier rule set.
+type jsonTableKey struct {
+	family nftables.TableFamily
+	name   string
+}
+
+// newJSONTableKey returns the key of the table.
+func newJSONTableKey(t *nftables.Table) jsonTableKey {
+	return jsonTableKey{t.Family, t.Name}
+}
+
+// A jsonSetKey identifies a set.
+type jsonSetKey struct {
+	jsonTableKey
+	name string
+}
+
+// The JSON objects of the libnftables schema, see libnftables-json(5).
+// Only fields used by the collector are included.
+type (
+	jsonTable struct {
+		Family string      `json:"family"`
+		Name   string      `json:"name"`
+		Flags  jsonStrings `json:"flags"`
+	}
+
+	jsonChain struct {
+		Family string `json:"family"`
+		Table  string `json:"table"`
+		Name   string `json:"name"`
+		Type   string `json:"type"`
+		Hook   string `json:"hook"`
+		Prio   int32  `json:"prio"`
+		Policy string `json:"policy"`
+	}
+
+	jsonRule struct {
+		Family  string                       `json:"family"`
+		Table   string                       `json:"table"`
+		Chain   string                       `json:"chain"`
+		Handle  uint64                       `json:"handle"`
+		Comment string                       `json:"comment"`
+		Expr    []map[string]json.RawMessage `json:"expr"`
+	}
+
+	jsonCounter struct {
+		Family  string `json:"family"`
+		Table   string `json:"table"`
+		Name    string `json:"name"`
+		Packets uint64 `json:"packets"`
+		Bytes   uint64 `json:"bytes"`
+	}
+
+	jsonQuota struct {
+		Family string `json:"family"`
+		Table  string `json:"table"`
+		Name   string `json:"name"`
+		Bytes  uint64 `json:"bytes"`
+		Used   uint64 `json:"used"`
+		Inv    bool   `json:"inv"`
+	}
+
+	jsonSet struct {
+		Family  string            `json:"family"`
+		Table   string            `json:"table"`
+		Name    string            `json:"name"`
+		Type    jsonStrings       `json:"type"`
+		Map     jsonStrings       `json:"map"`
+		Flags   jsonStrings       `json:"flags"`
+		Timeout uint64            `json:"timeout"`
+		Elem    []json.RawMessage `json:"elem"`
+	}
+)
+
+// jsonStrings is a list of strings that is a single string if it has
+// only one item, as libnftables prints flags and concatenated types.
+type jsonStrings []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (ss *jsonStrings) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err == nil {
+		*ss = jsonStrings{s}
+		return nil
+	}
+	return json.Unmarshal(bs, (*[]string)(ss))
+}
+
+// has returns true if s is in the list.
+func (ss jsonStrings) has(s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseJSONRuleset parses the output of "nft -j list ruleset". Unknown
+// objects are ignored.
+func parseJSONRuleset(bs []byte) (*jsonRuleset, error) {
+	var doc struct {
+		NFTables []map[string]json.RawMessage `json:"nftables"`
+	}
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return nil, fmt.Errorf("parsing JSON rule set: %w", err)
+	}
+	if doc.NFTables == nil {
+		return nil, fmt.Errorf("parsing JSON rule set: no nftables array")
+	}
+
+	p := jsonParser{
+		rs: &jsonRuleset{
+			objs:     map[jsonTableKey][]nftables.Obj{},
+			quotas:   map[jsonTableKey][]*Quota{},
+			rules:    map[jsonTableKey]map[string][]*Rule{},
+			sets:     map[jsonTableKey][]*Set{},
+			elems:    map[jsonSetKey][]SetElement{},
+			elemErrs: map[jsonSetKey]error{},
+		},
+		tables: map[jsonTableKey]*nftables.Table{},
+	}
+	for i, o := range doc.NFTables {
+		for kind, v := range o {
+			if err := p.add(kind, v); err != nil {
+				return nil, fmt.Errorf("parsing JSON rule set item %d (%s): %w", i, kind, err)
+			}
+		}
+	}
+	return p.rs, nil
+}
+
+// A jsonParser collects the objects of a JSON rule set. Tables are
+// listed before their contents.
+type jsonParser struct {
+	rs     *jsonRuleset
+	tables map[jsonTableKey]*nftables.Table
+}
+
+// add adds a single object, given its kind, like "table".
+func (p *jsonParser) add(kind string, v json.RawMessage) error {
+	switch kind {
+	case "table":
+		var jt jsonTable
+		if err := json.Unmarshal(v, &jt); err != nil {
+			return err
+		}
+		tf, err := parseTableFamily(jt.Family)
+		if err != nil {
+			return err
+		}
+		t := &nftables.Table{Name: jt.Name, Family: tf}
+		if jt.Flags.has("dormant") {
+			t.Flags |= unix.NFT_TABLE_F_DORMANT
+		}
+		p.tables[newJSONTableKey(t)] = t
+		p.rs.tables = append(p.rs.tables, t)
+
+	case "chain":
+		var jc jsonChain
+		if err := json.Unmarshal(v, &jc); err != nil {
+			return err
+		}
+		t, err := p.table(jc.Family, jc.Table)
+		if err != nil {
+			return err
+		}
+		cn := &nftables.Chain{Name: jc.Name, Table: t, Type: nftables.ChainType(jc.Type), Priority: nftables.ChainPriority(jc.Prio)}
+		if jc.Hook != "" {
+			if cn.Hooknum, err = parseChainHook(t.Family, jc.Hook); err != nil {
+				// A newer nft may know hooks we don't. That
+				// only affects the labels of this chain.
+				log.Printf("Chain %s %s %s: %v (ignored)", jc.Family, jc.Table, jc.Name, err)
+				cn.Hooknum = unknownHook
+			}
+		}
+		if jc.Policy != "" {
+			policy, err := parseChainPolicy(jc.Policy)
+			if err != nil {
+				return err
+			}
+			cn.Policy = &policy
+		}
+		p.rs.chains = append(p.rs.chains, cn)
+
+	case "rule":
+		var jr jsonRule
+		if err := json.Unmarshal(v, &jr); err != nil {
+			return err
+		}
+		t, err := p.table(jr.Family, jr.Table)
+		if err != nil {
+			return err
+		}
+		r, err := parseJSONRule(t, &jr)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", jr.Handle, err)
+		}
+		k := newJSONTableKey(t)
+		if p.rs.rules[k] == nil {
+			p.rs.rules[k] = map[string][]*Rule{}
+		}
+		p.rs.rules[k][jr.Chain] = append(p.rs.rules[k][jr.Chain], r)
+
+	case "counter":
+		var jc jsonCounter
+		if err := json.Unmarshal(v, &jc); err != nil {
+			return err
+		}
+		t, err := p.table(jc.Family, jc.Table)
+		if err != nil {
+			return err
+		}
+		k := newJSONTableKey(t)
+		p.rs.objs[k] = append(p.rs.objs[k], &nftables.CounterObj{Table: t, Name: jc.Name, Packets: jc.Packets, Bytes: jc.Bytes})
+
+	case "quota":
+		var jq jsonQuota
+		if err := json.Unmarshal(v, &jq); err != nil {
+			return err
+		}
+		t, err := p.table(jq.Family, jq.Table)
+		if err != nil {
+			return err
+		}
+		q := &Quota{Table: t, Name: jq.Name, Bytes: jq.Bytes, Consumed: jq.Used}
+		if jq.Inv {
+			q.Flags |= unix.NFT_QUOTA_F_INV
+		}
+		k := newJSONTableKey(t)
+		p.rs.quotas[k] = append(p.rs.quotas[k], q)
+
+	case "set", "map":
+		var js jsonSet
+		if err := json.Unmarshal(v, &js); err != nil {
+			return err
+		}
+		t, err := p.table(js.Family, js.Table)
+		if err != nil {
+			return err
+		}
+		st, err := parseJSONSet(t, kind == "map", &js)
+		if err != nil {
+			return fmt.Errorf("set %s: %w", js.Name, err)
+		}
+		k := newJSONTableKey(t)
+		p.rs.sets[k] = append(p.rs.sets[k], st)
+
+		sk := jsonSetKey{k, st.Name}
+		els, err := parseJSONSetElements(st, js.Elem)
+		if err != nil {
+			p.rs.elemErrs[sk] = fmt.Errorf("set %s: %w", st.Name, err)
+		} else {
+			p.rs.elems[sk] = els
+		}
+	}
+
+	return nil
+}
+
+// table returns a table seen earlier.
+func (p *jsonParser) table(family, name string) (*nftables.Table, error) {
+	tf, err := parseTableFamily(family)
+	if err != nil {
+		return nil, err
+	}
+	t := p.tables[jsonTableKey{tf, name}]
+	if t == nil {
+		return nil, fmt.Errorf("unknown table %s %s", family, name)
+	}
+	return t, nil
+}
+
+// parseJSONRule converts a rule. The comment is stored in the user
+// data, like nft does.
+func parseJSONRule(t *nftables.Table, jr *jsonRule) (*Rule, error) {
+	r := &Rule{Rule: &nftables.Rule{Table: t, Chain: &nftables.Chain{Name: jr.Chain, Table: t}, Handle: jr.Handle}}
+	if jr.Comment != "" {
+		r.UserData = ruleCommentUserData(jr.Comment)
+	}
+
+	for _, e := range jr.Expr {
+		for name, v := range e {
+			if err := r.addJSONExpr(name, v); err != nil {
+				return nil, fmt.Errorf("%s expression: %w", name, err)
+			}
+		}
+	}
+	return r, nil
+}
+
+// addJSONExpr converts a single rule expression.
+func (r *Rule) addJSONExpr(name string, v json.RawMessage) error {
+	if vd, ok, err := parseJSONVerdict(name, v); err != nil {
+		return err
+	} else if ok {
+		r.Exprs = append(r.Exprs, vd)
+		return nil
+	}
+
+	switch name {
+	case "counter":
+		var ref string
+		if err := json.Unmarshal(v, &ref); err == nil {
+			// A reference to a named counter.
+			r.Exprs = append(r.Exprs, &expr.Objref{Type: nftObjectCounter, Name: ref})
+			return nil
+		}
+
+		var cnt struct {
+			Packets uint64 `json:"packets"`
+			Bytes   uint64 `json:"bytes"`
+		}
+		if err := json.Unmarshal(v, &cnt); err != nil {
+			return err
+		}
+		r.Exprs = append(r.Exprs, &expr.Counter{Packets: cnt.Packets, Bytes: cnt.Bytes})
+
+	case "xt":
+		// The extension data isn't included, so comments of
+		// iptables-nft rules are lost.
+		var xt struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(v, &xt); err != nil {
+			return err
+		}
+		r.Others = append(r.Others, OtherExpr{Index: len(r.Exprs), Name: xt.Type, XT: &XTInfo{Name: xt.Name}})
+
+	default:
+		r.Others = append(r.Others, OtherExpr{Index: len(r.Exprs), Name: name})
+	}
+
+	return nil
+}
+
+// parseJSONVerdict returns the verdict, if the expression is one.
+func parseJSONVerdict(name string, v json.RawMessage) (*expr.Verdict, bool, error) {
+	var kind expr.VerdictKind
+	switch name {
+	case "accept":
+		kind = expr.VerdictAccept
+	case "drop":
+		kind = expr.VerdictDrop
+	case "continue":
+		kind = expr.VerdictContinue
+	case "return":
+		kind = expr.VerdictReturn
+	case "jump":
+		kind = expr.VerdictJump
+	case "goto":
+		kind = expr.VerdictGoto
+	default:
+		return nil, false, nil
+	}
+
+	vd := &expr.Verdict{Kind: kind}
+	if kind == expr.VerdictJump || kind == expr.VerdictGoto {
+		var target struct {
+			Target string `json:"target"`
+		}
+		if err := json.Unmarshal(v, &target); err != nil {
+			return nil, false, err
+		}
+		vd.Chain = target.Target
+	}
+	return vd, true, nil
+}
+
+// ruleCommentUserData returns rule user data holding a comment.
+func ruleCommentUserData(comment string) []byte {
+	bs := append([]byte(comment), 0)
+	if len(bs) > math.MaxUint8 {
+		bs = append(bs[:math.MaxUint8-1], 0)
+	}
+	return append([]byte{byte(udata.RuleComment), byte(len(bs))}, bs...)
+}
+
+// parseJSONSet converts a set or map, without elements.
+func parseJSONSet(t *nftables.Table, isMap bool, js *jsonSet) (*Set, error) {
+	st := &Set{Set: &nftables.Set{
+		Table:      t,
+		Name:       js.Name,
+		Constant:   js.Flags.has("constant"),
+		Interval:   js.Flags.has("interval"),
+		IsMap:      isMap,
+		HasTimeout: js.Flags.has("timeout") || js.Timeout != 0,
+		Timeout:    time.Duration(js.Timeout) * time.Second,
+	}}
+
+	var err error
+	if st.KeyType, err = parseJSONDatatype(js.Type); err != nil {
+		return nil, err
+	}
+	if isMap {
+		if st.DataType, err = parseJSONDatatype(js.Map); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// parseJSONDatatype returns the datatype, given its name, or the
+// names of a concatenation.
+func parseJSONDatatype(names jsonStrings) (nftables.SetDatatype, error) {
+	dts := make([]nftables.SetDatatype, len(names))
+	for i, name := range names {
+		dt, ok := setDatatypeByName(name)
+		if !ok {
+			if len(names) > 1 {
+				return nftables.SetDatatype{}, fmt.Errorf("unknown datatype in concatenation: %s", name)
+			}
+			return nftables.SetDatatype{Name: name}, nil
+		}
+		dts[i] = dt
+	}
+
+	switch len(dts) {
+	case 0:
+		return nftables.SetDatatype{}, fmt.Errorf("missing datatype")
+	case 1:
+		return dts[0], nil
+	default:
+		return nftables.ConcatSetType(dts...)
+	}
+}
+
+// setDatatypeByName returns the named datatype, if known.
+func setDatatypeByName(name string) (nftables.SetDatatype, bool) {
+	if name == nftables.TypeVerdict.Name {
+		return nftables.TypeVerdict, true
+	}
+	for _, dt := range setDatatypes {
+		if dt.Name == name {
+			return dt, true
+		}
+	}
+	return nftables.SetDatatype{}, false
+}
+
+// parseJSONSetElements converts the elements of a set. Elements of
+// interval sets become a start and an end element, like in netlink
+// dumps, unless the set has a concatenated key, where they have a
+// KeyEnd.
+func parseJSONSetElements(st *Set, jes []json.RawMessage) ([]SetElement, error) {
+	concat := concatDatatypes(st.KeyType) != nil
+	var els []SetElement
+	for _, je := range jes {
+		key, val, cnt, err := splitJSONSetElement(st, je)
+		if err != nil {
+			return nil, err
+		}
+
+		el := SetElement{Counter: cnt}
+		start, end, err := encodeJSONKey(st, key)
+		if err != nil {
+			return nil, err
+		}
+		el.Key = start
+
+		if val != nil {
+			vd, ok, err := parseJSONVerdictValue(val)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				el.VerdictData = vd
+			} else if el.Val, _, err = encodeJSONValue(st.DataType, datatypeByteOrder(st.DataType), val); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case !st.Interval:
+			els = append(els, el)
+
+		case concat:
+			el.KeyEnd = end
+			els = append(els, el)
+
+		default:
+			els = append(els, el)
+			if !bytes.Equal(end, bytes.Repeat([]byte{0xFF}, len(end))) {
+				els = append(els, SetElement{SetElement: nftables.SetElement{Key: incrementBytes(end), IntervalEnd: true}})
+			}
+		}
+	}
+	return els, nil
+}
+
+// splitJSONSetElement returns the key, the map value (or nil) and the
+// counter (or nil) of a JSON set element.
+func splitJSONSetElement(st *Set, je json.RawMessage) (json.RawMessage, json.RawMessage, *expr.Counter, error) {
+	var key, val json.RawMessage = je, nil
+	if st.IsMap {
+		var kv []json.RawMessage
+		if err := json.Unmarshal(je, &kv); err != nil || len(kv) != 2 {
+			return nil, nil, nil, fmt.Errorf("map element is not a key and value pair: %s", je)
+		}
+		key, val = kv[0], kv[1]
+	}
+
+	var wrapped struct {
+		Elem *struct {
+			Val     json.RawMessage `json:"val"`
+			Counter *expr.Counter   `json:"counter"`
+		} `json:"elem"`
+	}
+	if isJSONObject(key) {
+		if err := json.Unmarshal(key, &wrapped); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if wrapped.Elem == nil {
+		return key, val, nil, nil
+	}
+	return wrapped.Elem.Val, val, wrapped.Elem.Counter, nil
+}
+
+// parseJSONVerdictValue returns the verdict of a verdict map value, if
+// it is one.
+func parseJSONVerdictValue(v json.RawMessage) (*expr.Verdict, bool, error) {
+	if !isJSONObject(v) {
+		return nil, false, nil
+	}
+	var o map[string]json.RawMessage
+	if err := json.Unmarshal(v, &o); err != nil {
+		return nil, false, err
+	}
+	for name, v := range o {
+		return parseJSONVerdict(name, v)
+	}
+	return nil, false, nil
+}
+
+// encodeJSONKey encodes a set element key, returning the inclusive
+// end, which is the same as the start for single values.
+func encodeJSONKey(st *Set, key json.RawMessage) ([]byte, []byte, error) {
+	dts := concatDatatypes(st.KeyType)
+	if dts == nil {
+		bo := datatypeByteOrder(st.KeyType)
+		if st.Interval {
+			bo = udata.ByteOrderBigEndian
+		}
+		return encodeJSONValue(st.KeyType, bo, key)
+	}
+
+	var concat struct {
+		Concat []json.RawMessage `json:"concat"`
+	}
+	if err := json.Unmarshal(key, &concat); err != nil || len(concat.Concat) != len(dts) {
+		return nil, nil, fmt.Errorf("element is not a concatenation of %s: %s", st.KeyType.Name, key)
+	}
+
+	var start, end []byte
+	for i, dt := range dts {
+		s, e, err := encodeJSONValue(dt, datatypeByteOrder(dt), concat.Concat[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if n := len(s) % 4; n != 0 {
+			pad := make([]byte, 4-n)
+			s = append(s, pad...)
+			e = append(e, pad...)
+		}
+		start = append(start, s...)
+		end = append(end, e...)
+	}
+	return start, end, nil
+}
+
+// encodeJSONValue encodes a value, a prefix or a range of the given
+// datatype, returning the start and inclusive end.
+func encodeJSONValue(dt nftables.SetDatatype, bo udata.ByteOrder, v json.RawMessage) ([]byte, []byte, error) {
+	if isJSONObject(v) {
+		var o struct {
+			Prefix *struct {
+				Addr json.RawMessage `json:"addr"`
+				Len  int             `json:"len"`
+			} `json:"prefix"`
+			Range []json.RawMessage `json:"range"`
+		}
+		if err := json.Unmarshal(v, &o); err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case o.Prefix != nil:
+			addr, err := encodeJSONDatum(dt, bo, o.Prefix.Addr)
+			if err != nil {
+				return nil, nil, err
+			}
+			if o.Prefix.Len < 0 || o.Prefix.Len > len(addr)*8 {
+				return nil, nil, fmt.Errorf("invalid prefix length: %d", o.Prefix.Len)
+			}
+			start := append([]byte(nil), addr...)
+			end := append([]byte(nil), addr...)
+			for i := o.Prefix.Len; i < len(addr)*8; i++ {
+				start[i/8] &^= 0x80 >> uint(i%8)
+				end[i/8] |= 0x80 >> uint(i%8)
+			}
+			return start, end, nil
+
+		case len(o.Range) == 2:
+			start, err := encodeJSONDatum(dt, bo, o.Range[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			end, err := encodeJSONDatum(dt, bo, o.Range[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			return start, end, nil
+		}
+	}
+
+	bs, err := encodeJSONDatum(dt, bo, v)
+	return bs, bs, err
+}
+
+// encodeJSONDatum encodes a single value of the given datatype, the
+// inverse of datatypeString.
+func encodeJSONDatum(dt nftables.SetDatatype, bo udata.ByteOrder, v json.RawMessage) ([]byte, error) {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		var ss []string
+		if dt.Name != nftables.TypeCTState.Name || json.Unmarshal(v, &ss) != nil {
+			// Numbers are handled as strings.
+			var n json.Number
+			if err := json.Unmarshal(v, &n); err != nil {
+				return nil, fmt.Errorf("unsupported %s value: %s", dt.Name, v)
+			}
+			s = n.String()
+		} else {
+			s = strings.Join(ss, ",")
+		}
+	}
+
+	switch dt.Name {
+	case nftables.TypeIPAddr.Name:
+		if ip := net.ParseIP(s).To4(); ip != nil {
+			return ip, nil
+		}
+	case nftables.TypeIP6Addr.Name:
+		if ip := net.ParseIP(s); ip != nil && ip.To4() == nil {
+			return ip.To16(), nil
+		}
+	case nftables.TypeEtherAddr.Name, nftables.TypeLLAddr.Name:
+		if mac, err := net.ParseMAC(s); err == nil {
+			return mac, nil
+		}
+	case nftables.TypeIFName.Name, nftables.TypeString.Name:
+		n := int(dt.Bytes)
+		if n == 0 {
+			n = len(s) + 1
+		}
+		if len(s) < n {
+			bs := make([]byte, n)
+			copy(bs, s)
+			return bs, nil
+		}
+	case nftables.TypeCTState.Name:
+		var mask uint64
+		for _, name := range strings.Split(s, ",") {
+			bit, ok := namedValue(strings.TrimSpace(name), ctStateMaskString, uint64(expr.CtStateBitINVALID), uint64(expr.CtStateBitESTABLISHED), uint64(expr.CtStateBitRELATED), uint64(expr.CtStateBitNEW), uint64(expr.CtStateBitUNTRACKED))
+			if !ok {
+				return nil, fmt.Errorf("unknown ct_state: %s", name)
+			}
+			mask |= bit
+		}
+		return encodeInteger(bo, int(dt.Bytes), mask)
+	case nftables.TypeInetProto.Name:
+		if v, ok := namedValue(s, inetProtoString, unix.IPPROTO_ICMP, unix.IPPROTO_IGMP, unix.IPPROTO_TCP, unix.IPPROTO_UDP, unix.IPPROTO_GRE, unix.IPPROTO_ESP, unix.IPPROTO_AH, unix.IPPROTO_ICMPV6, unix.IPPROTO_SCTP, unix.IPPROTO_UDPLITE); ok {
+			return encodeInteger(bo, int(dt.Bytes), v)
+		}
+	case nftables.TypeEtherType.Name:
+		if v, ok := namedValue(s, etherTypeString, unix.ETH_P_IP, unix.ETH_P_IPV6, unix.ETH_P_ARP, unix.ETH_P_8021Q, unix.ETH_P_8021AD); ok {
+			return encodeInteger(bo, int(dt.Bytes), v)
+		}
+	case nftables.TypeNFProto.Name:
+		if v, ok := namedValue(s, nfProtoString, unix.NFPROTO_IPV4, unix.NFPROTO_IPV6); ok {
+			return encodeInteger(bo, int(dt.Bytes), v)
+		}
+	}
+
+	if n, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return encodeInteger(bo, int(dt.Bytes), n)
+	}
+	return nil, fmt.Errorf("unsupported %s value: %s", dt.Name, v)
+}
+
+// namedValue returns the candidate whose name is s.
+func namedValue(s string, name func(uint64) string, candidates ...uint64) (uint64, bool) {
+	for _, v := range candidates {
+		if name(v) == s {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// encodeInteger encodes an integer of n bytes, the inverse of
+// integerValue.
+func encodeInteger(bo udata.ByteOrder, n int, v uint64) ([]byte, error) {
+	if n <= 0 || n > 8 || (n < 8 && v >= 1<<(8*uint(n))) {
+		return nil, fmt.Errorf("integer %d doesn't fit in %d bytes", v, n)
+	}
+
+	var buf [8]byte
+	if bo == udata.ByteOrderHostEndian && nlenc.NativeEndian() == binary.LittleEndian {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		return buf[:n], nil
+	}
+	binary.BigEndian.PutUint64(buf[:], v)
+	return buf[8-n:], nil
+}
+
+// incrementBytes returns a big endian number plus one, the inverse of
+// decrementBytes.
+func incrementBytes(bs []byte) []byte {
+	bs = append([]byte(nil), bs...)
+	for i := len(bs) - 1; i >= 0; i-- {
+		bs[i]++
+		if bs[i] != 0 {
+			break
+		}
+	}
+	return bs
+}
+
+// isJSONObject returns true if the value is an object.
+func isJSONObject(v json.RawMessage) bool {
+	v = bytes.TrimSpace(v)
+	return len(v) > 0 && v[0] == '{'
+}
+
+// parseTableFamily parses the family name of a table.
+func parseTableFamily(s string) (nftables.TableFamily, error) {
+	for _, tf := range []nftables.TableFamily{
+		nftables.TableFamilyINet,
+		nftables.TableFamilyIPv4,
+		nftables.TableFamilyIPv6,
+		nftables.TableFamilyARP,
+		nftables.TableFamilyNetdev,
+		nftables.TableFamilyBridge,
+	} {
+		if TableFamilyString(tf) == s {
+			return tf, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown table family: %q", s)
+}
+
+// parseChainHook parses the hook name of a base chain.
+func parseChainHook(tf nftables.TableFamily, s string) (nftables.ChainHook, error) {
+	for i, name := range hookNames[tf] {
+		if name == s {
+			return nftables.ChainHook(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hook: %q", s)
+}
+
+// parseChainPolicy parses the policy of a base chain.
+func parseChainPolicy(s string) (nftables.ChainPolicy, error) {
+	for _, p := range []nftables.ChainPolicy{nftables.ChainPolicyAccept, nftables.ChainPolicyDrop} {
+		if ChainPolicyString(&p) == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown policy: %q", s)
+}
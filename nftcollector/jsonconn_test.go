@@ -0,0 +1,380 @@
+package nftcollector
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+const testJSONRuleset = `{"nftables": [
+  {"metainfo": {"version": "1.0.2", "release_name": "Lester Gooch", "json_schema_version": 1}},
+  {"table": {"family": "inet", "name": "filter", "handle": 1}},
+  {"table": {"family": "ip", "name": "nat", "handle": 2, "flags": "dormant"}},
+  {"chain": {"family": "inet", "table": "filter", "name": "input", "handle": 1, "type": "filter", "hook": "input", "prio": 0, "policy": "drop"}},
+  {"chain": {"family": "inet", "table": "filter", "name": "web", "handle": 2}},
+  {"counter": {"family": "inet", "name": "web", "table": "filter", "handle": 3, "packets": 42, "bytes": 4711}},
+  {"quota": {"family": "inet", "name": "daily", "table": "filter", "handle": 4, "bytes": 1000, "used": 10, "inv": true}},
+  {"set": {"family": "inet", "name": "allow", "table": "filter", "type": "ipv4_addr", "handle": 5, "flags": ["interval"],
+    "elem": [{"prefix": {"addr": "10.0.0.0", "len": 8}}, {"elem": {"val": {"range": ["192.168.0.1", "192.168.0.9"]}, "counter": {"packets": 1, "bytes": 2}}}]}},
+  {"map": {"family": "inet", "name": "ports", "table": "filter", "type": "inet_service", "handle": 6, "map": "verdict",
+    "elem": [[22, {"jump": {"target": "web"}}], [{"elem": {"val": 80, "counter": {"packets": 3, "bytes": 4}}}, {"accept": null}]]}},
+  {"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 7, "comment": "web",
+    "expr": [{"match": {"op": "==", "left": {"payload": {"protocol": "tcp", "field": "dport"}}, "right": 80}}, {"counter": {"packets": 5, "bytes": 6}}, {"jump": {"target": "web"}}]}},
+  {"rule": {"family": "inet", "table": "filter", "chain": "web", "handle": 8,
+    "expr": [{"counter": "web"}, {"xt": {"type": "match", "name": "comment"}}, {"accept": null}]}}
+]}`
+
+func TestJSONConn(t *testing.T) {
+	conn := NewJSONConn(func() ([]byte, error) { return []byte(testJSONRuleset), nil })
+
+	if _, err := conn.GetGen(); err != nil {
+		t.Fatalf("GetGen failed: %v", err)
+	}
+
+	filter := &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}
+	tables, err := conn.ListTables()
+	if err != nil {
+		t.Fatalf("ListTables failed: %v", err)
+	}
+	wantTables := []*nftables.Table{
+		filter,
+		{Name: "nat", Family: nftables.TableFamilyIPv4, Flags: unix.NFT_TABLE_F_DORMANT},
+	}
+	if !reflect.DeepEqual(tables, wantTables) {
+		t.Errorf("ListTables: got %+v, want %+v", tables, wantTables)
+	}
+
+	chains, err := conn.ListChains()
+	if err != nil {
+		t.Fatalf("ListChains failed: %v", err)
+	}
+	drop := nftables.ChainPolicyDrop
+	wantChains := []*nftables.Chain{
+		{Name: "input", Table: filter, Hooknum: nftables.ChainHookInput, Type: nftables.ChainTypeFilter, Policy: &drop},
+		{Name: "web", Table: filter},
+	}
+	if !reflect.DeepEqual(chains, wantChains) {
+		t.Errorf("ListChains: got %+v, want %+v", chains, wantChains)
+	}
+
+	objs, err := conn.GetObjects(filter)
+	if err != nil {
+		t.Fatalf("GetObjects failed: %v", err)
+	}
+	wantObjs := []nftables.Obj{&nftables.CounterObj{Table: filter, Name: "web", Packets: 42, Bytes: 4711}}
+	if !reflect.DeepEqual(objs, wantObjs) {
+		t.Errorf("GetObjects: got %+v, want %+v", objs, wantObjs)
+	}
+
+	quotas, err := conn.GetQuotas(filter)
+	if err != nil {
+		t.Fatalf("GetQuotas failed: %v", err)
+	}
+	wantQuotas := []*Quota{{Table: filter, Name: "daily", Bytes: 1000, Consumed: 10, Flags: unix.NFT_QUOTA_F_INV}}
+	if !reflect.DeepEqual(quotas, wantQuotas) {
+		t.Errorf("GetQuotas: got %+v, want %+v", quotas, wantQuotas)
+	}
+
+	rules, err := conn.GetTableRules(filter)
+	if err != nil {
+		t.Fatalf("GetTableRules failed: %v", err)
+	}
+	wantRules := map[string][]*Rule{
+		"input": {{
+			Rule: &nftables.Rule{Table: filter, Chain: &nftables.Chain{Name: "input", Table: filter}, Handle: 7,
+				Exprs:    []expr.Any{&expr.Counter{Packets: 5, Bytes: 6}, &expr.Verdict{Kind: expr.VerdictJump, Chain: "web"}},
+				UserData: makeRuleComment("web")},
+			Others: []OtherExpr{{Index: 0, Name: "match"}},
+		}},
+		"web": {{
+			Rule: &nftables.Rule{Table: filter, Chain: &nftables.Chain{Name: "web", Table: filter}, Handle: 8,
+				Exprs: []expr.Any{&expr.Objref{Type: nftObjectCounter, Name: "web"}, &expr.Verdict{Kind: expr.VerdictAccept}}},
+			Others: []OtherExpr{{Index: 1, Name: "match", XT: &XTInfo{Name: "comment"}}},
+		}},
+	}
+	if !reflect.DeepEqual(rules, wantRules) {
+		t.Errorf("GetTableRules: got %+v, want %+v", rules, wantRules)
+	}
+	if got, err := RuleComment(rules["input"][0]); err != nil || got != "web" {
+		t.Errorf("RuleComment: got %q, %v, want %q", got, err, "web")
+	}
+
+	sets, err := conn.GetSets(filter)
+	if err != nil {
+		t.Fatalf("GetSets failed: %v", err)
+	}
+	wantSets := []*Set{
+		{Set: &nftables.Set{Table: filter, Name: "allow", Interval: true, KeyType: nftables.TypeIPAddr}},
+		{Set: &nftables.Set{Table: filter, Name: "ports", IsMap: true, KeyType: nftables.TypeInetService, DataType: nftables.TypeVerdict}},
+	}
+	if !reflect.DeepEqual(sets, wantSets) {
+		t.Errorf("GetSets: got %+v, want %+v", sets, wantSets)
+	}
+
+	els, err := conn.GetSetElements(sets[1].Set)
+	if err != nil {
+		t.Fatalf("GetSetElements failed: %v", err)
+	}
+	wantEls := []SetElement{
+		{SetElement: nftables.SetElement{Key: []byte{0, 22}, VerdictData: &expr.Verdict{Kind: expr.VerdictJump, Chain: "web"}}},
+		{SetElement: nftables.SetElement{Key: []byte{0, 80}, VerdictData: &expr.Verdict{Kind: expr.VerdictAccept}}, Counter: &expr.Counter{Packets: 3, Bytes: 4}},
+	}
+	if !reflect.DeepEqual(els, wantEls) {
+		t.Errorf("GetSetElements: got %+v, want %+v", els, wantEls)
+	}
+}
+
+func TestParseJSONSetElements(t *testing.T) {
+	tsts := []struct {
+		name  string
+		set   string
+		want  []string // Element keys, as exported.
+		nEls  int      // Elements, as in a netlink dump.
+		wantE bool
+	}{
+		{
+			name: "ipv4",
+			set:  `{"name": "s", "type": "ipv4_addr", "elem": ["10.0.0.1", {"elem": {"val": "10.0.0.2", "counter": {"packets": 1}}}]}`,
+			want: []string{"10.0.0.1", "10.0.0.2"},
+			nEls: 2,
+		},
+		{
+			name: "interval",
+			set:  `{"name": "s", "type": "ipv4_addr", "flags": "interval", "elem": ["10.0.0.1", {"prefix": {"addr": "10.1.0.0", "len": 16}}, {"range": ["10.2.0.1", "10.2.0.9"]}, {"prefix": {"addr": "128.0.0.0", "len": 1}}]}`,
+			want: []string{"10.0.0.1", "10.1.0.0/16", "10.2.0.1-10.2.0.9", "128.0.0.0/1"},
+			nEls: 7,
+		},
+		{
+			name: "concat",
+			set:  `{"name": "s", "type": ["ipv6_addr", "inet_proto", "inet_service"], "flags": ["interval"], "elem": [{"concat": [{"prefix": {"addr": "2001:db8::", "len": 32}}, "tcp", {"range": [80, 88]}]}]}`,
+			want: []string{"2001:db8::/32 . tcp . 80-88"},
+			nEls: 1,
+		},
+		{
+			name: "hostEndian",
+			set:  `{"name": "s", "type": ["mark", "ct_state"], "elem": [{"concat": ["0x0000002a", ["established", "related"]]}]}`,
+			want: []string{"0x0000002a . established,related"},
+			nEls: 1,
+		},
+		{
+			name: "ifname",
+			set:  `{"name": "s", "type": "ifname", "elem": ["eth0"]}`,
+			want: []string{"eth0"},
+			nEls: 1,
+		},
+		{
+			name:  "unsupported",
+			set:   `{"name": "s", "type": "ipv4_addr", "elem": ["example.com"]}`,
+			wantE: true,
+		},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			bs := []byte(`{"nftables": [{"table": {"family": "inet", "name": "filter"}}, {"set": ` + tst.set[:1] + `"family": "inet", "table": "filter", ` + tst.set[1:] + `}]}`)
+			conn := NewJSONConn(func() ([]byte, error) { return bs, nil })
+
+			sets, err := conn.GetSets(&nftables.Table{Name: "filter", Family: nftables.TableFamilyINet})
+			if err != nil {
+				t.Fatalf("GetSets failed: %v", err)
+			}
+			els, err := conn.GetSetElements(sets[0].Set)
+			if (err != nil) != tst.wantE {
+				t.Fatalf("GetSetElements err: got %v, want error %v", err, tst.wantE)
+			}
+			if tst.wantE {
+				return
+			}
+
+			if len(els) != tst.nEls {
+				t.Errorf("GetSetElements: got %d elements, want %d", len(els), tst.nEls)
+			}
+			if sets[0].Interval {
+				els = setIntervalElements(els)
+			}
+			var got []string
+			for _, el := range els {
+				got = append(got, SetElementKeyString(sets[0], el))
+			}
+			if !reflect.DeepEqual(got, tst.want) {
+				t.Errorf("SetElementKeyString: got %q, want %q", got, tst.want)
+			}
+		})
+	}
+}
+
+func TestJSONConnChainHooks(t *testing.T) {
+	tsts := []struct {
+		family string
+		hook   string
+		want   nftables.ChainHook
+	}{
+		{"bridge", "input", nftables.ChainHookInput},
+		{"bridge", "postrouting", nftables.ChainHookPostrouting},
+		{"arp", "input", 0},
+		{"arp", "output", 1},
+		{"netdev", "ingress", nftables.ChainHookIngress},
+		{"netdev", "egress", 1},
+		{"inet", "ingress", 5},
+		{"inet", "future", unknownHook},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.family+"_"+tst.hook, func(t *testing.T) {
+			ruleset := `{"nftables": [
+  {"table": {"family": "` + tst.family + `", "name": "filter"}},
+  {"chain": {"family": "` + tst.family + `", "table": "filter", "name": "base", "type": "filter", "hook": "` + tst.hook + `", "prio": 0, "policy": "accept"}},
+  {"chain": {"family": "` + tst.family + `", "table": "filter", "name": "other"}}
+]}`
+			conn := NewJSONConn(func() ([]byte, error) { return []byte(ruleset), nil })
+
+			chains, err := conn.ListChains()
+			if err != nil {
+				t.Fatalf("ListChains failed: %v", err)
+			}
+			if len(chains) != 2 {
+				t.Fatalf("ListChains: got %+v, want 2 chains", chains)
+			}
+			if got := chains[0].Hooknum; got != tst.want {
+				t.Errorf("Hooknum: got %v, want %v", got, tst.want)
+			}
+			want := tst.hook
+			if tst.want == unknownHook {
+				want = "unknown"
+			}
+			if got := HookString(chains[0].Table.Family, chains[0].Hooknum); got != want {
+				t.Errorf("HookString: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONConnGetGen(t *testing.T) {
+	ruleset := `{"nftables": []}`
+	var readErr error
+	conn := NewJSONConn(func() ([]byte, error) { return []byte(ruleset), readErr })
+
+	gen, err := conn.GetGen()
+	if err != nil {
+		t.Fatalf("GetGen failed: %v", err)
+	}
+
+	if got, err := conn.GetGen(); err != nil || got != gen {
+		t.Errorf("GetGen unchanged: got %v, %v, want %v", got, err, gen)
+	}
+
+	ruleset = `{"nftables": [{"table": {"family": "inet", "name": "filter"}}]}`
+	if got, err := conn.GetGen(); err != nil || got == gen {
+		t.Errorf("GetGen changed: got %v, %v, want not %v", got, err, gen)
+	}
+	if tables, err := conn.ListTables(); err != nil || len(tables) != 1 {
+		t.Errorf("ListTables: got %+v, %v, want 1 table", tables, err)
+	}
+
+	readErr = errors.New("mocked")
+	if _, err := conn.GetGen(); !errors.Is(err, readErr) {
+		t.Errorf("GetGen err: got %v, want %v", err, readErr)
+	}
+
+	readErr = nil
+	ruleset = `{"nftables": [{"chain": {"family": "inet", "table": "missing", "name": "input"}}]}`
+	if _, err := conn.GetGen(); err == nil {
+		t.Errorf("GetGen unknown table: got nil, want error")
+	}
+}
+
+func TestJSONConnGetGenCounters(t *testing.T) {
+	ruleset := func(pkts int) string {
+		return fmt.Sprintf(`{"nftables": [
+  {"table": {"family": "inet", "name": "filter"}},
+  {"chain": {"family": "inet", "table": "filter", "name": "input"}},
+  {"counter": {"family": "inet", "name": "web", "table": "filter", "packets": %[1]d, "bytes": %[1]d}},
+  {"quota": {"family": "inet", "name": "daily", "table": "filter", "bytes": 1000, "used": %[1]d}},
+  {"set": {"family": "inet", "name": "seen", "table": "filter", "type": "ipv4_addr", "flags": ["timeout"],
+    "elem": [{"elem": {"val": "10.0.0.1", "timeout": 60, "expires": %[1]d, "counter": {"packets": %[1]d, "bytes": %[1]d}}}]}},
+  {"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 1,
+    "expr": [{"counter": {"packets": %[1]d, "bytes": %[1]d}}]}}
+]}`, pkts)
+	}
+	s := ruleset(1)
+	conn := NewJSONConn(func() ([]byte, error) { return []byte(s), nil })
+	filter := &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}
+
+	gen, err := conn.GetGen()
+	if err != nil {
+		t.Fatalf("GetGen failed: %v", err)
+	}
+
+	s = ruleset(2)
+	if got, err := conn.GetGen(); err != nil || got != gen {
+		t.Errorf("GetGen with new counter values: got %v, %v, want %v", got, err, gen)
+	}
+	rules, err := conn.GetTableRules(filter)
+	if err != nil {
+		t.Fatalf("GetTableRules failed: %v", err)
+	}
+	if got := ruleCounter(rules["input"][0].Rule); got == nil || got.Packets != 2 {
+		t.Errorf("GetTableRules counter: got %+v, want 2 packets", got)
+	}
+
+	s = strings.Replace(ruleset(2), `"bytes": 1000`, `"bytes": 2000`, 1)
+	if got, err := conn.GetGen(); err != nil || got == gen {
+		t.Errorf("GetGen with new quota limit: got %v, %v, want not %v", got, err, gen)
+	}
+}
+
+func TestJSONConnSnapshot(t *testing.T) {
+	ruleset := `{"nftables": [{"table": {"family": "inet", "name": "filter"}}]}`
+	conn := NewJSONConn(func() ([]byte, error) { return []byte(ruleset), nil })
+
+	snap := conn.snapshot()
+	if _, err := snap.GetGen(); err != nil {
+		t.Fatalf("GetGen failed: %v", err)
+	}
+
+	// Another collection reads a new rule set.
+	ruleset = `{"nftables": [{"table": {"family": "inet", "name": "filter"}}, {"table": {"family": "ip", "name": "nat"}}]}`
+	if _, err := conn.GetGen(); err != nil {
+		t.Fatalf("GetGen failed: %v", err)
+	}
+
+	if tables, err := snap.ListTables(); err != nil || len(tables) != 1 {
+		t.Errorf("snapshot ListTables: got %+v, %v, want 1 table", tables, err)
+	}
+	if tables, err := conn.ListTables(); err != nil || len(tables) != 2 {
+		t.Errorf("ListTables: got %+v, %v, want 2 tables", tables, err)
+	}
+}
+
+func TestNewJSONCommandConn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ruleset.json")
+	if err := ioutil.WriteFile(path, []byte(testJSONRuleset), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := NewJSONCommandConn("cat " + path)
+	if err != nil {
+		t.Fatalf("NewJSONCommandConn failed: %v", err)
+	}
+	tables, err := conn.ListTables()
+	if err != nil {
+		t.Fatalf("ListTables failed: %v", err)
+	}
+	if len(tables) != 2 {
+		t.Errorf("ListTables: got %+v, want 2 tables", tables)
+	}
+
+	conn, err = NewJSONCommandConn("cat " + filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("NewJSONCommandConn failed: %v", err)
+	}
+	if _, err := conn.GetGen(); err == nil {
+		t.Errorf("GetGen: got nil, want error")
+	}
+}
@@ -0,0 +1,980 @@
+package nftcollector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/tommie/prometheus-nftables-exporter/udata"
+	"golang.org/x/sys/unix"
+)
+
+// ruleCounter extracts the counter and returns it, or nil.
+func ruleCounter(r *nftables.Rule) *expr.Counter {
+	for _, e := range r.Exprs {
+		if cnt, ok := e.(*expr.Counter); ok {
+			return cnt
+		}
+	}
+	return nil
+}
+
+// RuleComment extracts the comment and returns it, or the empty
+// string if there was no comment. The comment is either in the user
+// data, as written by nft, or in an xt_comment match, as written by
+// iptables-nft.
+func RuleComment(r *Rule) (string, error) {
+	as, err := udata.Unmarshal(r.UserData, udata.UnmarshalRuleAttr)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range as {
+		if c, ok := a.(udata.Comment); ok {
+			return string(c), nil
+		}
+	}
+	for _, o := range r.Others {
+		if o.Name == "match" && o.XT != nil && o.XT.Name == "comment" {
+			return xtCommentString(o.XT.Info), nil
+		}
+	}
+	return "", nil
+}
+
+// xtCommentString returns the comment of an xt_comment_info, which
+// is a NUL-padded string.
+func xtCommentString(info []byte) string {
+	if i := bytes.IndexByte(info, 0); i >= 0 {
+		info = info[:i]
+	}
+	return string(info)
+}
+
+// RuleExprString returns an nft-like string representation of the
+// rule expressions. Counter values are omitted, so the result doesn't
+// change while the rule is unchanged. Expressions that can't be
+// printed are shown as their type name in brackets.
+func RuleExprString(tf nftables.TableFamily, r *Rule) string {
+	p := exprPrinter{regs: map[uint32]exprReg{}}
+	switch tf {
+	case nftables.TableFamilyIPv4:
+		p.l3 = "ip"
+	case nftables.TableFamilyIPv6:
+		p.l3 = "ip6"
+	}
+
+	others := r.Others
+	for i, e := range r.Exprs {
+		for len(others) > 0 && others[0].Index <= i {
+			p.printOther(others[0])
+			others = others[1:]
+		}
+		p.print(e)
+	}
+	for _, o := range others {
+		p.printOther(o)
+	}
+
+	return strings.Join(p.stmts, " ")
+}
+
+// An exprPrinter keeps track of register contents while printing
+// rule expressions.
+type exprPrinter struct {
+	regs  map[uint32]exprReg
+	stmts []string
+
+	// l3 and l4 are the network and transport protocols, as known
+	// from previous matches.
+	l3, l4 string
+}
+
+// An exprReg describes what has been loaded into a register.
+type exprReg struct {
+	desc string
+	dt   nftables.SetDatatype
+	mask []byte // From a bitwise expression.
+	data []byte // From an immediate expression.
+}
+
+// print handles a single expression.
+func (p *exprPrinter) print(e expr.Any) {
+	switch e := e.(type) {
+	case *expr.Payload:
+		if e.OperationType != expr.PayloadLoad {
+			p.stmts = append(p.stmts, p.payloadDesc(e)+" set "+p.regString(e.SourceRegister, nftables.TypeInteger))
+			return
+		}
+		desc, dt := p.payloadDesc(e), p.payloadDatatype(e)
+		p.regs[e.DestRegister] = exprReg{desc: desc, dt: dt}
+
+	case *expr.Meta:
+		desc, dt := metaKeyDesc(e.Key)
+		if e.SourceRegister {
+			p.stmts = append(p.stmts, desc+" set "+p.regString(e.Register, dt))
+			return
+		}
+		p.regs[e.Register] = exprReg{desc: desc, dt: dt}
+
+	case *expr.Ct:
+		desc, dt := ctKeyDesc(e.Key)
+		if e.SourceRegister {
+			p.stmts = append(p.stmts, desc+" set "+p.regString(e.Register, dt))
+			return
+		}
+		p.regs[e.Register] = exprReg{desc: desc, dt: dt}
+
+	case *expr.Bitwise:
+		reg := p.regs[e.SourceRegister]
+		reg.mask = e.Mask
+		p.regs[e.DestRegister] = reg
+
+	case *expr.Immediate:
+		p.regs[e.Register] = exprReg{data: e.Data}
+
+	case *expr.Cmp:
+		p.printCmp(e)
+
+	case *expr.Range:
+		reg := p.regs[e.Register]
+		p.stmts = append(p.stmts, reg.desc+" "+cmpOpString(e.Op)+datatypeString(reg.dt, udata.ByteOrderInvalid, e.FromData)+"-"+datatypeString(reg.dt, udata.ByteOrderInvalid, e.ToData))
+
+	case *expr.Lookup:
+		reg := p.regs[e.SourceRegister]
+		switch {
+		case e.IsDestRegSet && e.DestRegister == unix.NFT_REG_VERDICT:
+			p.stmts = append(p.stmts, reg.desc+" vmap @"+e.SetName)
+		case e.IsDestRegSet:
+			p.regs[e.DestRegister] = exprReg{desc: reg.desc + " map @" + e.SetName, dt: nftables.TypeInteger}
+		case e.Invert:
+			p.stmts = append(p.stmts, reg.desc+" != @"+e.SetName)
+		default:
+			p.stmts = append(p.stmts, reg.desc+" @"+e.SetName)
+		}
+
+	case *expr.Counter:
+		p.stmts = append(p.stmts, "counter")
+
+	case *expr.Verdict:
+		p.stmts = append(p.stmts, verdictString(e))
+
+	case *expr.NAT:
+		p.stmts = append(p.stmts, p.natString(e))
+
+	case *expr.Log:
+		s := "log"
+		if e.Key == unix.NFTA_LOG_PREFIX {
+			s += " prefix " + strconv.Quote(string(bytes.TrimRight(e.Data, "\x00")))
+		}
+		p.stmts = append(p.stmts, s)
+
+	case *expr.Limit:
+		p.stmts = append(p.stmts, limitString(e))
+
+	default:
+		p.stmts = append(p.stmts, "["+strings.ToLower(strings.TrimPrefix(fmt.Sprintf("%T", e), "*expr."))+"]")
+	}
+}
+
+// printOther handles an expression the nftables package can't
+// decode. Matches and targets are printed like nft does.
+func (p *exprPrinter) printOther(o OtherExpr) {
+	switch {
+	case o.Name == "match" && o.XT != nil && o.XT.Name == "comment":
+		p.stmts = append(p.stmts, "comment "+strconv.Quote(xtCommentString(o.XT.Info)))
+	case o.XT != nil:
+		p.stmts = append(p.stmts, "xt "+o.Name+" "+strconv.Quote(o.XT.Name))
+	default:
+		p.stmts = append(p.stmts, "["+o.Name+"]")
+	}
+}
+
+// printCmp prints a comparison, and remembers protocol matches.
+func (p *exprPrinter) printCmp(e *expr.Cmp) {
+	reg := p.regs[e.Register]
+
+	if e.Op == expr.CmpOpEq {
+		switch reg.desc {
+		case "meta nfproto":
+			switch datatypeString(reg.dt, udata.ByteOrderInvalid, e.Data) {
+			case "ipv4":
+				p.l3 = "ip"
+			case "ipv6":
+				p.l3 = "ip6"
+			}
+		case "meta l4proto", "ip protocol", "ip6 nexthdr":
+			p.l4 = datatypeString(reg.dt, udata.ByteOrderInvalid, e.Data)
+		}
+	}
+
+	if reg.mask != nil {
+		if e.Op == expr.CmpOpNeq && isZero(e.Data) {
+			// E.g. "ct state established,related".
+			p.stmts = append(p.stmts, reg.desc+" "+datatypeString(reg.dt, udata.ByteOrderInvalid, reg.mask))
+			return
+		}
+		p.stmts = append(p.stmts, reg.desc+" & "+datatypeString(reg.dt, udata.ByteOrderInvalid, reg.mask)+" "+cmpOpString(e.Op)+datatypeString(reg.dt, udata.ByteOrderInvalid, e.Data))
+		return
+	}
+
+	p.stmts = append(p.stmts, reg.desc+" "+cmpOpString(e.Op)+datatypeString(reg.dt, udata.ByteOrderInvalid, e.Data))
+}
+
+// regString returns the immediate value of a register, or its
+// description if it was loaded from the packet.
+func (p *exprPrinter) regString(r uint32, dt nftables.SetDatatype) string {
+	reg := p.regs[r]
+	if reg.data != nil {
+		return datatypeString(dt, udata.ByteOrderInvalid, reg.data)
+	}
+	return reg.desc
+}
+
+// natString returns a string representation of a NAT statement.
+func (p *exprPrinter) natString(e *expr.NAT) string {
+	s := "snat"
+	if e.Type == expr.NATTypeDestNAT {
+		s = "dnat"
+	}
+
+	addrType := nftables.TypeIPAddr
+	if e.Family == unix.NFPROTO_IPV6 {
+		addrType = nftables.TypeIP6Addr
+	}
+
+	var to string
+	if e.RegAddrMin != 0 {
+		to = p.regString(e.RegAddrMin, addrType)
+		if e.RegAddrMax != 0 && e.RegAddrMax != e.RegAddrMin {
+			to += "-" + p.regString(e.RegAddrMax, addrType)
+		}
+		if addrType.Name == nftables.TypeIP6Addr.Name && e.RegProtoMin != 0 {
+			to = "[" + to + "]"
+		}
+	}
+	if e.RegProtoMin != 0 {
+		to += ":" + p.regString(e.RegProtoMin, nftables.TypeInetService)
+		if e.RegProtoMax != 0 && e.RegProtoMax != e.RegProtoMin {
+			to += "-" + p.regString(e.RegProtoMax, nftables.TypeInetService)
+		}
+	}
+	if to != "" {
+		s += " to " + to
+	}
+
+	if e.Random {
+		s += " random"
+	}
+	if e.FullyRandom {
+		s += " fully-random"
+	}
+	if e.Persistent {
+		s += " persistent"
+	}
+
+	return s
+}
+
+// payloadDesc returns the nft name of a payload field, or a raw
+// payload expression.
+func (p *exprPrinter) payloadDesc(e *expr.Payload) string {
+	switch e.Base {
+	case expr.PayloadBaseLLHeader:
+		switch {
+		case e.Offset == 0 && e.Len == 6:
+			return "ether daddr"
+		case e.Offset == 6 && e.Len == 6:
+			return "ether saddr"
+		case e.Offset == 12 && e.Len == 2:
+			return "ether type"
+		}
+		return fmt.Sprintf("@ll,%d,%d", e.Offset*8, e.Len*8)
+
+	case expr.PayloadBaseNetworkHeader:
+		switch {
+		case p.l3 == "ip" && e.Offset == 9 && e.Len == 1:
+			return "ip protocol"
+		case p.l3 == "ip" && e.Offset == 12 && e.Len == 4:
+			return "ip saddr"
+		case p.l3 == "ip" && e.Offset == 16 && e.Len == 4:
+			return "ip daddr"
+		case p.l3 == "ip6" && e.Offset == 6 && e.Len == 1:
+			return "ip6 nexthdr"
+		case p.l3 == "ip6" && e.Offset == 8 && e.Len == 16:
+			return "ip6 saddr"
+		case p.l3 == "ip6" && e.Offset == 24 && e.Len == 16:
+			return "ip6 daddr"
+		}
+		return fmt.Sprintf("@nh,%d,%d", e.Offset*8, e.Len*8)
+
+	case expr.PayloadBaseTransportHeader:
+		l4 := p.l4
+		switch l4 {
+		case "tcp", "udp", "udplite", "sctp", "dccp":
+		default:
+			l4 = "th"
+		}
+		switch {
+		case e.Offset == 0 && e.Len == 2:
+			return l4 + " sport"
+		case e.Offset == 2 && e.Len == 2:
+			return l4 + " dport"
+		}
+		return fmt.Sprintf("@th,%d,%d", e.Offset*8, e.Len*8)
+	}
+
+	return fmt.Sprintf("@unknown(%d),%d,%d", e.Base, e.Offset*8, e.Len*8)
+}
+
+// payloadDatatype returns the datatype of a payload field.
+func (p *exprPrinter) payloadDatatype(e *expr.Payload) nftables.SetDatatype {
+	switch p.payloadDesc(e) {
+	case "ether daddr", "ether saddr":
+		return nftables.TypeEtherAddr
+	case "ether type":
+		return nftables.TypeEtherType
+	case "ip protocol", "ip6 nexthdr":
+		return nftables.TypeInetProto
+	case "ip saddr", "ip daddr":
+		return nftables.TypeIPAddr
+	case "ip6 saddr", "ip6 daddr":
+		return nftables.TypeIP6Addr
+	}
+	if e.Base == expr.PayloadBaseTransportHeader && e.Len == 2 && (e.Offset == 0 || e.Offset == 2) {
+		return nftables.TypeInetService
+	}
+	return nftables.TypeInteger
+}
+
+// metaKeyDesc returns the nft name and datatype of a meta key.
+func metaKeyDesc(k expr.MetaKey) (string, nftables.SetDatatype) {
+	switch k {
+	case expr.MetaKeyLEN:
+		return "meta length", nftables.TypeInteger
+	case expr.MetaKeyPROTOCOL:
+		return "meta protocol", nftables.TypeEtherType
+	case expr.MetaKeyPRIORITY:
+		return "meta priority", nftables.TypeClassID
+	case expr.MetaKeyMARK:
+		return "meta mark", nftables.TypeMark
+	case expr.MetaKeyIIF:
+		return "iif", nftables.TypeIFIndex
+	case expr.MetaKeyOIF:
+		return "oif", nftables.TypeIFIndex
+	case expr.MetaKeyIIFNAME:
+		return "iifname", nftables.TypeIFName
+	case expr.MetaKeyOIFNAME:
+		return "oifname", nftables.TypeIFName
+	case expr.MetaKeyIIFTYPE:
+		return "iiftype", nftables.TypeARPHRD
+	case expr.MetaKeyOIFTYPE:
+		return "oiftype", nftables.TypeARPHRD
+	case expr.MetaKeySKUID:
+		return "meta skuid", nftables.TypeUID
+	case expr.MetaKeySKGID:
+		return "meta skgid", nftables.TypeGID
+	case expr.MetaKeyNFTRACE:
+		return "meta nftrace", nftables.TypeInteger
+	case expr.MetaKeyNFPROTO:
+		return "meta nfproto", nftables.TypeNFProto
+	case expr.MetaKeyL4PROTO:
+		return "meta l4proto", nftables.TypeInetProto
+	case expr.MetaKeyPKTTYPE:
+		return "meta pkttype", nftables.TypePktType
+	case expr.MetaKeyCPU:
+		return "meta cpu", nftables.TypeInteger
+	case expr.MetaKeyCGROUP:
+		return "meta cgroup", nftables.TypeInteger
+	default:
+		return fmt.Sprintf("meta unknown(%d)", k), nftables.TypeInteger
+	}
+}
+
+// ctKeyDesc returns the nft name and datatype of a conntrack key.
+func ctKeyDesc(k expr.CtKey) (string, nftables.SetDatatype) {
+	switch k {
+	case expr.CtKeySTATE:
+		return "ct state", nftables.TypeCTState
+	case expr.CtKeyDIRECTION:
+		return "ct direction", nftables.TypeCTDir
+	case expr.CtKeySTATUS:
+		return "ct status", nftables.TypeCTStatus
+	case expr.CtKeyMARK:
+		return "ct mark", nftables.TypeMark
+	case expr.CtKeyEXPIRATION:
+		return "ct expiration", nftables.TypeTime
+	case expr.CtKeyHELPER:
+		return "ct helper", nftables.TypeString
+	case expr.CtKeySRC:
+		return "ct original saddr", nftables.TypeInteger
+	case expr.CtKeyDST:
+		return "ct original daddr", nftables.TypeInteger
+	case expr.CtKeyPROTOCOL:
+		return "ct original protocol", nftables.TypeInetProto
+	case expr.CtKeyPROTOSRC:
+		return "ct original proto-src", nftables.TypeInetService
+	case expr.CtKeyPROTODST:
+		return "ct original proto-dst", nftables.TypeInetService
+	case expr.CtKeyZONE:
+		return "ct zone", nftables.TypeInteger
+	default:
+		return fmt.Sprintf("ct unknown(%d)", k), nftables.TypeInteger
+	}
+}
+
+// cmpOpString returns the comparison operator, with a trailing
+// space. Equality is implicit in nft.
+func cmpOpString(op expr.CmpOp) string {
+	switch op {
+	case expr.CmpOpEq:
+		return ""
+	case expr.CmpOpNeq:
+		return "!= "
+	case expr.CmpOpLt:
+		return "< "
+	case expr.CmpOpLte:
+		return "<= "
+	case expr.CmpOpGt:
+		return "> "
+	case expr.CmpOpGte:
+		return ">= "
+	default:
+		return fmt.Sprintf("unknown(%d) ", op)
+	}
+}
+
+// limitString returns a string representation of a limit statement.
+func limitString(e *expr.Limit) string {
+	s := "limit rate "
+	if e.Over {
+		s += "over "
+	}
+	s += strconv.FormatUint(e.Rate, 10)
+	if e.Type == expr.LimitTypePktBytes {
+		s += " bytes"
+	}
+
+	switch e.Unit {
+	case expr.LimitTimeSecond:
+		s += "/second"
+	case expr.LimitTimeMinute:
+		s += "/minute"
+	case expr.LimitTimeHour:
+		s += "/hour"
+	case expr.LimitTimeDay:
+		s += "/day"
+	case expr.LimitTimeWeek:
+		s += "/week"
+	default:
+		s += fmt.Sprintf("/%ds", e.Unit)
+	}
+
+	if e.Burst != 0 {
+		s += " burst " + strconv.FormatUint(uint64(e.Burst), 10)
+		if e.Type == expr.LimitTypePktBytes {
+			s += " bytes"
+		} else {
+			s += " packets"
+		}
+	}
+
+	return s
+}
+
+// isZero returns true if all bytes are zero.
+func isZero(bs []byte) bool {
+	for _, b := range bs {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SetElementKeyString returns a string representation of the key of
+// a set element, like nft prints it. Interval set elements should
+// first be paired up with setIntervalElements.
+func SetElementKeyString(st *Set, el SetElement) string {
+	bo := setKeyByteOrder(st)
+	if el.KeyEnd != nil {
+		return rangeString(st.KeyType, bo, el.Key, el.KeyEnd)
+	}
+	return datatypeString(st.KeyType, bo, el.Key)
+}
+
+// SetElementValueString returns a string representation of the value
+// of a map element, like nft prints it.
+func SetElementValueString(st *Set, el SetElement) string {
+	if el.VerdictData != nil {
+		return verdictString(el.VerdictData)
+	}
+	return datatypeString(st.DataType, setDataByteOrder(st), el.Val)
+}
+
+// setKeyByteOrder returns the byte order of set keys. Interval keys
+// are always big endian.
+func setKeyByteOrder(st *Set) udata.ByteOrder {
+	if st.Interval {
+		return udata.ByteOrderBigEndian
+	}
+	as, _ := udata.Unmarshal(st.UserData, udata.UnmarshalSetAttr)
+	for _, a := range as {
+		if bo, ok := a.(udata.KeyByteOrder); ok {
+			return udata.ByteOrder(bo)
+		}
+	}
+	return udata.ByteOrderInvalid
+}
+
+// setDataByteOrder returns the byte order of map values.
+func setDataByteOrder(st *Set) udata.ByteOrder {
+	as, _ := udata.Unmarshal(st.UserData, udata.UnmarshalSetAttr)
+	for _, a := range as {
+		if bo, ok := a.(udata.DataByteOrder); ok {
+			return udata.ByteOrder(bo)
+		}
+	}
+	return udata.ByteOrderInvalid
+}
+
+// setIntervalElements pairs up the start and end elements of an
+// interval set, returning one element per range, with an inclusive
+// KeyEnd. The start element is kept, since it holds the counter.
+// Elements that already have a KeyEnd are returned as-is.
+func setIntervalElements(els []SetElement) []SetElement {
+	els = append([]SetElement(nil), els...)
+	sort.SliceStable(els, func(i, j int) bool {
+		if c := bytes.Compare(els[i].Key, els[j].Key); c != 0 {
+			return c < 0
+		}
+		// An end at the same key as a start belongs to the previous range.
+		return els[i].IntervalEnd && !els[j].IntervalEnd
+	})
+
+	var ret []SetElement
+	var start *SetElement
+	for i := range els {
+		el := els[i]
+		switch {
+		case el.KeyEnd != nil:
+			ret = append(ret, el)
+
+		case el.IntervalEnd:
+			if start != nil {
+				start.KeyEnd = decrementBytes(el.Key)
+				ret = append(ret, *start)
+				start = nil
+			}
+
+		default:
+			if start != nil {
+				start.KeyEnd = decrementBytes(el.Key)
+				ret = append(ret, *start)
+			}
+			start = &el
+		}
+	}
+	if start != nil {
+		// The last range extends to the maximum value.
+		start.KeyEnd = bytes.Repeat([]byte{0xFF}, len(start.Key))
+		ret = append(ret, *start)
+	}
+
+	return ret
+}
+
+// decrementBytes returns a big endian number minus one.
+func decrementBytes(bs []byte) []byte {
+	bs = append([]byte(nil), bs...)
+	for i := len(bs) - 1; i >= 0; i-- {
+		bs[i]--
+		if bs[i] != 0xFF {
+			break
+		}
+	}
+	return bs
+}
+
+// rangeString returns a string representation of an inclusive
+// range. Address ranges that form a prefix are printed in CIDR
+// notation.
+func rangeString(dt nftables.SetDatatype, bo udata.ByteOrder, start, end []byte) string {
+	if dts := concatDatatypes(dt); dts != nil {
+		ss := splitConcatData(dts, start)
+		es := splitConcatData(dts, end)
+		if ss == nil || es == nil {
+			return fmt.Sprintf("0x%x-0x%x", start, end)
+		}
+		strs := make([]string, len(dts))
+		for i, dt := range dts {
+			strs[i] = rangeString(dt, udata.ByteOrderInvalid, ss[i], es[i])
+		}
+		return strings.Join(strs, " . ")
+	}
+
+	if bytes.Equal(start, end) {
+		return datatypeString(dt, bo, start)
+	}
+	switch dt.Name {
+	case nftables.TypeIPAddr.Name, nftables.TypeIP6Addr.Name:
+		if n, ok := prefixLength(start, end); ok {
+			return datatypeString(dt, bo, start) + "/" + strconv.Itoa(n)
+		}
+	}
+	return datatypeString(dt, bo, start) + "-" + datatypeString(dt, bo, end)
+}
+
+// prefixLength returns the number of leading bits shared by start and
+// end, if the range is exactly a prefix.
+func prefixLength(start, end []byte) (int, bool) {
+	if len(start) != len(end) {
+		return 0, false
+	}
+	n := 0
+	for n < len(start)*8 && bitAt(start, n) == bitAt(end, n) {
+		n++
+	}
+	for i := n; i < len(start)*8; i++ {
+		if bitAt(start, i) != 0 || bitAt(end, i) != 1 {
+			return 0, false
+		}
+	}
+	return n, true
+}
+
+// bitAt returns the big endian bit at position i.
+func bitAt(bs []byte, i int) byte {
+	return bs[i/8] >> (7 - uint(i%8)) & 1
+}
+
+// splitConcatData splits concatenated data into its parts. Each part
+// is padded to a multiple of four bytes. Returns nil if the data is
+// too short.
+func splitConcatData(dts []nftables.SetDatatype, bs []byte) [][]byte {
+	ret := make([][]byte, len(dts))
+	for i, dt := range dts {
+		n := int(dt.Bytes)
+		if len(bs) < n || n == 0 {
+			return nil
+		}
+		ret[i] = bs[:n]
+		if n%4 != 0 {
+			n += 4 - n%4
+		}
+		if len(bs) < n {
+			n = len(bs)
+		}
+		bs = bs[n:]
+	}
+	return ret
+}
+
+// datatypeString returns a string representation of a value of the
+// given datatype, like nft prints it. The byte order is only used for
+// integer types, and defaults to the byte order nft uses for the
+// datatype. Strings are not quoted.
+func datatypeString(dt nftables.SetDatatype, bo udata.ByteOrder, bs []byte) string {
+	if dts := concatDatatypes(dt); dts != nil {
+		parts := splitConcatData(dts, bs)
+		if parts == nil {
+			return fmt.Sprintf("0x%x", bs)
+		}
+		strs := make([]string, len(dts))
+		for i, dt := range dts {
+			strs[i] = datatypeString(dt, udata.ByteOrderInvalid, parts[i])
+		}
+		return strings.Join(strs, " . ")
+	}
+
+	if bo == udata.ByteOrderInvalid {
+		bo = datatypeByteOrder(dt)
+	}
+
+	switch dt.Name {
+	case nftables.TypeIPAddr.Name:
+		if len(bs) == net.IPv4len {
+			return net.IP(bs).String()
+		}
+	case nftables.TypeIP6Addr.Name:
+		if len(bs) == net.IPv6len {
+			return net.IP(bs).String()
+		}
+	case nftables.TypeEtherAddr.Name, nftables.TypeLLAddr.Name:
+		return net.HardwareAddr(bs).String()
+	case nftables.TypeIFName.Name, nftables.TypeString.Name:
+		if i := bytes.IndexByte(bs, 0); i >= 0 {
+			bs = bs[:i]
+		}
+		return string(bs)
+	case nftables.TypeMark.Name, nftables.TypeCTStatus.Name:
+		if v, ok := integerValue(bo, bs); ok {
+			return fmt.Sprintf("0x%08x", v)
+		}
+	case nftables.TypeCTState.Name:
+		if v, ok := integerValue(bo, bs); ok {
+			return ctStateMaskString(v)
+		}
+	case nftables.TypeInetProto.Name:
+		if v, ok := integerValue(bo, bs); ok {
+			return inetProtoString(v)
+		}
+	case nftables.TypeEtherType.Name:
+		if v, ok := integerValue(bo, bs); ok {
+			return etherTypeString(v)
+		}
+	case nftables.TypeNFProto.Name:
+		if v, ok := integerValue(bo, bs); ok {
+			return nfProtoString(v)
+		}
+	}
+
+	if v, ok := integerValue(bo, bs); ok {
+		return strconv.FormatUint(v, 10)
+	}
+	return fmt.Sprintf("0x%x", bs)
+}
+
+// datatypeByteOrder returns the byte order nft uses for integers of
+// the given datatype.
+func datatypeByteOrder(dt nftables.SetDatatype) udata.ByteOrder {
+	switch dt.Name {
+	case nftables.TypeMark.Name, nftables.TypeIFIndex.Name, nftables.TypeUID.Name, nftables.TypeGID.Name,
+		nftables.TypeCTState.Name, nftables.TypeCTStatus.Name, nftables.TypeCTEventBit.Name,
+		nftables.TypeRealm.Name, nftables.TypeClassID.Name, nftables.TypeDevGroup.Name,
+		nftables.TypeFIBAddr.Name, nftables.TypeCGroupV2.Name, nftables.TypeTime.Name,
+		nftables.TypeTimeHour.Name, nftables.TypeTimeDay.Name:
+		return udata.ByteOrderHostEndian
+	default:
+		return udata.ByteOrderBigEndian
+	}
+}
+
+// integerValue returns the data as an integer, if it's at most eight
+// bytes long.
+func integerValue(bo udata.ByteOrder, bs []byte) (uint64, bool) {
+	if len(bs) == 0 || len(bs) > 8 {
+		return 0, false
+	}
+
+	var v uint64
+	if bo == udata.ByteOrderHostEndian && nlenc.NativeEndian() == binary.LittleEndian {
+		for i := len(bs) - 1; i >= 0; i-- {
+			v = v<<8 | uint64(bs[i])
+		}
+	} else {
+		for _, b := range bs {
+			v = v<<8 | uint64(b)
+		}
+	}
+	return v, true
+}
+
+// ctStateMaskString returns a comma-separated list of conntrack
+// states.
+func ctStateMaskString(v uint64) string {
+	var ss []string
+	for _, st := range []struct {
+		bit  uint32
+		name string
+	}{
+		{expr.CtStateBitINVALID, "invalid"},
+		{expr.CtStateBitESTABLISHED, "established"},
+		{expr.CtStateBitRELATED, "related"},
+		{expr.CtStateBitNEW, "new"},
+		{expr.CtStateBitUNTRACKED, "untracked"},
+	} {
+		if v&uint64(st.bit) != 0 {
+			ss = append(ss, st.name)
+			v &^= uint64(st.bit)
+		}
+	}
+	if v != 0 || len(ss) == 0 {
+		ss = append(ss, fmt.Sprintf("0x%08x", v))
+	}
+	return strings.Join(ss, ",")
+}
+
+// inetProtoString returns the name of an IP protocol number.
+func inetProtoString(v uint64) string {
+	switch v {
+	case unix.IPPROTO_ICMP:
+		return "icmp"
+	case unix.IPPROTO_IGMP:
+		return "igmp"
+	case unix.IPPROTO_TCP:
+		return "tcp"
+	case unix.IPPROTO_UDP:
+		return "udp"
+	case unix.IPPROTO_GRE:
+		return "gre"
+	case unix.IPPROTO_ESP:
+		return "esp"
+	case unix.IPPROTO_AH:
+		return "ah"
+	case unix.IPPROTO_ICMPV6:
+		return "ipv6-icmp"
+	case unix.IPPROTO_SCTP:
+		return "sctp"
+	case unix.IPPROTO_UDPLITE:
+		return "udplite"
+	default:
+		return strconv.FormatUint(v, 10)
+	}
+}
+
+// etherTypeString returns the name of an Ethernet frame type.
+func etherTypeString(v uint64) string {
+	switch v {
+	case unix.ETH_P_IP:
+		return "ip"
+	case unix.ETH_P_IPV6:
+		return "ip6"
+	case unix.ETH_P_ARP:
+		return "arp"
+	case unix.ETH_P_8021Q:
+		return "vlan"
+	case unix.ETH_P_8021AD:
+		return "8021ad"
+	default:
+		return fmt.Sprintf("0x%04x", v)
+	}
+}
+
+// nfProtoString returns the name of a Netfilter protocol family.
+func nfProtoString(v uint64) string {
+	switch v {
+	case unix.NFPROTO_IPV4:
+		return "ipv4"
+	case unix.NFPROTO_IPV6:
+		return "ipv6"
+	default:
+		return strconv.FormatUint(v, 10)
+	}
+}
+
+// verdictString returns a string representation of a verdict.
+func verdictString(v *expr.Verdict) string {
+	switch v.Kind {
+	case expr.VerdictAccept:
+		return "accept"
+	case expr.VerdictDrop:
+		return "drop"
+	case expr.VerdictContinue:
+		return "continue"
+	case expr.VerdictBreak:
+		return "break"
+	case expr.VerdictReturn:
+		return "return"
+	case expr.VerdictJump:
+		return "jump " + v.Chain
+	case expr.VerdictGoto:
+		return "goto " + v.Chain
+	case expr.VerdictQueue:
+		return "queue"
+	case expr.VerdictStolen:
+		return "stolen"
+	case expr.VerdictRepeat:
+		return "repeat"
+	case expr.VerdictStop:
+		return "stop"
+	default:
+		return fmt.Sprintf("unknown(%d)", v.Kind)
+	}
+}
+
+// ChainPolicyString returns a string representation of a
+// ChainPolicy. If the input is nil, this defaults to "accept", like
+// Netfilter does.
+func ChainPolicyString(p *nftables.ChainPolicy) string {
+	if p == nil {
+		return "accept"
+	}
+	switch *p {
+	case nftables.ChainPolicyDrop:
+		return "drop"
+	case nftables.ChainPolicyAccept:
+		return "accept"
+	default:
+		return fmt.Sprintf("unknown(%d)", *p)
+	}
+}
+
+// TableFamilyString returns a string representation of a TableFamily.
+func TableFamilyString(tf nftables.TableFamily) string {
+	switch tf {
+	case nftables.TableFamilyINet:
+		return "inet"
+	case nftables.TableFamilyIPv4:
+		return "ip"
+	case nftables.TableFamilyIPv6:
+		return "ip6"
+	case nftables.TableFamilyARP:
+		return "arp"
+	case nftables.TableFamilyNetdev:
+		return "netdev"
+	case nftables.TableFamilyBridge:
+		return "bridge"
+	default:
+		return fmt.Sprintf("unknown(%d)", tf)
+	}
+}
+
+// TableFlagMaskString returns a comma-separated list of table flags.
+func TableFlagMaskString(v uint32) string {
+	var fs []string
+	for m := uint32(1); m <= maxTableFlag; m <<= 1 {
+		if v&m != 0 {
+			fs = append(fs, tableFlagString(m))
+		}
+	}
+	return strings.Join(fs, ",")
+}
+
+const maxTableFlag = unix.NFT_TABLE_F_DORMANT
+
+// tableFlagString returns a string representation of a table flag.
+func tableFlagString(v uint32) string {
+	switch v {
+	case 0:
+		return "none"
+	case unix.NFT_TABLE_F_DORMANT:
+		return "dormant"
+	default:
+		return fmt.Sprintf("unknown(%d)", bits.TrailingZeros32(v))
+	}
+}
+
+// hookNames are the hook names of each family, indexed by hook
+// number, as nft names them.
+var hookNames = map[nftables.TableFamily][]string{
+	nftables.TableFamilyINet:   {"prerouting", "input", "forward", "output", "postrouting", "ingress"},
+	nftables.TableFamilyIPv4:   {"prerouting", "input", "forward", "output", "postrouting"},
+	nftables.TableFamilyIPv6:   {"prerouting", "input", "forward", "output", "postrouting"},
+	nftables.TableFamilyBridge: {"prerouting", "input", "forward", "output", "postrouting"},
+	nftables.TableFamilyARP:    {"input", "output", "forward"},
+	nftables.TableFamilyNetdev: {"ingress", "egress"},
+}
+
+// unknownHook is the hook of a base chain whose hook name wasn't
+// recognized.
+const unknownHook = ^nftables.ChainHook(0)
+
+// HookString returns a string representation of a ChainHook. The
+// interpretation of the hook value depends on the family.
+func HookString(tf nftables.TableFamily, v nftables.ChainHook) string {
+	if v == unknownHook {
+		return "unknown"
+	}
+	if names := hookNames[tf]; int(v) < len(names) {
+		return names[v]
+	}
+	return fmt.Sprintf("unknown(%d)", v)
+}
@@ -0,0 +1,373 @@
+package nftcollector
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/tommie/prometheus-nftables-exporter/udata"
+	"golang.org/x/sys/unix"
+)
+
+func TestRuleCounter(t *testing.T) {
+	t.Run("found", func(t *testing.T) {
+		want := &expr.Counter{}
+
+		got := ruleCounter(&nftables.Rule{
+			Exprs: []expr.Any{nil, want},
+		})
+
+		if got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		got := ruleCounter(&nftables.Rule{
+			Exprs: []expr.Any{},
+		})
+
+		if got != nil {
+			t.Errorf("got %v, want %v", got, nil)
+		}
+	})
+}
+
+func TestRuleComment(t *testing.T) {
+	t.Run("found", func(t *testing.T) {
+		want := "test"
+
+		got, err := RuleComment(&Rule{Rule: &nftables.Rule{
+			UserData: makeRuleComment(want),
+		}})
+
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("xtComment", func(t *testing.T) {
+		want := "test"
+
+		got, err := RuleComment(&Rule{Rule: &nftables.Rule{}, Others: []OtherExpr{
+			{Name: "match", XT: &XTInfo{Name: "conntrack"}},
+			{Name: "match", XT: &XTInfo{Name: "comment", Info: append([]byte(want), make([]byte, 252)...)}},
+		}})
+
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		got, err := RuleComment(&Rule{Rule: &nftables.Rule{}})
+
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+		if got != "" {
+			t.Errorf("got %q, want %q", got, "")
+		}
+	})
+}
+
+func makeRuleComment(s string) []byte {
+	return append([]byte{byte(udata.RuleComment), byte(len(s) + 1)}, append([]byte(s), '\x00')...)
+}
+
+func TestRuleExprString(t *testing.T) {
+	tsts := []struct {
+		name   string
+		tf     nftables.TableFamily
+		exprs  []expr.Any
+		others []OtherExpr
+		want   string
+	}{
+		{"empty", nftables.TableFamilyINet, nil, nil, ""},
+		{"tcpDport", nftables.TableFamilyINet, []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0, 22}},
+			&expr.Counter{Packets: 42, Bytes: 4711},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		}, nil, "meta l4proto tcp tcp dport 22 counter accept"},
+		{"ip6Saddr", nftables.TableFamilyINet, []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.NFPROTO_IPV6}},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 8, Len: 16},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: net.ParseIP("2001:db8::1")},
+			&expr.Verdict{Kind: expr.VerdictDrop},
+		}, nil, "meta nfproto ipv6 ip6 saddr != 2001:db8::1 drop"},
+		{"iifname", nftables.TableFamilyIPv4, []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: append([]byte("eth0"), make([]byte, 12)...)},
+			&expr.Verdict{Kind: expr.VerdictJump, Chain: "chain1"},
+		}, nil, "iifname eth0 jump chain1"},
+		{"ctState", nftables.TableFamilyIPv4, []expr.Any{
+			&expr.Ct{Key: expr.CtKeySTATE, Register: 1},
+			&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: nlenc.Uint32Bytes(expr.CtStateBitESTABLISHED | expr.CtStateBitRELATED), Xor: make([]byte, 4)},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: make([]byte, 4)},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		}, nil, "ct state established,related accept"},
+		{"lookup", nftables.TableFamilyIPv4, []expr.Any{
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+			&expr.Lookup{SourceRegister: 1, SetName: "set1", Invert: true},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 16, Len: 4},
+			&expr.Lookup{SourceRegister: 1, SetName: "map1", DestRegister: unix.NFT_REG_VERDICT, IsDestRegSet: true},
+		}, nil, "ip saddr != @set1 ip daddr vmap @map1"},
+		{"range", nftables.TableFamilyIPv4, []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_UDP}},
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 2},
+			&expr.Range{Op: expr.CmpOpEq, Register: 1, FromData: []byte{4, 0}, ToData: []byte{8, 0}},
+		}, nil, "meta l4proto udp udp sport 1024-2048"},
+		{"dnat", nftables.TableFamilyIPv4, []expr.Any{
+			&expr.Immediate{Register: 1, Data: []byte{10, 0, 0, 1}},
+			&expr.Immediate{Register: 2, Data: []byte{0, 80}},
+			&expr.NAT{Type: expr.NATTypeDestNAT, Family: unix.NFPROTO_IPV4, RegAddrMin: 1, RegProtoMin: 2},
+		}, nil, "dnat to 10.0.0.1:80"},
+		{"masquerade", nftables.TableFamilyIPv4, []expr.Any{
+			&expr.Masq{},
+		}, nil, "[masq]"},
+		{"logLimit", nftables.TableFamilyIPv4, []expr.Any{
+			&expr.Limit{Type: expr.LimitTypePkts, Rate: 10, Unit: expr.LimitTimeMinute, Burst: 5},
+			&expr.Log{Key: unix.NFTA_LOG_PREFIX, Data: []byte("dropped: \x00")},
+		}, nil, `limit rate 10/minute burst 5 packets log prefix "dropped: "`},
+		{"metaSet", nftables.TableFamilyIPv4, []expr.Any{
+			&expr.Immediate{Register: 1, Data: nlenc.Uint32Bytes(42)},
+			&expr.Meta{Key: expr.MetaKeyMARK, Register: 1, SourceRegister: true},
+		}, nil, "meta mark set 0x0000002a"},
+		{"xt", nftables.TableFamilyIPv4, []expr.Any{
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		}, []OtherExpr{
+			{Index: 0, Name: "match", XT: &XTInfo{Name: "comment", Info: append([]byte("test"), make([]byte, 252)...)}},
+			{Index: 0, Name: "match", XT: &XTInfo{Name: "conntrack"}},
+			{Index: 2, Name: "target", XT: &XTInfo{Name: "MASQUERADE"}},
+			{Index: 2, Name: "exthdr"},
+		}, `comment "test" xt match "conntrack" counter accept xt target "MASQUERADE" [exthdr]`},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			got := RuleExprString(tst.tf, &Rule{Rule: &nftables.Rule{Exprs: tst.exprs}, Others: tst.others})
+			if got != tst.want {
+				t.Errorf("got %q, want %q", got, tst.want)
+			}
+		})
+	}
+}
+
+func TestSetElementKeyString(t *testing.T) {
+	ipPort := nftables.MustConcatSetType(nftables.TypeIPAddr, nftables.TypeInetService)
+
+	tsts := []struct {
+		name string
+		st   nftables.Set
+		ud   []byte
+		el   SetElement
+		want string
+	}{
+		{"ipv4", nftables.Set{KeyType: nftables.TypeIPAddr}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 1}}}, "10.0.0.1"},
+		{"ipv6", nftables.Set{KeyType: nftables.TypeIP6Addr}, nil, SetElement{SetElement: nftables.SetElement{Key: net.ParseIP("2001:db8::1")}}, "2001:db8::1"},
+		{"ether", nftables.Set{KeyType: nftables.TypeEtherAddr}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55}}}, "00:11:22:33:44:55"},
+		{"service", nftables.Set{KeyType: nftables.TypeInetService}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{0, 22}}}, "22"},
+		{"proto", nftables.Set{KeyType: nftables.TypeInetProto}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{6}}}, "tcp"},
+		{"ifname", nftables.Set{KeyType: nftables.TypeIFName}, nil, SetElement{SetElement: nftables.SetElement{Key: append([]byte("eth0"), make([]byte, 12)...)}}, "eth0"},
+		{"mark", nftables.Set{KeyType: nftables.TypeMark}, nil, SetElement{SetElement: nftables.SetElement{Key: nlenc.Uint32Bytes(42)}}, "0x0000002a"},
+		{"integerBigEndian", nftables.Set{KeyType: nftables.TypeInteger}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{0, 0, 1, 0}}}, "256"},
+		{"integerHostEndian", nftables.Set{KeyType: nftables.TypeInteger}, makeSetByteOrder(udata.SetKeyByteOrder, udata.ByteOrderHostEndian), SetElement{SetElement: nftables.SetElement{Key: nlenc.Uint32Bytes(256)}}, "256"},
+		{"concat", nftables.Set{KeyType: ipPort}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 1, 0, 22, 0, 0}}}, "10.0.0.1 . 22"},
+		{"prefix", nftables.Set{KeyType: nftables.TypeIPAddr, Interval: true}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 0}}, KeyEnd: []byte{10, 0, 255, 255}}, "10.0.0.0/16"},
+		{"range", nftables.Set{KeyType: nftables.TypeIPAddr, Interval: true}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 1}}, KeyEnd: []byte{10, 0, 0, 5}}, "10.0.0.1-10.0.0.5"},
+		{"serviceRange", nftables.Set{KeyType: nftables.TypeInetService, Interval: true}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{0, 0}}, KeyEnd: []byte{0, 255}}, "0-255"},
+		{"concatRange", nftables.Set{KeyType: ipPort, Interval: true}, nil, SetElement{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 0, 0, 22, 0, 0}}, KeyEnd: []byte{10, 0, 0, 255, 0, 22, 0, 0}}, "10.0.0.0/24 . 22"},
+		{"unknown", nftables.Set{KeyType: nftables.TypeCTLabel}, nil, SetElement{SetElement: nftables.SetElement{Key: make([]byte, 16)}}, "0x00000000000000000000000000000000"},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			st := tst.st
+			got := SetElementKeyString(&Set{Set: &st, UserData: tst.ud}, tst.el)
+			if got != tst.want {
+				t.Errorf("got %q, want %q", got, tst.want)
+			}
+		})
+	}
+}
+
+func TestSetElementValueString(t *testing.T) {
+	tsts := []struct {
+		name string
+		st   nftables.Set
+		el   SetElement
+		want string
+	}{
+		{"ipv4", nftables.Set{DataType: nftables.TypeIPAddr}, SetElement{SetElement: nftables.SetElement{Val: []byte{10, 0, 0, 1}}}, "10.0.0.1"},
+		{"accept", nftables.Set{DataType: nftables.TypeVerdict}, SetElement{SetElement: nftables.SetElement{VerdictData: &expr.Verdict{Kind: expr.VerdictAccept}}}, "accept"},
+		{"jump", nftables.Set{DataType: nftables.TypeVerdict}, SetElement{SetElement: nftables.SetElement{VerdictData: &expr.Verdict{Kind: expr.VerdictJump, Chain: "chain1"}}}, "jump chain1"},
+	}
+	for _, tst := range tsts {
+		t.Run(tst.name, func(t *testing.T) {
+			st := tst.st
+			got := SetElementValueString(&Set{Set: &st}, tst.el)
+			if got != tst.want {
+				t.Errorf("got %q, want %q", got, tst.want)
+			}
+		})
+	}
+}
+
+func makeSetByteOrder(t udata.AttrType, bo udata.ByteOrder) []byte {
+	return append([]byte{byte(t), 4}, nlenc.Uint32Bytes(uint32(bo))...)
+}
+
+func TestSetIntervalElements(t *testing.T) {
+	cnt := &expr.Counter{Packets: 1}
+	got := setIntervalElements([]SetElement{
+		{SetElement: nftables.SetElement{Key: []byte{10, 1, 0, 0}, IntervalEnd: true}},
+		{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 0}}, Counter: cnt},
+		{SetElement: nftables.SetElement{Key: []byte{10, 2, 0, 0}}},
+		{SetElement: nftables.SetElement{Key: []byte{0, 0, 0, 0}, IntervalEnd: true}},
+	})
+
+	want := []SetElement{
+		{SetElement: nftables.SetElement{Key: []byte{10, 0, 0, 0}}, KeyEnd: []byte{10, 0, 255, 255}, Counter: cnt},
+		{SetElement: nftables.SetElement{Key: []byte{10, 2, 0, 0}}, KeyEnd: []byte{255, 255, 255, 255}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestChainPolicyString(t *testing.T) {
+	t.Run("defined", func(t *testing.T) {
+		p := nftables.ChainPolicyDrop
+		got := ChainPolicyString(&p)
+		want := "drop"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		p := nftables.ChainPolicy(42)
+		got := ChainPolicyString(&p)
+		want := "unknown(42)"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		got := ChainPolicyString(nil)
+		want := "accept"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestTableFamilyString(t *testing.T) {
+	t.Run("defined", func(t *testing.T) {
+		got := TableFamilyString(nftables.TableFamilyINet)
+		want := "inet"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		got := TableFamilyString(nftables.TableFamily(42))
+		want := "unknown(42)"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestTableFlagMaskString(t *testing.T) {
+	t.Run("single", func(t *testing.T) {
+		got := TableFlagMaskString(unix.NFT_TABLE_F_DORMANT)
+		want := "dormant"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		got := TableFlagMaskString(0)
+		want := ""
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestTableFlagString(t *testing.T) {
+	t.Run("unknown", func(t *testing.T) {
+		got := tableFlagString(1 << 20)
+		want := "unknown(20)"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		got := tableFlagString(0)
+		want := "none"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestHookString(t *testing.T) {
+	t.Run("input", func(t *testing.T) {
+		got := HookString(nftables.TableFamilyINet, nftables.ChainHookInput)
+		want := "input"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("arpOutput", func(t *testing.T) {
+		got := HookString(nftables.TableFamilyARP, 1)
+		want := "output"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("netdevEgress", func(t *testing.T) {
+		got := HookString(nftables.TableFamilyNetdev, 1)
+		want := "egress"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("unknownINet", func(t *testing.T) {
+		got := HookString(nftables.TableFamilyINet, nftables.ChainHook(42))
+		want := "unknown(42)"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("unknownFamily", func(t *testing.T) {
+		got := HookString(nftables.TableFamily(42), nftables.ChainHook(42))
+		want := "unknown(42)"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,765 @@
+package nftcollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink"
+	"github.com/tommie/prometheus-nftables-exporter/internal/nfmsg"
+	"golang.org/x/sys/unix"
+)
+
+// An NLConn is an nftables.Conn that also knows how to read things
+// the nftables package doesn't decode.
+type NLConn struct {
+	nftables.Conn
+}
+
+// Constants from include/uapi/linux/netfilter/nf_tables.h that are
+// not in x/sys/unix.
+const (
+	nftObjectCounter = 1
+	nftObjectQuota   = 2
+
+	nftaSetElemKeyEnd      = 0xa
+	nftaSetElemExpressions = 0xb
+)
+
+// A Quota is a named quota object.
+type Quota struct {
+	Table *nftables.Table
+	Name  string
+
+	Bytes    uint64 // The limit.
+	Consumed uint64
+	Flags    uint32 // Mask of NFT_QUOTA_F_*.
+}
+
+// GetObjects returns the named counters of the table. Unlike
+// nftables.Conn.GetObjects, this doesn't fail if the table contains
+// other kinds of objects.
+func (c *NLConn) GetObjects(t *nftables.Table) ([]nftables.Obj, error) {
+	var os []nftables.Obj
+	err := c.dumpObjects(t, nftObjectCounter, func(name string, ad *netlink.AttributeDecoder) error {
+		o := &nftables.CounterObj{Table: t, Name: name}
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.NFTA_COUNTER_BYTES:
+				o.Bytes = ad.Uint64()
+			case unix.NFTA_COUNTER_PACKETS:
+				o.Packets = ad.Uint64()
+			}
+		}
+		os = append(os, o)
+		return ad.Err()
+	})
+	return os, err
+}
+
+// GetQuotas returns the named quotas of the table.
+func (c *NLConn) GetQuotas(t *nftables.Table) ([]*Quota, error) {
+	var qs []*Quota
+	err := c.dumpObjects(t, nftObjectQuota, func(name string, ad *netlink.AttributeDecoder) error {
+		q := &Quota{Table: t, Name: name}
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.NFTA_QUOTA_BYTES:
+				q.Bytes = ad.Uint64()
+			case unix.NFTA_QUOTA_CONSUMED:
+				q.Consumed = ad.Uint64()
+			case unix.NFTA_QUOTA_FLAGS:
+				q.Flags = ad.Uint32()
+			}
+		}
+		qs = append(qs, q)
+		return ad.Err()
+	})
+	return qs, err
+}
+
+// A Set is a set, with information the nftables package doesn't
+// decode.
+type Set struct {
+	*nftables.Set
+
+	// UserData can be parsed with udata.UnmarshalSetAttr.
+	UserData []byte
+}
+
+// GetSets returns the sets of the table. Unlike
+// nftables.Conn.GetSets, this also returns user data, resolves
+// concatenated key types and doesn't confuse the key and data types
+// of verdict maps.
+func (c *NLConn) GetSets(t *nftables.Table) ([]*Set, error) {
+	ae := newAttrEncoder()
+	ae.String(unix.NFTA_SET_TABLE, t.Name)
+
+	msgs, err := c.dump(t.Family, unix.NFT_MSG_GETSET, ae)
+	if err != nil {
+		return nil, fmt.Errorf("listing sets: %w", err)
+	}
+
+	var sts []*Set
+	for _, msg := range msgs {
+		ad, err := nfmsg.NewDecoder(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		st := &Set{Set: &nftables.Set{Table: t}}
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.NFTA_SET_NAME:
+				st.Name = ad.String()
+			case unix.NFTA_SET_ID:
+				st.ID = ad.Uint32()
+			case unix.NFTA_SET_FLAGS:
+				flags := ad.Uint32()
+				st.Constant = flags&unix.NFT_SET_CONSTANT != 0
+				st.Anonymous = flags&unix.NFT_SET_ANONYMOUS != 0
+				st.Interval = flags&unix.NFT_SET_INTERVAL != 0
+				st.IsMap = flags&unix.NFT_SET_MAP != 0
+				st.HasTimeout = flags&unix.NFT_SET_TIMEOUT != 0
+			case unix.NFTA_SET_KEY_TYPE:
+				st.KeyType = setDatatype(ad.Uint32())
+			case unix.NFTA_SET_DATA_TYPE:
+				st.DataType = setDatatype(ad.Uint32())
+			case unix.NFTA_SET_TIMEOUT:
+				st.Timeout = time.Duration(ad.Uint64()) * time.Millisecond
+			case unix.NFTA_SET_USERDATA:
+				st.UserData = ad.Bytes()
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+
+		sts = append(sts, st)
+	}
+
+	return sts, nil
+}
+
+// setDatatypes are the datatypes known to nftables. Their magic
+// numbers are also their index.
+var setDatatypes = []nftables.SetDatatype{
+	nftables.TypeInvalid,
+	nftables.TypeVerdict,
+	nftables.TypeNFProto,
+	nftables.TypeBitmask,
+	nftables.TypeInteger,
+	nftables.TypeString,
+	nftables.TypeLLAddr,
+	nftables.TypeIPAddr,
+	nftables.TypeIP6Addr,
+	nftables.TypeEtherAddr,
+	nftables.TypeEtherType,
+	nftables.TypeARPOp,
+	nftables.TypeInetProto,
+	nftables.TypeInetService,
+	nftables.TypeICMPType,
+	nftables.TypeTCPFlag,
+	nftables.TypeDCCPPktType,
+	nftables.TypeMHType,
+	nftables.TypeTime,
+	nftables.TypeMark,
+	nftables.TypeIFIndex,
+	nftables.TypeARPHRD,
+	nftables.TypeRealm,
+	nftables.TypeClassID,
+	nftables.TypeUID,
+	nftables.TypeGID,
+	nftables.TypeCTState,
+	nftables.TypeCTDir,
+	nftables.TypeCTStatus,
+	nftables.TypeICMP6Type,
+	nftables.TypeCTLabel,
+	nftables.TypePktType,
+	nftables.TypeICMPCode,
+	nftables.TypeICMPV6Code,
+	nftables.TypeICMPXCode,
+	nftables.TypeDevGroup,
+	nftables.TypeDSCP,
+	nftables.TypeECN,
+	nftables.TypeFIBAddr,
+	nftables.TypeBoolean,
+	nftables.TypeCTEventBit,
+	nftables.TypeIFName,
+	nftables.TypeIGMPType,
+	nftables.TypeTimeDate,
+	nftables.TypeTimeHour,
+	nftables.TypeTimeDay,
+	nftables.TypeCGroupV2,
+}
+
+// nftDataVerdict is the datatype magic the kernel uses for verdict
+// map data (NFT_DATA_VERDICT).
+const nftDataVerdict = 0xffffff00
+
+// setDatatype returns the datatype of a set key or data magic
+// number. Concatenations are resolved into their parts.
+func setDatatype(magic uint32) nftables.SetDatatype {
+	if magic == nftDataVerdict {
+		return nftables.TypeVerdict
+	}
+	if int(magic) < len(setDatatypes) {
+		return setDatatypes[magic]
+	}
+
+	if dts := splitDatatypeMagic(magic); dts != nil {
+		if dt, err := nftables.ConcatSetType(dts...); err == nil {
+			return dt
+		}
+	}
+
+	dt := nftables.SetDatatype{Name: fmt.Sprintf("unknown(%d)", magic)}
+	dt.SetNFTMagic(magic)
+	return dt
+}
+
+// concatDatatypes returns the parts of a concatenated datatype, or
+// nil if it isn't a concatenation.
+func concatDatatypes(dt nftables.SetDatatype) []nftables.SetDatatype {
+	magic := dt.GetNFTMagic()
+	if magic == nftDataVerdict || int(magic) < len(setDatatypes) {
+		return nil
+	}
+	return splitDatatypeMagic(magic)
+}
+
+// splitDatatypeMagic returns the datatypes of a concatenation magic
+// number, first part first. Returns nil if any part is unknown.
+func splitDatatypeMagic(magic uint32) []nftables.SetDatatype {
+	var dts []nftables.SetDatatype
+	for m := magic; m != 0; m >>= nftables.SetConcatTypeBits {
+		i := int(m & nftables.SetConcatTypeMask)
+		if i >= len(setDatatypes) {
+			return nil
+		}
+		dts = append([]nftables.SetDatatype{setDatatypes[i]}, dts...)
+	}
+	return dts
+}
+
+// A SetElement is a set element, with its counter, if any.
+type SetElement struct {
+	nftables.SetElement
+
+	// KeyEnd is the end of a range in sets with concatenated
+	// intervals.
+	KeyEnd []byte
+
+	Counter *expr.Counter
+}
+
+// GetSetElements returns the elements of the set. Unlike
+// nftables.Conn.GetSetElements, this also returns element counters.
+func (c *NLConn) GetSetElements(s *nftables.Set) ([]SetElement, error) {
+	ae := newAttrEncoder()
+	ae.String(unix.NFTA_SET_ELEM_LIST_TABLE, s.Table.Name)
+	ae.String(unix.NFTA_SET_ELEM_LIST_SET, s.Name)
+
+	msgs, err := c.dump(s.Table.Family, unix.NFT_MSG_GETSETELEM, ae)
+	if err != nil {
+		return nil, fmt.Errorf("listing set elements: %w", err)
+	}
+
+	var els []SetElement
+	for _, msg := range msgs {
+		ad, err := nfmsg.NewDecoder(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		for ad.Next() {
+			if ad.Type() != unix.NFTA_SET_ELEM_LIST_ELEMENTS {
+				continue
+			}
+			ad.Nested(func(ad *netlink.AttributeDecoder) error {
+				for ad.Next() {
+					if ad.Type() != unix.NFTA_LIST_ELEM {
+						continue
+					}
+					var el SetElement
+					ad.Nested(el.decode)
+					els = append(els, el)
+				}
+				return nil
+			})
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return els, nil
+}
+
+// decode reads the attributes of a single set element.
+func (el *SetElement) decode(ad *netlink.AttributeDecoder) error {
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.NFTA_SET_ELEM_KEY:
+			ad.Nested(func(ad *netlink.AttributeDecoder) error {
+				el.Key = decodeDataValue(ad)
+				return nil
+			})
+		case nftaSetElemKeyEnd:
+			ad.Nested(func(ad *netlink.AttributeDecoder) error {
+				el.KeyEnd = decodeDataValue(ad)
+				return nil
+			})
+		case unix.NFTA_SET_ELEM_DATA:
+			ad.Nested(el.decodeData)
+		case unix.NFTA_SET_ELEM_FLAGS:
+			el.IntervalEnd = ad.Uint32()&unix.NFT_SET_ELEM_INTERVAL_END != 0
+		case unix.NFTA_SET_ELEM_TIMEOUT:
+			el.Timeout = time.Duration(ad.Uint64()) * time.Millisecond
+		case unix.NFTA_SET_ELEM_EXPR:
+			ad.Nested(el.decodeExpr)
+		case nftaSetElemExpressions:
+			ad.Nested(func(ad *netlink.AttributeDecoder) error {
+				for ad.Next() {
+					if ad.Type() == unix.NFTA_LIST_ELEM {
+						ad.Nested(el.decodeExpr)
+					}
+				}
+				return nil
+			})
+		}
+	}
+	return ad.Err()
+}
+
+// decodeData reads a map element value, which is either a value or
+// a verdict.
+func (el *SetElement) decodeData(ad *netlink.AttributeDecoder) error {
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.NFTA_DATA_VALUE:
+			el.Val = ad.Bytes()
+		case unix.NFTA_DATA_VERDICT:
+			v := &expr.Verdict{}
+			ad.Nested(func(ad *netlink.AttributeDecoder) error {
+				for ad.Next() {
+					switch ad.Type() {
+					case unix.NFTA_VERDICT_CODE:
+						v.Kind = expr.VerdictKind(ad.Int32())
+					case unix.NFTA_VERDICT_CHAIN:
+						v.Chain = ad.String()
+					}
+				}
+				return nil
+			})
+			el.VerdictData = v
+		}
+	}
+	return ad.Err()
+}
+
+// decodeExpr reads an element expression. Only counters are kept.
+func (el *SetElement) decodeExpr(ad *netlink.AttributeDecoder) error {
+	var name string
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.NFTA_EXPR_NAME:
+			name = ad.String()
+		case unix.NFTA_EXPR_DATA:
+			if name != "counter" {
+				continue
+			}
+			cnt := &expr.Counter{}
+			ad.Do(func(bs []byte) error {
+				return expr.Unmarshal(bs, cnt)
+			})
+			el.Counter = cnt
+		}
+	}
+	return ad.Err()
+}
+
+// decodeDataValue returns the value of a nested NFTA_DATA_VALUE.
+func decodeDataValue(ad *netlink.AttributeDecoder) []byte {
+	var bs []byte
+	for ad.Next() {
+		if ad.Type() == unix.NFTA_DATA_VALUE {
+			bs = ad.Bytes()
+		}
+	}
+	return bs
+}
+
+// A Rule is a rule, with the expressions the nftables package
+// doesn't decode.
+type Rule struct {
+	*nftables.Rule
+
+	// Others are the expressions missing from Rule.Exprs.
+	Others []OtherExpr
+}
+
+// An OtherExpr is an expression the nftables package can't decode.
+type OtherExpr struct {
+	// Index is the position in Rule.Exprs of the expression
+	// following this one.
+	Index int
+
+	Name string // E.g. "match".
+
+	// XT is the xtables extension of "match" and "target"
+	// expressions, as used by iptables-nft. Otherwise nil.
+	XT *XTInfo
+}
+
+// An XTInfo describes an xtables match or target.
+type XTInfo struct {
+	Name string // E.g. "comment".
+	Rev  uint32
+
+	// Info is the extension-specific struct, e.g. xt_comment_info.
+	Info []byte
+}
+
+// ListChains returns all chains. Unlike nftables.Conn.ListChains,
+// policies are decoded in network byte order, so accept isn't
+// unknown(16777216) on little-endian hosts.
+func (c *NLConn) ListChains() ([]*nftables.Chain, error) {
+	cns, err := c.Conn.ListChains()
+	if err != nil {
+		return nil, err
+	}
+	for _, cn := range cns {
+		if cn.Policy != nil {
+			p := nftables.ChainPolicy(binaryutil.BigEndian.Uint32(binaryutil.NativeEndian.PutUint32(uint32(*cn.Policy))))
+			cn.Policy = &p
+		}
+	}
+	return cns, nil
+}
+
+// GetRule returns the rules in the chain. Unlike
+// nftables.Conn.GetRule, this also returns expressions the nftables
+// package can't decode, and rules know their table family.
+func (c *NLConn) GetRule(t *nftables.Table, cn *nftables.Chain) ([]*Rule, error) {
+	ae := newAttrEncoder()
+	ae.String(unix.NFTA_RULE_TABLE, t.Name)
+	ae.String(unix.NFTA_RULE_CHAIN, cn.Name)
+
+	return c.getRules(t, ae, func(string) *nftables.Chain { return cn })
+}
+
+// GetTableRules returns the rules of all chains in the table, by
+// chain name, using a single dump.
+func (c *NLConn) GetTableRules(t *nftables.Table) (map[string][]*Rule, error) {
+	ae := newAttrEncoder()
+	ae.String(unix.NFTA_RULE_TABLE, t.Name)
+
+	cns := map[string]*nftables.Chain{}
+	rs, err := c.getRules(t, ae, func(name string) *nftables.Chain {
+		cn := cns[name]
+		if cn == nil {
+			cn = &nftables.Chain{Name: name, Table: t}
+			cns[name] = cn
+		}
+		return cn
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rsm := map[string][]*Rule{}
+	for _, r := range rs {
+		rsm[r.Chain.Name] = append(rsm[r.Chain.Name], r)
+	}
+	return rsm, nil
+}
+
+// getRules dumps the rules selected by the attributes. The chain of
+// each rule is looked up by name.
+func (c *NLConn) getRules(t *nftables.Table, ae *netlink.AttributeEncoder, chain func(string) *nftables.Chain) ([]*Rule, error) {
+	msgs, err := c.dump(t.Family, unix.NFT_MSG_GETRULE, ae)
+	if err != nil {
+		return nil, fmt.Errorf("listing rules: %w", err)
+	}
+
+	var rs []*Rule
+	for _, msg := range msgs {
+		ad, err := nfmsg.NewDecoder(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &Rule{Rule: &nftables.Rule{Table: t}}
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.NFTA_RULE_CHAIN:
+				r.Chain = chain(ad.String())
+			case unix.NFTA_RULE_HANDLE:
+				r.Handle = ad.Uint64()
+			case unix.NFTA_RULE_POSITION:
+				r.Position = ad.Uint64()
+			case unix.NFTA_RULE_EXPRESSIONS:
+				ad.Nested(func(ad *netlink.AttributeDecoder) error {
+					for ad.Next() {
+						if ad.Type() == unix.NFTA_LIST_ELEM {
+							ad.Nested(r.decodeExpr)
+						}
+					}
+					return nil
+				})
+			case unix.NFTA_RULE_USERDATA:
+				r.UserData = ad.Bytes()
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+		if r.Chain == nil {
+			r.Chain = chain("")
+		}
+
+		rs = append(rs, r)
+	}
+
+	return rs, nil
+}
+
+// decodeExpr reads a single rule expression, and appends it to
+// either Exprs or Others.
+func (r *Rule) decodeExpr(ad *netlink.AttributeDecoder) error {
+	var name string
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.NFTA_EXPR_NAME:
+			name = ad.String()
+		case unix.NFTA_EXPR_DATA:
+			e := newExpr(name)
+			if e == nil {
+				o := OtherExpr{Index: len(r.Exprs), Name: name}
+				if name == "match" || name == "target" {
+					o.XT = &XTInfo{}
+					ad.Nested(o.XT.decode)
+				}
+				r.Others = append(r.Others, o)
+				continue
+			}
+			ad.Do(func(bs []byte) error {
+				if err := expr.Unmarshal(bs, e); err != nil {
+					return err
+				}
+				// Verdicts are immediates writing to the verdict register.
+				if imm, ok := e.(*expr.Immediate); ok && imm.Register == unix.NFT_REG_VERDICT && len(imm.Data) == 0 {
+					e = &expr.Verdict{}
+					if err := expr.Unmarshal(bs, e); err != nil {
+						return err
+					}
+				}
+				r.Exprs = append(r.Exprs, e)
+				return nil
+			})
+		}
+	}
+	return ad.Err()
+}
+
+// decode reads the attributes of a match or target expression. The
+// attribute numbers are the same for both.
+func (xt *XTInfo) decode(ad *netlink.AttributeDecoder) error {
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.NFTA_MATCH_NAME:
+			xt.Name = ad.String()
+		case unix.NFTA_MATCH_REV:
+			xt.Rev = ad.Uint32()
+		case unix.NFTA_MATCH_INFO:
+			xt.Info = ad.Bytes()
+		}
+	}
+	return ad.Err()
+}
+
+// newExpr returns an empty expression of the given name, or nil if
+// the nftables package can't decode it.
+func newExpr(name string) expr.Any {
+	switch name {
+	case "bitwise":
+		return &expr.Bitwise{}
+	case "cmp":
+		return &expr.Cmp{}
+	case "counter":
+		return &expr.Counter{}
+	case "ct":
+		return &expr.Ct{}
+	case "dup":
+		return &expr.Dup{}
+	case "dynset":
+		return &expr.Dynset{}
+	case "fib":
+		return &expr.Fib{}
+	case "hash":
+		return &expr.Hash{}
+	case "immediate":
+		return &expr.Immediate{}
+	case "limit":
+		return &expr.Limit{}
+	case "log":
+		return &expr.Log{}
+	case "lookup":
+		return &expr.Lookup{}
+	case "masq":
+		return &expr.Masq{}
+	case "meta":
+		return &expr.Meta{}
+	case "nat":
+		return &expr.NAT{}
+	case "notrack":
+		return &expr.Notrack{}
+	case "numgen":
+		return &expr.Numgen{}
+	case "objref":
+		return &expr.Objref{}
+	case "payload":
+		return &expr.Payload{}
+	case "queue":
+		return &expr.Queue{}
+	case "range":
+		return &expr.Range{}
+	case "redir":
+		return &expr.Redir{}
+	case "reject":
+		return &expr.Reject{}
+	case "tproxy":
+		return &expr.TProxy{}
+	default:
+		return nil
+	}
+}
+
+// dumpObjects lists all objects of the given type in the table. The
+// function is called with a decoder for the object data.
+func (c *NLConn) dumpObjects(t *nftables.Table, typ uint32, f func(string, *netlink.AttributeDecoder) error) error {
+	ae := newAttrEncoder()
+	ae.String(unix.NFTA_OBJ_TABLE, t.Name)
+	ae.Uint32(unix.NFTA_OBJ_TYPE, typ)
+
+	msgs, err := c.dump(t.Family, unix.NFT_MSG_GETOBJ, ae)
+	if err != nil {
+		return fmt.Errorf("listing objects: %w", err)
+	}
+
+	for _, msg := range msgs {
+		ad, err := nfmsg.NewDecoder(msg)
+		if err != nil {
+			return err
+		}
+
+		var name string
+		var otyp uint32
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.NFTA_OBJ_NAME:
+				name = ad.String()
+			case unix.NFTA_OBJ_TYPE:
+				otyp = ad.Uint32()
+			case unix.NFTA_OBJ_DATA:
+				if otyp != typ {
+					// Older kernels ignore the type filter.
+					continue
+				}
+				ad.Nested(func(ad *netlink.AttributeDecoder) error {
+					return f(name, ad)
+				})
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetGen returns the generation ID of the rule set. It changes on
+// every transaction.
+func (c *NLConn) GetGen() (uint32, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | unix.NFT_MSG_GETGEN),
+			Flags: netlink.Request,
+		},
+		Data: []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, 0},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range msgs {
+		if msg.Header.Type != netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES<<8)|unix.NFT_MSG_NEWGEN) {
+			continue
+		}
+
+		ad, err := nfmsg.NewDecoder(msg)
+		if err != nil {
+			return 0, err
+		}
+		for ad.Next() {
+			if ad.Type() == unix.NFTA_GEN_ID {
+				return ad.Uint32(), nil
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return 0, fmt.Errorf("no generation ID in response")
+}
+
+// dump sends an NFTables dump request and returns the responses.
+func (c *NLConn) dump(tf nftables.TableFamily, msgType uint16, ae *netlink.AttributeEncoder) ([]netlink.Message, error) {
+	data, err := ae.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType((unix.NFNL_SUBSYS_NFTABLES << 8) | msgType),
+			Flags: netlink.Request | netlink.Acknowledge | netlink.Dump,
+		},
+		Data: append([]byte{uint8(tf), unix.NFNETLINK_V0, 0, 0}, data...),
+	})
+}
+
+// dial opens a netlink connection, honoring TestDial and NetNS like
+// nftables.Conn does.
+func (c *NLConn) dial() (*netlink.Conn, error) {
+	if c.TestDial != nil {
+		return dialExchange(exchangeFunc(c.TestDial)), nil
+	}
+
+	return netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: c.NetNS})
+}
+
+// newAttrEncoder returns an encoder using network byte order.
+func newAttrEncoder() *netlink.AttributeEncoder {
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	return ae
+}
@@ -0,0 +1,205 @@
+package nftcollector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// recordingVersion is the version of the recording file format. It
+// is bumped on incompatible changes, and other versions are refused.
+const recordingVersion = 1
+
+// A Recording holds the netlink requests of a collection, and the
+// responses from the kernel. It is written by "promnftd record", and
+// served by a replay connection, so the decoding and collection of a
+// real rule set can be reproduced without privileges.
+type Recording struct {
+	Version   int                `json:"version"`
+	Exchanges []RecordedExchange `json:"exchanges"`
+}
+
+// A RecordedExchange is a request and its responses, or the error
+// number the kernel returned.
+type RecordedExchange struct {
+	Request   RecordedMessage   `json:"request"`
+	Responses []RecordedMessage `json:"responses,omitempty"`
+	Errno     unix.Errno        `json:"errno,omitempty"`
+}
+
+// A RecordedMessage is a netlink message, without sequence number
+// and port ID. The final message of a multi-part response is not
+// included.
+type RecordedMessage struct {
+	Type  netlink.HeaderType  `json:"type"`
+	Flags netlink.HeaderFlags `json:"flags"`
+	Data  []byte              `json:"data"`
+}
+
+// newRecordedMessage returns the message, without sequence number and
+// port ID.
+func newRecordedMessage(msg netlink.Message) RecordedMessage {
+	return RecordedMessage{Type: msg.Header.Type, Flags: msg.Header.Flags, Data: msg.Data}
+}
+
+// A Recorder forwards netlink requests to the kernel, and records
+// them. Repeated requests are only recorded once.
+type Recorder struct {
+	dial func() (*netlink.Conn, error)
+
+	mu  sync.Mutex
+	rec Recording
+}
+
+// NewRecorder creates a recorder sending requests through connections
+// from dial.
+func NewRecorder(dial func() (*netlink.Conn, error)) *Recorder {
+	return &Recorder{dial: dial, rec: Recording{Version: recordingVersion}}
+}
+
+// Exchange is to be used as nftables.Conn.TestDial.
+func (r *Recorder) Exchange(reqs []netlink.Message) ([]netlink.Message, error) {
+	if len(reqs) != 1 {
+		return nil, fmt.Errorf("recording supports only single requests, got %d", len(reqs))
+	}
+	req := reqs[0]
+
+	conn, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	msgs, err := conn.Execute(netlink.Message{Header: netlink.Header{Type: req.Header.Type, Flags: req.Header.Flags}, Data: req.Data})
+	ex := RecordedExchange{Request: newRecordedMessage(req)}
+	if err != nil {
+		if !errors.As(err, &ex.Errno) {
+			return nil, err
+		}
+	}
+	for _, msg := range msgs {
+		ex.Responses = append(ex.Responses, newRecordedMessage(msg))
+	}
+
+	r.mu.Lock()
+	if r.rec.find(req) == nil {
+		r.rec.Exchanges = append(r.rec.Exchanges, ex)
+	}
+	r.mu.Unlock()
+
+	return ex.reply(req)
+}
+
+// Recording returns what has been recorded so far.
+func (r *Recorder) Recording() Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.rec
+	rec.Exchanges = append([]RecordedExchange(nil), r.rec.Exchanges...)
+	return rec
+}
+
+// reply returns the recorded response to the request, with the
+// sequence number and port ID of the request.
+func (ex *RecordedExchange) reply(req netlink.Message) ([]netlink.Message, error) {
+	if ex.Errno != 0 {
+		return errorReply(int(ex.Errno), req), nil
+	}
+
+	var msgs []netlink.Message
+	for _, rm := range ex.Responses {
+		msgs = append(msgs, netlink.Message{
+			Header: netlink.Header{Type: rm.Type, Flags: rm.Flags, Sequence: req.Header.Sequence, PID: req.Header.PID},
+			Data:   rm.Data,
+		})
+	}
+	if req.Header.Flags&netlink.Dump == 0 {
+		return msgs, nil
+	}
+	return multipartReply(append(msgs, netlink.Message{Header: netlink.Header{Sequence: req.Header.Sequence, PID: req.Header.PID}})), nil
+}
+
+// A replayer serves netlink requests from a recording.
+type replayer struct {
+	rec Recording
+}
+
+// NewReplayConn creates a connection serving requests from the
+// recording. Requests that are not in the recording fail.
+func NewReplayConn(rec Recording) *NLConn {
+	r := &replayer{rec: rec}
+	return &NLConn{nftables.Conn{TestDial: r.Exchange}}
+}
+
+// Exchange is to be used as nftables.Conn.TestDial.
+// Requests are matched by type and data.
+func (r *replayer) Exchange(reqs []netlink.Message) ([]netlink.Message, error) {
+	if len(reqs) != 1 {
+		return nil, fmt.Errorf("replaying supports only single requests, got %d", len(reqs))
+	}
+	req := reqs[0]
+
+	ex := r.rec.find(req)
+	if ex == nil {
+		return nil, fmt.Errorf("request not in recording: type 0x%x, data %x", req.Header.Type, req.Data)
+	}
+	return ex.reply(req)
+}
+
+// find returns the exchange with the same request type and data, or
+// nil.
+func (rec *Recording) find(req netlink.Message) *RecordedExchange {
+	for i := range rec.Exchanges {
+		ex := &rec.Exchanges[i]
+		if ex.Request.Type == req.Header.Type && bytes.Equal(ex.Request.Data, req.Data) {
+			return ex
+		}
+	}
+	return nil
+}
+
+// ReadRecording reads a recording, checking its version.
+func ReadRecording(r io.Reader) (Recording, error) {
+	var rec Recording
+	if err := json.NewDecoder(r).Decode(&rec); err != nil {
+		return Recording{}, err
+	}
+	if rec.Version != recordingVersion {
+		return Recording{}, fmt.Errorf("unsupported recording version %d, want %d", rec.Version, recordingVersion)
+	}
+	return rec, nil
+}
+
+// ReadRecordingFile reads a recording from a file.
+func ReadRecordingFile(path string) (Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Recording{}, err
+	}
+	defer f.Close()
+
+	rec, err := ReadRecording(f)
+	if err != nil {
+		return Recording{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return rec, nil
+}
+
+// WriteRecording writes a recording as indented JSON.
+func WriteRecording(w io.Writer, rec Recording) error {
+	bs, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
+}
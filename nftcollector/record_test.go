@@ -0,0 +1,258 @@
+package nftcollector
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nltest"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+var updateGolden = flag.Bool("update", false, "Update golden files in testdata.")
+
+func TestRecordReplay(t *testing.T) {
+	rec := NewRecorder(func() (*netlink.Conn, error) { return nltest.Dial(fakeKernel), nil })
+	collectText(t, newCollector(&NLConn{nftables.Conn{TestDial: rec.Exchange}}, allFilter, allFilter, allFilter, allFilter, RuleTextNone, allFilter, 100, false))
+
+	var buf bytes.Buffer
+	if err := WriteRecording(&buf, rec.Recording()); err != nil {
+		t.Fatalf("WriteRecording failed: %v", err)
+	}
+	got, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecording failed: %v", err)
+	}
+
+	want := collectText(t, newCollector(&NLConn{nftables.Conn{TestDial: fakeKernel}}, allFilter, allFilter, allFilter, allFilter, RuleTextNone, allFilter, 100, false))
+	if got := collectText(t, newCollector(NewReplayConn(got), allFilter, allFilter, allFilter, allFilter, RuleTextNone, allFilter, 100, false)); got != want {
+		t.Errorf("replayed collection: got\n%s\nwant\n%s", got, want)
+	}
+	for _, s := range []string{`nftables_rule_packet_count{chain="input",comment="allow ssh",family="inet",table="filter"} 42`, `nftables_set_element_packet_count{element="10.0.0.1",family="inet",set="set1",table="filter"} 1`} {
+		if !strings.Contains(want, s) {
+			t.Errorf("collection: want %q, got\n%s", s, want)
+		}
+	}
+}
+
+func TestReadRecording(t *testing.T) {
+	if _, err := ReadRecording(strings.NewReader(`{"version": 1, "exchanges": []}`)); err != nil {
+		t.Errorf("ReadRecording failed: %v", err)
+	}
+	if _, err := ReadRecording(strings.NewReader(`{"version": 2, "exchanges": []}`)); err == nil {
+		t.Errorf("ReadRecording version 2: got nil, want error")
+	}
+}
+
+func TestReplayConn(t *testing.T) {
+	rec := NewRecorder(func() (*netlink.Conn, error) {
+		return nltest.Dial(func(reqs []netlink.Message) ([]netlink.Message, error) {
+			return nltest.Error(int(unix.ENOENT), reqs)
+		}), nil
+	})
+	conn := &NLConn{nftables.Conn{TestDial: rec.Exchange}}
+	tbl := &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}
+	if _, err := conn.GetSets(tbl); errnoString(err) != "ENOENT" {
+		t.Fatalf("GetSets err: got %v, want ENOENT", err)
+	}
+
+	replay := NewReplayConn(rec.Recording())
+	if _, err := replay.GetSets(tbl); errnoString(err) != "ENOENT" {
+		t.Errorf("replayed GetSets err: got %v, want ENOENT", err)
+	}
+	if _, err := replay.GetSets(&nftables.Table{Name: "table2", Family: nftables.TableFamilyINet}); err == nil || !strings.Contains(err.Error(), "not in recording") {
+		t.Errorf("replayed GetSets err: got %v, want not in recording", err)
+	}
+}
+
+func TestReplayConnEmptyDump(t *testing.T) {
+	rec := NewRecorder(func() (*netlink.Conn, error) {
+		return nltest.Dial(func(reqs []netlink.Message) ([]netlink.Message, error) {
+			// What the kernel sends for a table without sets.
+			return []netlink.Message{{
+				Header: netlink.Header{Type: netlink.Done, Flags: netlink.Multi, Sequence: reqs[0].Header.Sequence, PID: reqs[0].Header.PID},
+				Data:   make([]byte, 4),
+			}}, nil
+		}), nil
+	})
+	conn := &NLConn{nftables.Conn{TestDial: rec.Exchange}}
+	tbl := &nftables.Table{Name: "table1", Family: nftables.TableFamilyINet}
+	if got, err := conn.GetSets(tbl); err != nil || len(got) != 0 {
+		t.Fatalf("GetSets: got %v, %v, want no sets", got, err)
+	}
+
+	replay := NewReplayConn(rec.Recording())
+	if got, err := replay.GetSets(tbl); err != nil || len(got) != 0 {
+		t.Errorf("replayed GetSets: got %v, %v, want no sets", got, err)
+	}
+}
+
+// TestReplayGolden replays the recordings in testdata, and compares
+// the collections with the golden files. Run with -update to write
+// them.
+func TestReplayGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.recording.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no recordings in testdata")
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".recording.json")
+		t.Run(name, func(t *testing.T) {
+			rec, err := ReadRecordingFile(path)
+			if err != nil {
+				t.Fatalf("ReadRecordingFile failed: %v", err)
+			}
+			got := collectText(t, newCollector(NewReplayConn(rec), allFilter, allFilter, allFilter, allFilter, RuleTextPlain, allFilter, 100, false))
+
+			goldenPath := filepath.Join("testdata", name+".metrics")
+			if *updateGolden {
+				if err := ioutil.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("collection of %s: got\n%s\nwant\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+// collectText returns the metrics of a collector, one sorted line
+// per sample.
+func collectText(t *testing.T, coll prometheus.Collector) string {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(coll)
+	fams, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+
+	var lines []string
+	for _, mf := range fams {
+		for _, m := range mf.Metric {
+			var lps []string
+			for _, lp := range m.Label {
+				lps = append(lps, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+			}
+			v := m.GetGauge().GetValue() + m.GetCounter().GetValue() + m.GetUntyped().GetValue()
+			lines = append(lines, fmt.Sprintf("%s{%s} %v", mf.GetName(), strings.Join(lps, ","), v))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// fakeKernel answers the requests of a collection with a small rule
+// set: an inet table with a base chain, an iptables-nft rule, a named
+// counter and a set with an element counter.
+func fakeKernel(reqs []netlink.Message) ([]netlink.Message, error) {
+	req := reqs[0]
+	inet := netlink.Message{Header: req.Header, Data: append([]byte{unix.NFPROTO_INET}, req.Data[1:]...)}
+
+	switch req.Header.Type & 0xFF {
+	case unix.NFT_MSG_GETGEN:
+		return []netlink.Message{
+			makeNLReply(req, unix.NFT_MSG_NEWGEN, func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(unix.NFTA_GEN_ID, 42)
+			}),
+		}, nil
+
+	case unix.NFT_MSG_GETTABLE:
+		return makeNLDump(req,
+			makeNLReply(inet, unix.NFT_MSG_NEWTABLE, func(ae *netlink.AttributeEncoder) {
+				ae.String(unix.NFTA_TABLE_NAME, "filter")
+				ae.Uint32(unix.NFTA_TABLE_FLAGS, 0)
+			}),
+		)
+
+	case unix.NFT_MSG_GETCHAIN:
+		return makeNLDump(req,
+			makeNLReply(inet, unix.NFT_MSG_NEWCHAIN, func(ae *netlink.AttributeEncoder) {
+				ae.String(unix.NFTA_CHAIN_TABLE, "filter")
+				ae.String(unix.NFTA_CHAIN_NAME, "input")
+				ae.Nested(unix.NFTA_CHAIN_HOOK, func(ae *netlink.AttributeEncoder) error {
+					ae.Uint32(unix.NFTA_HOOK_HOOKNUM, uint32(nftables.ChainHookInput))
+					ae.Uint32(unix.NFTA_HOOK_PRIORITY, 0)
+					return nil
+				})
+				ae.Uint32(unix.NFTA_CHAIN_POLICY, uint32(nftables.ChainPolicyDrop))
+				ae.String(unix.NFTA_CHAIN_TYPE, string(nftables.ChainTypeFilter))
+			}),
+		)
+
+	case unix.NFT_MSG_GETRULE:
+		return makeNLDump(req,
+			makeNLReply(req, unix.NFT_MSG_NEWRULE, func(ae *netlink.AttributeEncoder) {
+				ae.String(unix.NFTA_RULE_TABLE, "filter")
+				ae.String(unix.NFTA_RULE_CHAIN, "input")
+				ae.Uint64(unix.NFTA_RULE_HANDLE, 3)
+				ae.Bytes(unix.NFTA_RULE_EXPRESSIONS, xtCommentRuleExprs)
+			}),
+		)
+
+	case unix.NFT_MSG_GETOBJ:
+		return makeNLDump(req,
+			makeNLObj(req, "counter1", nftObjectCounter, func(ae *netlink.AttributeEncoder) {
+				ae.Uint64(unix.NFTA_COUNTER_BYTES, 4711)
+				ae.Uint64(unix.NFTA_COUNTER_PACKETS, 42)
+			}),
+		)
+
+	case unix.NFT_MSG_GETSET:
+		return makeNLDump(req,
+			makeNLReply(req, unix.NFT_MSG_NEWSET, func(ae *netlink.AttributeEncoder) {
+				ae.String(unix.NFTA_SET_TABLE, "filter")
+				ae.String(unix.NFTA_SET_NAME, "set1")
+				ae.Uint32(unix.NFTA_SET_KEY_TYPE, nftables.TypeIPAddr.GetNFTMagic())
+			}),
+		)
+
+	case unix.NFT_MSG_GETSETELEM:
+		return makeNLDump(req,
+			makeNLReply(req, unix.NFT_MSG_NEWSETELEM, func(ae *netlink.AttributeEncoder) {
+				ae.String(unix.NFTA_SET_ELEM_LIST_TABLE, "filter")
+				ae.String(unix.NFTA_SET_ELEM_LIST_SET, "set1")
+				ae.Nested(unix.NFTA_SET_ELEM_LIST_ELEMENTS, func(ae *netlink.AttributeEncoder) error {
+					ae.Nested(unix.NFTA_LIST_ELEM, func(ae *netlink.AttributeEncoder) error {
+						ae.Nested(unix.NFTA_SET_ELEM_KEY, func(ae *netlink.AttributeEncoder) error {
+							ae.Bytes(unix.NFTA_DATA_VALUE, []byte{10, 0, 0, 1})
+							return nil
+						})
+						ae.Nested(unix.NFTA_SET_ELEM_EXPR, func(ae *netlink.AttributeEncoder) error {
+							ae.String(unix.NFTA_EXPR_NAME, "counter")
+							ae.Nested(unix.NFTA_EXPR_DATA, func(ae *netlink.AttributeEncoder) error {
+								ae.Uint64(unix.NFTA_COUNTER_BYTES, 2)
+								ae.Uint64(unix.NFTA_COUNTER_PACKETS, 1)
+								return nil
+							})
+							return nil
+						})
+						return nil
+					})
+					return nil
+				})
+			}),
+		)
+
+	default:
+		return nltest.Error(int(unix.EOPNOTSUPP), reqs)
+	}
+}
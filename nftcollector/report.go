@@ -0,0 +1,204 @@
+package nftcollector
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+)
+
+// A RulesetReport tells what a collector exports from each object in
+// the rule set, and why objects are left out. The reasons are those
+// of the nftables_ineligible_* metrics.
+type RulesetReport struct {
+	Tables []*TableReport `json:"tables"`
+}
+
+// A TableReport describes a table and its contents.
+type TableReport struct {
+	Family   string         `json:"family"`
+	Name     string         `json:"name"`
+	Flags    string         `json:"flags"`
+	Counters []ObjectReport `json:"counters"`
+	Quotas   []ObjectReport `json:"quotas"`
+	Sets     []SetReport    `json:"sets"`
+	Chains   []*ChainReport `json:"chains"`
+	Errors   []string       `json:"errors,omitempty"`
+}
+
+// An ObjectReport describes a named counter or quota.
+type ObjectReport struct {
+	Name     string `json:"name"`
+	Exported bool   `json:"exported"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// A SetReport describes a set. Exported tells if the set metadata is
+// exported. If element counters aren't, Reason tells why.
+type SetReport struct {
+	Name            string `json:"name"`
+	Size            int    `json:"size"`
+	ElementCounters int    `json:"element_counters"`
+	Exported        bool   `json:"exported"`
+	Reason          string `json:"reason,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// A ChainReport describes a chain and its rules. Type is empty for
+// regular chains, and then Hook, Policy and Priority are meaningless.
+type ChainReport struct {
+	Name     string       `json:"name"`
+	Type     string       `json:"type,omitempty"`
+	Hook     string       `json:"hook"`
+	Policy   string       `json:"policy"`
+	Priority int32        `json:"priority"`
+	Rules    []RuleReport `json:"rules"`
+}
+
+// A RuleReport describes a rule.
+type RuleReport struct {
+	Handle   uint64 `json:"handle"`
+	Comment  string `json:"comment"`
+	Counter  bool   `json:"counter"`
+	Exported bool   `json:"exported"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report lists the rule set, using the same decisions as Collect. It
+// doesn't update any metrics.
+func (c *Collector) Report() (*RulesetReport, error) {
+	cc := *c
+	cc.conn = collectionConn(c.conn)
+	c = &cc
+
+	ts, err := c.conn.ListTables()
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %v", err)
+	}
+
+	cns, err := c.conn.ListChains()
+	if err != nil {
+		return nil, fmt.Errorf("listing chains: %v", err)
+	}
+
+	rep := &RulesetReport{Tables: []*TableReport{}}
+	trs := map[tableKey]*TableReport{}
+	for _, t := range ts {
+		tr := c.reportTable(t)
+		rep.Tables = append(rep.Tables, tr)
+		trs[tableKey{t.Family, t.Name}] = tr
+	}
+
+	rules := map[tableKey]*tableRules{}
+	for _, cn := range cns {
+		tr := trs[tableKey{cn.Table.Family, cn.Table.Name}]
+		if tr == nil {
+			// The table was created after listing tables.
+			continue
+		}
+		tr.Chains = append(tr.Chains, c.reportChain(cn, c.tableRules(rules, cn.Table), tr))
+	}
+
+	return rep, nil
+}
+
+// reportTable describes a table, except its chains.
+func (c *Collector) reportTable(t *nftables.Table) *TableReport {
+	fam := TableFamilyString(t.Family)
+	tr := &TableReport{
+		Family:   fam,
+		Name:     t.Name,
+		Flags:    TableFlagMaskString(t.Flags),
+		Counters: []ObjectReport{},
+		Quotas:   []ObjectReport{},
+		Sets:     []SetReport{},
+		Chains:   []*ChainReport{},
+	}
+
+	if os, err := c.conn.GetObjects(t); err != nil {
+		tr.Errors = append(tr.Errors, fmt.Sprintf("listing objects: %v", err))
+	} else {
+		for _, o := range os {
+			if cnt, ok := o.(*nftables.CounterObj); ok {
+				reason := c.objectReason(KindCounters, fam, t.Name, cnt.Name)
+				tr.Counters = append(tr.Counters, ObjectReport{Name: cnt.Name, Exported: reason == "", Reason: reason})
+			}
+		}
+	}
+
+	if qs, err := c.conn.GetQuotas(t); err != nil {
+		tr.Errors = append(tr.Errors, fmt.Sprintf("listing quotas: %v", err))
+	} else {
+		for _, q := range qs {
+			reason := c.objectReason(KindQuotas, fam, t.Name, q.Name)
+			tr.Quotas = append(tr.Quotas, ObjectReport{Name: q.Name, Exported: reason == "", Reason: reason})
+		}
+	}
+
+	if sts, err := c.conn.GetSets(t); err != nil {
+		tr.Errors = append(tr.Errors, fmt.Sprintf("listing sets: %v", err))
+	} else {
+		for _, st := range sts {
+			tr.Sets = append(tr.Sets, c.reportSet(fam, t, st))
+		}
+	}
+
+	return tr
+}
+
+// reportSet describes a set, like collectSet exports it.
+func (c *Collector) reportSet(family string, t *nftables.Table, st *Set) SetReport {
+	sr := SetReport{Name: st.Name}
+	if sr.Reason = c.objectReason(KindSets, family, t.Name, st.Name); sr.Reason != "" {
+		return sr
+	}
+	sr.Exported = true
+
+	els, err := c.conn.GetSetElements(st.Set)
+	if err != nil {
+		sr.Reason = "elements-error"
+		sr.Error = err.Error()
+		return sr
+	}
+	sr.Size = len(els)
+
+	if c.setElementFilter(st.Name) {
+		var cels []SetElement
+		cels, sr.Reason = c.setElementCounters(st, els)
+		sr.ElementCounters = len(cels)
+	}
+
+	return sr
+}
+
+// reportChain describes a chain, like collectChain exports it.
+func (c *Collector) reportChain(cn *nftables.Chain, rules *tableRules, tr *TableReport) *ChainReport {
+	cr := &ChainReport{
+		Name:     cn.Name,
+		Type:     string(cn.Type),
+		Hook:     HookString(cn.Table.Family, cn.Hooknum),
+		Policy:   ChainPolicyString(cn.Policy),
+		Priority: int32(cn.Priority),
+		Rules:    []RuleReport{},
+	}
+
+	if rules.err != nil {
+		tr.Errors = append(tr.Errors, fmt.Sprintf("listing rules of chain %s: %v", cn.Name, rules.err))
+		return cr
+	}
+
+	for _, r := range rules.rules[cn.Name] {
+		rr := RuleReport{Handle: r.Handle, Counter: ruleCounter(r.Rule) != nil}
+		rr.Comment, _ = RuleComment(r)
+
+		_, reason, err := c.ruleCounts(cn.Table, r)
+		rr.Exported = reason == "" && err == nil
+		rr.Reason = reason
+		if err != nil {
+			rr.Error = err.Error()
+		}
+		cr.Rules = append(cr.Rules, rr)
+	}
+
+	return cr
+}
@@ -0,0 +1,98 @@
+package nftcollector
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNFTCollectorReport(t *testing.T) {
+	table := &nftables.Table{Name: "filter", Family: nftables.TableFamilyINet}
+	conn := &fakeNFTConn{
+		tables: []*nftables.Table{table},
+		chains: []*nftables.Chain{
+			{Name: "input", Table: table, Hooknum: nftables.ChainHookInput, Type: nftables.ChainTypeFilter},
+		},
+		objs: map[string][]nftables.Obj{
+			"filter": {
+				&nftables.CounterObj{Name: "web"},
+				&nftables.CounterObj{Name: "db"},
+			},
+		},
+		sets: map[string][]*Set{
+			"filter": {
+				{Set: &nftables.Set{Name: "allow", KeyType: nftables.TypeIPAddr}},
+				{Set: &nftables.Set{Name: "deny", KeyType: nftables.TypeIPAddr}},
+			},
+		},
+		setEls: map[string][]SetElement{
+			"allow": {SetElement{}, SetElement{}},
+		},
+		rules: map[string][]*Rule{
+			"filter/input": {
+				{Rule: &nftables.Rule{Table: table, Chain: &nftables.Chain{Name: "input"}, Handle: 1,
+					Exprs:    []expr.Any{&expr.Counter{}},
+					UserData: makeRuleComment("web")}},
+				{Rule: &nftables.Rule{Table: table, Chain: &nftables.Chain{Name: "input"}, Handle: 2,
+					Exprs: []expr.Any{&expr.Counter{}}}},
+				{Rule: &nftables.Rule{Table: table, Chain: &nftables.Chain{Name: "input"}, Handle: 3,
+					UserData: makeRuleComment("web without counter")}},
+				{Rule: &nftables.Rule{Table: table, Chain: &nftables.Chain{Name: "input"}, Handle: 4,
+					Exprs:    []expr.Any{&expr.Counter{}},
+					UserData: makeRuleComment("db")}},
+			},
+		},
+	}
+	web := func(s string) bool { return strings.HasPrefix(s, "web") }
+	c := newCollector(conn, web, web, func(s string) bool { return s == "allow" }, allFilter, RuleTextNone, noneFilter, 0, false)
+
+	ineligible := c.metrics.IneligibleRules.WithLabelValues("inet", "filter", "comment-filter")
+	before := testutil.ToFloat64(ineligible)
+
+	got, err := c.Report()
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+
+	want := &RulesetReport{
+		Tables: []*TableReport{{
+			Family: "inet",
+			Name:   "filter",
+			Counters: []ObjectReport{
+				{Name: "web", Exported: true},
+				{Name: "db", Reason: "name-filter"},
+			},
+			Quotas: []ObjectReport{},
+			Sets: []SetReport{
+				{Name: "allow", Size: 2, Exported: true},
+				{Name: "deny", Reason: "name-filter"},
+			},
+			Chains: []*ChainReport{{
+				Name:   "input",
+				Type:   "filter",
+				Hook:   "input",
+				Policy: "accept",
+				Rules: []RuleReport{
+					{Handle: 1, Comment: "web", Counter: true, Exported: true},
+					{Handle: 2, Counter: true, Reason: "no-comment"},
+					{Handle: 3, Comment: "web without counter", Reason: "no-counter"},
+					{Handle: 4, Comment: "db", Counter: true, Reason: "comment-filter"},
+				},
+			}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.MarshalIndent(got, "", "  ")
+		wantJSON, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("Report: got %s, want %s", gotJSON, wantJSON)
+	}
+
+	if got := testutil.ToFloat64(ineligible) - before; got != 0 {
+		t.Errorf("IneligibleRules comment-filter: got %v, want 0", got)
+	}
+}
@@ -11,3 +11,23 @@ func (Comment) xxx_IsNFTUDataAttr() {}
 type UnknownAttr []byte
 
 func (UnknownAttr) xxx_IsNFTUDataAttr() {}
+
+// A ByteOrder is the byte order of a set key or data type. It
+// corresponds to enum byteorder in nftables.
+type ByteOrder uint32
+
+const (
+	ByteOrderInvalid ByteOrder = iota
+	ByteOrderHostEndian
+	ByteOrderBigEndian
+)
+
+// KeyByteOrder is the byte order of the keys of a set.
+type KeyByteOrder ByteOrder
+
+func (KeyByteOrder) xxx_IsNFTUDataAttr() {}
+
+// DataByteOrder is the byte order of the data of a map.
+type DataByteOrder ByteOrder
+
+func (DataByteOrder) xxx_IsNFTUDataAttr() {}
@@ -6,6 +6,8 @@ package udata
 
 import (
 	"fmt"
+
+	"github.com/mdlayher/netlink/nlenc"
 )
 
 // An Unmarshaller can split udata into a stream of attributes.
@@ -125,6 +127,31 @@ func UnmarshalRuleAttr(t AttrType, bs []byte) (Attr, error) {
 	}
 }
 
+// UnmarshalSetAttr can read a user data attribute coming from a set.
+func UnmarshalSetAttr(t AttrType, bs []byte) (Attr, error) {
+	switch t {
+	case SetKeyByteOrder:
+		bo, err := unmarshalUint32Attr(bs)
+		return KeyByteOrder(bo), err
+	case SetDataByteOrder:
+		bo, err := unmarshalUint32Attr(bs)
+		return DataByteOrder(bo), err
+	case SetComment:
+		return unmarshalCommentAttr(bs)
+	default:
+		return UnknownAttr(bs), nil
+	}
+}
+
+// unmarshalUint32Attr reads an integer. They are stored in host byte
+// order.
+func unmarshalUint32Attr(bs []byte) (uint32, error) {
+	if len(bs) != 4 {
+		return 0, fmt.Errorf("invalid uint32 length: %d bytes", len(bs))
+	}
+	return nlenc.Uint32(bs), nil
+}
+
 func unmarshalCommentAttr(bs []byte) (Attr, error) {
 	if len(bs) == 0 || bs[len(bs)-1] != 0 {
 		return nil, fmt.Errorf("incomplete string data")
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/mdlayher/netlink/nlenc"
 )
 
 func ExampleUnmarshaller() {
@@ -118,3 +120,48 @@ func TestUnmarshalRuleAttr(t *testing.T) {
 		}
 	})
 }
+
+func TestUnmarshalSetAttr(t *testing.T) {
+	t.Run("keyByteOrder", func(t *testing.T) {
+		got, err := UnmarshalSetAttr(SetKeyByteOrder, nlenc.Uint32Bytes(uint32(ByteOrderBigEndian)))
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+
+		want := KeyByteOrder(ByteOrderBigEndian)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("dataByteOrder", func(t *testing.T) {
+		got, err := UnmarshalSetAttr(SetDataByteOrder, nlenc.Uint32Bytes(uint32(ByteOrderHostEndian)))
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+
+		want := DataByteOrder(ByteOrderHostEndian)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("shortByteOrder", func(t *testing.T) {
+		_, err := UnmarshalSetAttr(SetKeyByteOrder, []byte{1})
+		if err == nil {
+			t.Fatalf("succeeded when it shouldn't")
+		}
+	})
+
+	t.Run("comment", func(t *testing.T) {
+		got, err := UnmarshalSetAttr(SetComment, []byte{'a', 'b', 'c', 0})
+		if err != nil {
+			t.Fatalf("failed: %v", err)
+		}
+
+		want := Comment("abc")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+}